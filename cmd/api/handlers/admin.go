@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"github.com/labstack/echo"
+	"net/http"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+)
+
+const HeaderAdminToken = "X-Admin-Token"
+
+// AdminOnly lets a request through if it carries token in the
+// X-Admin-Token header or comes from a user with the admin role. An empty
+// token matches nothing.
+func AdminOnly(token string, policy *auth.Policy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			got := ctx.Request().Header.Get(HeaderAdminToken)
+			if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return next(ctx)
+			}
+			switch err := policy.Admin(ctx.Request().Context()); err {
+			case nil:
+				return next(ctx)
+			case auth.ErrUnauthenticated, auth.ErrForbidden:
+				return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: "Admin only"})
+			default:
+				return errorJSON(ctx, http.StatusInternalServerError, "Can't check permissions", err)
+			}
+		}
+	}
+}
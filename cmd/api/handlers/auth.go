@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+	"time"
+)
+
+type Auth struct {
+	UserService   forum.UserRepository
+	Authenticator *auth.Authenticator
+}
+
+type credentials struct {
+	NickName string `json:"nickname"`
+	Password string `json:"password"`
+}
+
+type session struct {
+	NickName string    `json:"nickname"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (h *Auth) Login(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	var creds credentials
+	if err := ctx.Bind(&creds); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	user, err := h.UserService.FindUserByNickName(reqCtx, creds.NickName)
+	if err != nil {
+		return errorJSON(ctx, http.StatusUnauthorized, "Wrong nickname or password", err)
+	}
+	hash, err := h.UserService.SelectPasswordHash(reqCtx, user.NickName)
+	if err != nil {
+		return errorJSON(ctx, http.StatusUnauthorized, "Wrong nickname or password", err)
+	}
+	if !auth.CheckPassword(hash, creds.Password) {
+		return ctx.JSON(http.StatusUnauthorized, forum.ErrorMessage{Message: "Wrong nickname or password"})
+	}
+	token, expires, err := h.Authenticator.Issue(user.NickName)
+	if err != nil {
+		return errorJSON(ctx, http.StatusInternalServerError, "Can't issue token", err)
+	}
+	return ctx.JSON(http.StatusOK, session{NickName: user.NickName, Token: token, Expires: expires})
+}
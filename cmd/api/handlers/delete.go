@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"strings"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+)
+
+// actor names who makes a change: the authenticated caller, whom the
+// optional nickname query parameter must name if it is given.
+func actor(ctx echo.Context) (string, error) {
+	principal, ok := auth.FromContext(ctx.Request().Context())
+	if !ok {
+		return "", auth.ErrUnauthenticated
+	}
+	if nickName := ctx.QueryParam("nickname"); nickName != "" && !strings.EqualFold(nickName, principal.NickName) {
+		return "", auth.ErrForbidden
+	}
+	return principal.NickName, nil
+}
+
+func (h *Post) DeletePost(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+	post, err := h.PostService.SelectPostById(reqCtx, id)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	if ok, err := h.moderate(ctx, post.Forum, post.Author); !ok {
+		return err
+	}
+	by, err := actor(ctx)
+	if ok, err := allowed(ctx, err); !ok {
+		return err
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.DeletePost(reqCtx, tx, id, by)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (h *Post) DeleteThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	slugOrIdStr := ctx.Param("slug_or_id")
+	var thread forum.Thread
+	id, err := strconv.Atoi(slugOrIdStr)
+	if err != nil {
+		thread, err = h.ThreadService.FindThreadBySlug(reqCtx, slugOrIdStr)
+	} else {
+		thread, err = h.ThreadService.FindThreadById(reqCtx, id)
+	}
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	id = thread.Id
+	if ok, err := h.moderate(ctx, thread.Forum, thread.Author); !ok {
+		return err
+	}
+	by, err := actor(ctx)
+	if ok, err := allowed(ctx, err); !ok {
+		return err
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.DeleteThread(reqCtx, tx, id, by)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (h *Post) PurgePost(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.PurgePost(reqCtx, tx, id)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (h *Post) PurgeThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.PurgeThread(reqCtx, tx, id)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
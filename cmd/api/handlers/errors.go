@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo"
+	"net/http"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+	"tech-db/internal/logging"
+)
+
+// errorJSON answers with message and logs err, the underlying cause, unless
+// it is a plain not found.
+func errorJSON(ctx echo.Context, code int, message string, err error) error {
+	if err != nil && err != pgx.ErrNoRows {
+		logging.Error(ctx, err)
+	}
+	return ctx.JSON(code, forum.ErrorMessage{Message: message})
+}
+
+// authorize checks that the caller may act as nickName. When it may not,
+// it answers the request and returns false.
+func authorize(ctx echo.Context, nickName string) (bool, error) {
+	return allowed(ctx, auth.Authorize(ctx.Request().Context(), nickName))
+}
+
+// allowed answers the request and returns false when err, the result of an
+// auth or policy check, refuses it.
+func allowed(ctx echo.Context, err error) (bool, error) {
+	switch err {
+	case nil:
+		return true, nil
+	case auth.ErrUnauthenticated:
+		return false, ctx.JSON(http.StatusUnauthorized, forum.ErrorMessage{Message: err.Error()})
+	case auth.ErrForbidden:
+		return false, ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	default:
+		return false, errorJSON(ctx, http.StatusInternalServerError, "Can't check permissions", err)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+	"time"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	defaultEventWait  = 30
+	maxEventWait      = 60
+	defaultEventPoll  = 250 * time.Millisecond
+)
+
+// Events serves the change feed recorded in the outbox. A client asks for
+// the changes after the last seq it has seen; when there are none yet the
+// request is held for up to ?wait= seconds until some are committed.
+type Events struct {
+	OutboxService forum.OutboxRepository
+	// Poll is how often a held request looks for new changes, 250
+	// milliseconds by default.
+	Poll time.Duration
+	// Done ends held requests early when it is closed, so that they do not
+	// hold up a shutdown.
+	Done <-chan struct{}
+}
+
+func (h *Events) poll() time.Duration {
+	if h.Poll > 0 {
+		return h.Poll
+	}
+	return defaultEventPoll
+}
+
+// GetEvents answers {"events": [...], "last": seq} with up to ?limit=
+// changes after ?after=. Last is the seq to pass as after next time.
+func (h *Events) GetEvents(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	var after int64
+	if s := ctx.QueryParam("after"); s != "" {
+		var err error
+		after, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 0 {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "after must be a seq"})
+		}
+	}
+	limit := defaultEventLimit
+	if s := ctx.QueryParam("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxEventLimit {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "limit must be between 1 and 1000"})
+		}
+	}
+	wait := defaultEventWait
+	if s := ctx.QueryParam("wait"); s != "" {
+		var err error
+		wait, err = strconv.Atoi(s)
+		if err != nil || wait < 0 || wait > maxEventWait {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "wait must be between 0 and 60 seconds"})
+		}
+	}
+
+	deadline := time.After(time.Duration(wait) * time.Second)
+	ticker := time.NewTicker(h.poll())
+	defer ticker.Stop()
+	for {
+		changes, err := h.OutboxService.SelectChanges(reqCtx, after, limit)
+		if err != nil {
+			return errorJSON(ctx, http.StatusInternalServerError, "Can't read events", err)
+		}
+		if len(changes) > 0 || wait == 0 {
+			return h.page(ctx, after, changes)
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return h.page(ctx, after, changes)
+		case <-h.Done:
+			return h.page(ctx, after, changes)
+		case <-reqCtx.Done():
+			return nil
+		}
+	}
+}
+
+func (h *Events) page(ctx echo.Context, after int64, changes []forum.Change) error {
+	feed := forum.ChangeFeed{Events: changes, Last: after}
+	if len(changes) > 0 {
+		feed.Last = changes[len(changes)-1].Seq
+	} else {
+		feed.Events = []forum.Change{}
+	}
+	return ctx.JSON(http.StatusOK, feed)
+}
@@ -10,18 +10,20 @@ import (
 )
 
 type Forum struct {
-	ForumService  *forum.ForumService
-	UserService   *forum.UserService
-	ThreadService *forum.ThreadService
+	ForumService  forum.ForumRepository
+	UserService   forum.UserRepository
+	ThreadService forum.ThreadRepository
+	TxManager     forum.Transactor
 }
 
 func (h *Forum) CreateForum(ctx echo.Context) (Err error) {
+	reqCtx := ctx.Request().Context()
 	newForum := forum.Forum{}
 	if err := ctx.Bind(&newForum); err != nil {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
-	fullForum, err := h.ForumService.SelectForumBySlug(newForum.Slug)
+	fullForum, err := h.ForumService.SelectForumBySlug(reqCtx, newForum.Slug)
 	if err == nil {
 		return ctx.JSON(http.StatusConflict, fullForum)
 	}
@@ -29,17 +31,20 @@ func (h *Forum) CreateForum(ctx echo.Context) (Err error) {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
-	user, err := h.UserService.FindUserByNickName(newForum.User)
+	user, err := h.UserService.FindUserByNickName(reqCtx, newForum.User)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find user"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	newForum.User = user.NickName
 
-	if err = h.ForumService.InsertForum(newForum); err != nil {
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return tx.Forums.InsertForum(reqCtx, newForum)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
@@ -47,6 +52,7 @@ func (h *Forum) CreateForum(ctx echo.Context) (Err error) {
 }
 
 func (h *Forum) CreateThread(ctx echo.Context) (Err error) {
+	reqCtx := ctx.Request().Context()
 	slug := ctx.Param("slug")
 	if slug == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
@@ -58,29 +64,32 @@ func (h *Forum) CreateThread(ctx echo.Context) (Err error) {
 	}
 
 	newThread.Forum = slug
+	if ok, err := authorize(ctx, newThread.Author); !ok {
+		return err
+	}
 
-	threadForum, err := h.ForumService.SelectForumBySlug(newThread.Forum)
+	threadForum, err := h.ForumService.SelectForumBySlug(reqCtx, newThread.Forum)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find forum"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 	newThread.Forum = threadForum.Slug
 	newThread.ForumId = threadForum.Id
 
-	author, err := h.UserService.FindUserByNickName(newThread.Author)
+	author, err := h.UserService.FindUserByNickName(reqCtx, newThread.Author)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find user"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	newThread.Author = author.NickName
 
 	if newThread.Slug != "" {
-		thread, err := h.ThreadService.SelectThreadBySlug(newThread.Slug)
+		thread, err := h.ThreadService.SelectThreadBySlug(reqCtx, newThread.Slug)
 		if err == nil {
 			return ctx.JSON(http.StatusConflict, thread)
 		}
@@ -89,41 +98,48 @@ func (h *Forum) CreateThread(ctx echo.Context) (Err error) {
 		}
 	}
 
-	threadId, err := h.ThreadService.InsertThread(newThread)
-	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
-	}
-
-	newThread.Id = threadId
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		threadId, err := tx.Threads.InsertThread(reqCtx, newThread)
+		if err != nil {
+			return err
+		}
+		newThread.Id = threadId
 
-	err = h.ForumService.UpdateThreadCount(newThread.ForumId)
+		if err = tx.Forums.UpdateThreadCount(reqCtx, newThread.ForumId); err != nil {
+			return err
+		}
+		if err = tx.Forums.InsertForumUser(reqCtx, newThread.ForumId, author.Id); err != nil {
+			return err
+		}
+		return forum.EnqueueEvent(reqCtx, tx.Webhooks, newThread.Forum, forum.EventThreadCreated, newThread)
+	})
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusBadRequest, "Error", err)
 	}
 
-	err = h.ForumService.InsertForumUser(newThread.ForumId, author.Id)
-
 	return ctx.JSON(http.StatusCreated, newThread)
 }
 
 func (h *Forum) GetForumDetails(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slug := ctx.Param("slug")
 	if slug == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
-	fullForum, err := h.ForumService.SelectForumBySlug(slug)
+	fullForum, err := h.ForumService.SelectForumBySlug(reqCtx, slug)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find forum"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	return ctx.JSON(http.StatusOK, fullForum)
 }
 
 func (h *Forum) GetForumThreads(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slug := ctx.Param("slug")
 	if slug == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
@@ -146,36 +162,49 @@ func (h *Forum) GetForumThreads(ctx echo.Context) error {
 		desc = false
 	}
 
-	threads, err := h.ThreadService.SelectThreadByForum(slug, limit, since, desc)
+	page := forum.Page{Limit: limit, Since: since, Desc: desc}
+	if page.After, err = cursorParam(ctx, forum.ThreadCursor); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
+	if page.After != nil {
+		page.Desc = page.After.Desc
+	}
+
+	threads, err := h.ThreadService.SelectThreadByForum(reqCtx, slug, page)
+	if err == forum.ErrBadPage {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	if len(threads) == 0 {
-		_, err := h.ForumService.SelectForumBySlug(slug)
+		_, err := h.ForumService.SelectForumBySlug(reqCtx, slug)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
 			}
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+			return errorJSON(ctx, http.StatusNotFound, "Error", err)
 		}
 
 		threads := []forum.Thread{}
 		return ctx.JSON(http.StatusOK, threads)
 	}
 
+	setPageLinks(ctx, page, len(threads) == limit, threads[0].Cursor(page.Desc), threads[len(threads)-1].Cursor(page.Desc))
 	return ctx.JSON(http.StatusOK, threads)
 }
 
 func (h *Forum) GetForumUsers(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slug := ctx.Param("slug")
 	if slug == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
-	usersForum, err := h.ForumService.SelectForumBySlug(slug)
+	usersForum, err := h.ForumService.SelectForumBySlug(reqCtx, slug)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find forum"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
 	}
 	limitStr := ctx.QueryParam("limit")
 	limit, err := strconv.Atoi(limitStr)
@@ -188,25 +217,38 @@ func (h *Forum) GetForumUsers(ctx echo.Context) error {
 
 	since := ctx.QueryParam("since")
 
-	desc := ctx.QueryParam("desc")
-	if desc == "" {
-		desc = "false"
+	desc, err := strconv.ParseBool(ctx.QueryParam("desc"))
+	if err != nil {
+		desc = false
 	}
 
-	users, err := h.UserService.SelectUsersByForum(usersForum.Id, limit, since, desc)
+	page := forum.Page{Limit: limit, Since: since, Desc: desc}
+	if page.After, err = cursorParam(ctx, forum.UserCursor); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
+	if page.After != nil {
+		page.Desc = page.After.Desc
+	}
+
+	users, err := h.UserService.SelectUsersByForum(reqCtx, usersForum.Id, page)
+	if err == forum.ErrBadPage {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 	if users == nil {
 		nullUsers := []User{}
 		return ctx.JSON(http.StatusOK, nullUsers)
 	}
 
+	setPageLinks(ctx, page, len(users) == limit, users[0].Cursor(page.Desc), users[len(users)-1].Cursor(page.Desc))
 	return ctx.JSON(http.StatusOK, users)
 }
 
 func (h *Forum) Clean(ctx echo.Context) error {
-	err := h.ForumService.Clean()
+	reqCtx := ctx.Request().Context()
+	err := h.ForumService.Clean(reqCtx)
 	if err != nil {
 		return err
 	}
@@ -214,8 +256,9 @@ func (h *Forum) Clean(ctx echo.Context) error {
 }
 
 func (h *Forum) Status(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 
-	status, err := h.ForumService.SelectStatus()
+	status, err := h.ForumService.SelectStatus(reqCtx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,1267 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"golang.org/x/net/websocket"
+	"tech-db/internal/auth"
+	"tech-db/internal/diff"
+	"tech-db/internal/forum"
+	"tech-db/internal/forum/memory"
+	"tech-db/internal/live"
+	"tech-db/internal/webhook"
+)
+
+type testServer struct {
+	e      *echo.Echo
+	store  *memory.Store
+	hub    *live.Hub
+	auth   *auth.Authenticator
+	header http.Header
+}
+
+const testAdminToken = "secret"
+
+func newTestServer() *testServer {
+	return newAuthTestServer(false)
+}
+
+// newAuthTestServer is newTestServer with authentication required or not.
+func newAuthTestServer(required bool) *testServer {
+	store := memory.NewStore()
+	hub := live.NewHub()
+	authenticator := auth.New([]byte("test"), time.Hour, required)
+	login := Auth{UserService: store.Users, Authenticator: authenticator}
+	user := User{UserService: store.Users, TxManager: store}
+	policy := auth.NewPolicy(store.Roles)
+	roles := Roles{ForumService: store.Forums, RoleService: store.Roles, Policy: policy}
+	forumHandler := Forum{ForumService: store.Forums, UserService: store.Users, ThreadService: store.Threads, TxManager: store}
+	post := Post{PostService: store.Posts, ForumService: store.Forums, UserService: store.Users, ThreadService: store.Threads, TxManager: store, Policy: policy, Hub: hub}
+	webhooks := Webhooks{ForumService: store.Forums, WebhookService: store.Webhooks, Policy: policy, AllowPrivate: true}
+	feeds := Live{Hub: hub, ForumService: store.Forums, ThreadService: store.Threads, KeepAlive: 50 * time.Millisecond}
+
+	e := echo.New()
+	e.Use(authenticator.Middleware())
+	e.POST("/api/auth/login", login.Login)
+	e.POST("/api/user/:nickname/create", user.CreateUser)
+	e.POST("/api/user/:nickname/profile", user.EditProfile)
+	e.PUT("/api/user/:nickname/password", user.SetPassword)
+	e.POST("/api/forum/create", forumHandler.CreateForum)
+	e.POST("/api/forum/:slug/create", forumHandler.CreateThread)
+	e.GET("/api/forum/:slug/details", forumHandler.GetForumDetails)
+	e.GET("/api/forum/:slug/users", forumHandler.GetForumUsers)
+	e.POST("/api/thread/:slug_or_id/create", post.CreatePosts)
+	e.POST("/api/thread/:slug_or_id/vote", post.CreateVote)
+	e.GET("/api/thread/:slug_or_id/live", feeds.ThreadEvents)
+	e.GET("/api/thread/:slug_or_id/live/ws", feeds.ThreadSocket)
+	e.GET("/api/forum/:slug/live", feeds.ForumEvents)
+	e.POST("/api/post/:id/split", post.SplitThread)
+	e.POST("/api/thread/:slug_or_id/move", post.MoveThread)
+	e.POST("/api/thread/:slug_or_id/merge", post.MergeThread)
+	e.PUT("/api/thread/:slug_or_id/locked", post.LockThread)
+	e.DELETE("/api/thread/:slug_or_id/locked", post.UnlockThread)
+	e.PUT("/api/thread/:slug_or_id/pinned", post.PinThread)
+	e.DELETE("/api/thread/:slug_or_id/pinned", post.UnpinThread)
+	e.PUT("/api/thread/:slug_or_id/archived", post.ArchiveThread)
+	e.DELETE("/api/thread/:slug_or_id/archived", post.UnarchiveThread)
+	e.GET("/api/forum/:slug/threads", forumHandler.GetForumThreads)
+	e.GET("/api/thread/:slug_or_id/posts", post.GetPosts)
+	e.POST("/api/post/:id/details", post.EditMessage)
+	e.GET("/api/thread/:slug_or_id/details", post.GetThread)
+	e.POST("/api/thread/:slug_or_id/details", post.EditThread)
+	e.GET("/api/post/:id/tree", post.GetTree)
+	e.GET("/api/post/:id/context", post.GetContext)
+	e.GET("/api/post/:id/history", post.GetHistory)
+	e.GET("/api/post/:id/diff", post.GetDiff)
+	e.DELETE("/api/post/:id", post.DeletePost)
+	e.DELETE("/api/thread/:slug_or_id", post.DeleteThread)
+	search := Search{SearchService: store.Search}
+	e.GET("/api/search", search.Search)
+	e.PUT("/api/forum/:slug/moderators/:nickname", roles.AddModerator)
+	e.DELETE("/api/forum/:slug/moderators/:nickname", roles.RemoveModerator)
+	e.GET("/api/forum/:slug/moderators", roles.GetModerators)
+	e.POST("/api/forum/:slug/webhooks", webhooks.CreateWebhook)
+	e.GET("/api/forum/:slug/webhooks", webhooks.GetWebhooks)
+	e.DELETE("/api/forum/:slug/webhooks/:id", webhooks.DeleteWebhook)
+	e.GET("/api/forum/:slug/webhooks/:id/deliveries", webhooks.GetDeliveries)
+	adminOnly := AdminOnly(testAdminToken, policy)
+	e.POST("/api/service/clear", forumHandler.Clean, adminOnly)
+	admin := e.Group("/api/admin", adminOnly)
+	admin.DELETE("/post/:id", post.PurgePost)
+	admin.DELETE("/thread/:id", post.PurgeThread)
+	admin.PUT("/user/:nickname/admin", roles.GrantAdmin)
+	admin.PUT("/user/:nickname/password", user.ResetPassword)
+	events := Events{OutboxService: store.Outbox, Poll: 10 * time.Millisecond}
+	e.GET("/api/events", events.GetEvents, adminOnly)
+	return &testServer{e: e, store: store, hub: hub, auth: authenticator, header: http.Header{}}
+}
+
+func (s *testServer) do(t *testing.T, method, target, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for k := range s.header {
+		req.Header.Set(k, s.header.Get(k))
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func (s *testServer) seed(t *testing.T) {
+	t.Helper()
+	for _, nick := range []string{"alice", "bob"} {
+		if code := s.do(t, http.MethodPost, "/api/user/"+nick+"/create", `{"email":"`+nick+`@example.com","fullname":"`+nick+`"}`, nil); code != http.StatusCreated {
+			t.Fatalf("create user %s: %d", nick, code)
+		}
+	}
+	if code := s.do(t, http.MethodPost, "/api/forum/create", `{"slug":"go","title":"Go","user":"alice"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create forum: %d", code)
+	}
+	if code := s.do(t, http.MethodPost, "/api/forum/go/create", `{"slug":"hello","title":"Hello","message":"hi","author":"alice"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create thread: %d", code)
+	}
+}
+
+func TestCreateUserConflict(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	var users []forum.User
+	code := s.do(t, http.MethodPost, "/api/user/ALICE/create", `{"email":"bob@example.com","fullname":"x"}`, &users)
+	if code != http.StatusConflict {
+		t.Fatalf("got %d, want %d", code, http.StatusConflict)
+	}
+	if len(users) != 2 {
+		t.Fatalf("got %d conflicting users, want 2", len(users))
+	}
+}
+
+func TestCreatePostsUnknownAuthorRollsBack(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"ok"},{"author":"nobody","message":"nope"}]`, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("got %d, want %d", code, http.StatusNotFound)
+	}
+
+	var f forum.Forum
+	s.do(t, http.MethodGet, "/api/forum/go/details", "", &f)
+	if f.Posts != 0 {
+		t.Errorf("forum has %d posts after a failed batch", f.Posts)
+	}
+	var users []forum.User
+	s.do(t, http.MethodGet, "/api/forum/go/users", "", &users)
+	if len(users) != 1 || users[0].NickName != "alice" {
+		t.Errorf("forum users after a failed batch: %+v", users)
+	}
+}
+
+func TestCreateVoteFlip(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	for _, step := range []struct {
+		body  string
+		votes int
+	}{
+		{`{"nickname":"bob","voice":1}`, 1},
+		{`{"nickname":"bob","voice":1}`, 1},
+		{`{"nickname":"bob","voice":-1}`, -1},
+		{`{"nickname":"alice","voice":-1}`, -2},
+	} {
+		var thread forum.Thread
+		if code := s.do(t, http.MethodPost, "/api/thread/hello/vote", step.body, &thread); code != http.StatusOK {
+			t.Fatalf("vote %s: %d", step.body, code)
+		}
+		if thread.Votes != step.votes {
+			t.Errorf("after %s got %d votes, want %d", step.body, thread.Votes, step.votes)
+		}
+	}
+}
+
+func TestDeleteAndPurgePost(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"root"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	id := strconv.Itoa(posts[0].Id)
+
+	if code := s.do(t, http.MethodDelete, "/api/post/"+id+"?nickname=alice", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous delete: %d", code)
+	}
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodDelete, "/api/post/"+id+"?nickname=bob", "", nil); code != http.StatusForbidden {
+		t.Errorf("delete naming another user: %d", code)
+	}
+	if code := s.do(t, http.MethodDelete, "/api/post/"+id, "", nil); code != http.StatusNoContent {
+		t.Fatalf("delete: %d", code)
+	}
+	s.as(t, "")
+	var tree []forum.Post
+	s.do(t, http.MethodGet, "/api/thread/hello/posts?sort=tree", "", &tree)
+	if len(tree) != 1 || !tree[0].Deleted || tree[0].Message != "" {
+		t.Errorf("tree after delete = %+v", tree)
+	}
+
+	if code := s.do(t, http.MethodDelete, "/api/admin/post/"+id, "", nil); code != http.StatusForbidden {
+		t.Errorf("purge without a token: %d", code)
+	}
+	s.header.Set(HeaderAdminToken, "wrong")
+	if code := s.do(t, http.MethodDelete, "/api/admin/post/"+id, "", nil); code != http.StatusForbidden {
+		t.Errorf("purge with a wrong token: %d", code)
+	}
+	s.header.Set(HeaderAdminToken, testAdminToken)
+	if code := s.do(t, http.MethodDelete, "/api/admin/post/"+id, "", nil); code != http.StatusNoContent {
+		t.Errorf("purge: %d", code)
+	}
+	if code := s.do(t, http.MethodDelete, "/api/admin/post/"+id, "", nil); code != http.StatusNotFound {
+		t.Errorf("purge twice: %d", code)
+	}
+}
+
+func TestPostHistoryAndDiff(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"one two\nthree"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	base := "/api/post/" + strconv.Itoa(posts[0].Id)
+
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPost, base+"/details", `{"message":"one 2\nthree"}`, nil); code != http.StatusOK {
+		t.Fatalf("edit: %d", code)
+	}
+	s.as(t, "bob")
+	if code := s.do(t, http.MethodPost, base+"/details", `{"message":"one 2\nthree\nfour"}`, nil); code != http.StatusOK {
+		t.Fatalf("edit: %d", code)
+	}
+
+	var history []forum.PostRevision
+	if code := s.do(t, http.MethodGet, base+"/history", "", &history); code != http.StatusOK {
+		t.Fatalf("history: %d", code)
+	}
+	if len(history) != 3 || history[0].Editor != "bob" || history[1].Editor != "alice" || history[2].Editor != "bob" {
+		t.Fatalf("history = %+v", history)
+	}
+
+	var d postDiff
+	if code := s.do(t, http.MethodGet, base+"/diff?from=0&to=1&mode=word", "", &d); code != http.StatusOK {
+		t.Fatalf("diff: %d", code)
+	}
+	want := []diff.Change{{Op: diff.Equal, Text: "one "}, {Op: diff.Delete, Text: "two"}, {Op: diff.Insert, Text: "2"}, {Op: diff.Equal, Text: "\nthree"}}
+	if !reflect.DeepEqual(d.Changes, want) {
+		t.Errorf("word diff = %+v, want %+v", d.Changes, want)
+	}
+
+	if code := s.do(t, http.MethodGet, base+"/diff", "", &d); code != http.StatusOK || d.From != 0 || d.To != 2 || d.Mode != "line" {
+		t.Errorf("default diff: %d %+v", code, d)
+	}
+	if code := s.do(t, http.MethodGet, base+"/diff?to=3", "", nil); code != http.StatusNotFound {
+		t.Errorf("diff to a missing revision: %d", code)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"gopher one"},{"author":"bob","message":"gopher two"},{"author":"bob","message":"gopher three"}]`, nil); code != http.StatusCreated {
+		t.Fatalf("create posts: %d", code)
+	}
+
+	seen := map[int]bool{}
+	target := "/api/search?q=gopher&limit=2"
+	for pages := 0; target != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		var page searchPage
+		if code := s.do(t, http.MethodGet, target, "", &page); code != http.StatusOK {
+			t.Fatalf("%s: %d", target, code)
+		}
+		for _, r := range page.Results {
+			if seen[r.Id] {
+				t.Errorf("post %d returned twice", r.Id)
+			}
+			seen[r.Id] = true
+		}
+		target = ""
+		if page.Next != "" {
+			target = "/api/search?q=gopher&limit=2&cursor=" + page.Next
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("found %d posts, want 3", len(seen))
+	}
+
+	for _, bad := range []string{"/api/search", "/api/search?q=x&limit=0", "/api/search?q=x&since=yesterday", "/api/search?q=x&cursor=e30"} {
+		if code := s.do(t, http.MethodGet, bad, "", nil); code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want %d", bad, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	var ready error
+	health := Health{Ready: func(context.Context) error { return ready }}
+	e := echo.New()
+	e.GET("/healthz", health.Live)
+	e.GET("/readyz", health.Readiness)
+	s := &testServer{e: e}
+
+	if code := s.do(t, http.MethodGet, "/readyz", "", nil); code != http.StatusOK {
+		t.Fatalf("ready: %d", code)
+	}
+
+	ready = errors.New("schema version 1, want 2")
+	var status healthStatus
+	if code := s.do(t, http.MethodGet, "/readyz", "", &status); code != http.StatusServiceUnavailable || status.Error != ready.Error() {
+		t.Fatalf("not ready: %d %+v", code, status)
+	}
+	if code := s.do(t, http.MethodGet, "/healthz", "", nil); code != http.StatusOK {
+		t.Fatalf("live while not ready: %d", code)
+	}
+}
+
+func TestLoginAndAuthorize(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	var created map[string]interface{}
+	code := s.do(t, http.MethodPost, "/api/user/carol/create", `{"email":"carol@example.com","fullname":"Carol","password":"hunter2"}`, &created)
+	if code != http.StatusCreated {
+		t.Fatalf("register: got %d", code)
+	}
+	if _, leaked := created["password"]; leaked {
+		t.Fatalf("password echoed back: %v", created)
+	}
+
+	if code := s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"carol","password":"wrong"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"alice","password":""}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("account without password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	var sess session
+	if code := s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"CAROL","password":"hunter2"}`, &sess); code != http.StatusOK {
+		t.Fatalf("login: got %d", code)
+	}
+	if sess.NickName != "carol" || sess.Token == "" {
+		t.Fatalf("login: got %+v", sess)
+	}
+
+	s.header.Set(echo.HeaderAuthorization, "Bearer "+sess.Token)
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"as alice"}]`, nil); code != http.StatusForbidden {
+		t.Fatalf("post as someone else: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/vote", `{"nickname":"alice","voice":1}`, nil); code != http.StatusForbidden {
+		t.Fatalf("vote as someone else: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodPost, "/api/user/alice/profile", `{"fullname":"Mallory"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("edit someone else's profile: got %d, want %d", code, http.StatusForbidden)
+	}
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"carol","message":"as carol"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("post as self: got %d", code)
+	}
+	if code := s.do(t, http.MethodPost, "/api/post/"+strconv.Itoa(posts[0].Id)+"/details?nickname=alice", `{"message":"edited"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("edit naming another editor: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	s.header.Set(echo.HeaderAuthorization, "Bearer garbage")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"carol","message":"x"}]`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	s := newAuthTestServer(true)
+	if code := s.do(t, http.MethodPost, "/api/user/dave/create", `{"email":"dave@example.com","fullname":"Dave","password":"pw"}`, nil); code != http.StatusCreated {
+		t.Fatalf("register: got %d", code)
+	}
+	if code := s.do(t, http.MethodPost, "/api/forum/create", `{"slug":"go","title":"Go","user":"dave"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create forum: %d", code)
+	}
+	if code := s.do(t, http.MethodPost, "/api/forum/go/create", `{"slug":"hello","title":"Hello","message":"hi","author":"dave"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous thread: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	var sess session
+	if code := s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"dave","password":"pw"}`, &sess); code != http.StatusOK {
+		t.Fatalf("login: got %d", code)
+	}
+	s.header.Set(echo.HeaderAuthorization, "Bearer "+sess.Token)
+	if code := s.do(t, http.MethodPost, "/api/forum/go/create", `{"slug":"hello","title":"Hello","message":"hi","author":"dave"}`, nil); code != http.StatusCreated {
+		t.Fatalf("authenticated thread: got %d", code)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	login := func(nick, password string) int {
+		return s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"`+nick+`","password":"`+password+`"}`, nil)
+	}
+
+	if code := s.do(t, http.MethodPut, "/api/user/alice/password", `{"password":"pw"}`, nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	s.as(t, "bob")
+	if code := s.do(t, http.MethodPut, "/api/user/alice/password", `{"password":"pw"}`, nil); code != http.StatusForbidden {
+		t.Errorf("someone else's: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPut, "/api/user/alice/password", `{"password":""}`, nil); code != http.StatusBadRequest {
+		t.Errorf("empty password: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := s.do(t, http.MethodPut, "/api/user/alice/password", `{"password":"pw"}`, nil); code != http.StatusNoContent {
+		t.Fatalf("own password: got %d", code)
+	}
+	s.as(t, "")
+	if code := login("alice", "pw"); code != http.StatusOK {
+		t.Errorf("login with the new password: got %d", code)
+	}
+
+	// bob was created without a password; an admin gives him one
+	if code := s.do(t, http.MethodPut, "/api/admin/user/bob/password", `{"password":"pw2"}`, nil); code != http.StatusForbidden {
+		t.Errorf("reset without the admin token: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.header.Set(HeaderAdminToken, testAdminToken)
+	if code := s.do(t, http.MethodPut, "/api/admin/user/nobody/password", `{"password":"pw2"}`, nil); code != http.StatusNotFound {
+		t.Errorf("reset for an unknown user: got %d, want %d", code, http.StatusNotFound)
+	}
+	if code := s.do(t, http.MethodPut, "/api/admin/user/bob/password", `{"password":"pw2"}`, nil); code != http.StatusNoContent {
+		t.Fatalf("reset: got %d", code)
+	}
+	s.header.Del(HeaderAdminToken)
+	if code := login("bob", "pw2"); code != http.StatusOK {
+		t.Errorf("login with the reset password: got %d", code)
+	}
+}
+
+// as makes the following requests as nick, with a token issued without a
+// login, or anonymously when nick is empty.
+func (s *testServer) as(t *testing.T, nick string) {
+	t.Helper()
+	if nick == "" {
+		s.header.Del(echo.HeaderAuthorization)
+		return
+	}
+	token, _, err := s.auth.Issue(nick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.header.Set(echo.HeaderAuthorization, "Bearer "+token)
+}
+
+// login registers nick with a password and returns a bearer header for it.
+func (s *testServer) login(t *testing.T, nick string) string {
+	t.Helper()
+	if code := s.do(t, http.MethodPost, "/api/user/"+nick+"/create", `{"email":"`+nick+`@example.com","fullname":"`+nick+`","password":"pw"}`, nil); code != http.StatusCreated {
+		t.Fatalf("register %s: %d", nick, code)
+	}
+	var sess session
+	if code := s.do(t, http.MethodPost, "/api/auth/login", `{"nickname":"`+nick+`","password":"pw"}`, &sess); code != http.StatusOK {
+		t.Fatalf("login %s: %d", nick, code)
+	}
+	return "Bearer " + sess.Token
+}
+
+func TestModeration(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	alice := s.login(t, "alicia")
+	mod := s.login(t, "mod")
+	other := s.login(t, "other")
+	var posts []forum.Post
+	s.header.Set(echo.HeaderAuthorization, alice)
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alicia","message":"first"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	edit := "/api/post/" + strconv.Itoa(posts[0].Id) + "/details"
+
+	s.header.Set(echo.HeaderAuthorization, other)
+	if code := s.do(t, http.MethodPost, edit, `{"message":"vandalised"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("edit by a stranger: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodPut, "/api/forum/go/moderators/other", "", nil); code != http.StatusForbidden {
+		t.Fatalf("stranger appointing itself: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	s.header.Set(echo.HeaderAuthorization, mod)
+	if code := s.do(t, http.MethodPost, edit, `{"message":"moderated"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("edit by a moderator before appointment: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.header.Del(echo.HeaderAuthorization)
+	s.header.Set(HeaderAdminToken, testAdminToken)
+	if code := s.do(t, http.MethodPut, "/api/admin/user/other/admin", "", nil); code != http.StatusNoContent {
+		t.Fatalf("grant admin: %d", code)
+	}
+	s.header.Del(HeaderAdminToken)
+
+	s.header.Set(echo.HeaderAuthorization, other)
+	if code := s.do(t, http.MethodPut, "/api/forum/go/moderators/mod", "", nil); code != http.StatusNoContent {
+		t.Fatalf("admin appointing a moderator: %d", code)
+	}
+	var mods []forum.User
+	s.do(t, http.MethodGet, "/api/forum/go/moderators", "", &mods)
+	if len(mods) != 1 || mods[0].NickName != "mod" {
+		t.Fatalf("moderators = %+v", mods)
+	}
+
+	s.header.Set(echo.HeaderAuthorization, mod)
+	var edited forum.Post
+	if code := s.do(t, http.MethodPost, edit, `{"message":"moderated"}`, &edited); code != http.StatusOK {
+		t.Fatalf("edit by a moderator: got %d", code)
+	}
+	if edited.Message != "moderated" || edited.Author != "alicia" {
+		t.Errorf("edited post = %+v", edited)
+	}
+	if code := s.do(t, http.MethodPost, "/api/service/clear", "", nil); code != http.StatusForbidden {
+		t.Fatalf("clear by a moderator: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.header.Del(echo.HeaderAuthorization)
+	if code := s.do(t, http.MethodPost, "/api/service/clear", "", nil); code != http.StatusForbidden {
+		t.Fatalf("anonymous clear: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.header.Set(echo.HeaderAuthorization, other)
+	if code := s.do(t, http.MethodPost, "/api/service/clear", "", nil); code != http.StatusOK {
+		t.Fatalf("clear by an admin: got %d", code)
+	}
+}
+
+// TestAnonymousModeration checks that the routes guarded by the policy
+// refuse anonymous callers even though authentication is not required to
+// act as a user, and leave everything as it was.
+func TestAnonymousModeration(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	if code := s.do(t, http.MethodPost, "/api/forum/create", `{"slug":"rust","title":"Rust","user":"bob"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create forum: %d", code)
+	}
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"root"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	post := "/api/post/" + strconv.Itoa(posts[0].Id)
+
+	for _, r := range []struct{ method, target, body string }{
+		{http.MethodPost, post + "/details", `{"message":"vandalised"}`},
+		{http.MethodDelete, post, ""},
+		{http.MethodPost, post + "/split", `{"title":"T"}`},
+		{http.MethodPost, "/api/thread/hello/details", `{"title":"vandalised"}`},
+		{http.MethodDelete, "/api/thread/hello", ""},
+		{http.MethodPost, "/api/thread/hello/move", `{"forum":"rust"}`},
+		{http.MethodPost, "/api/thread/hello/merge", `{"into":"hello"}`},
+		{http.MethodPut, "/api/thread/hello/locked", ""},
+		{http.MethodDelete, "/api/thread/hello/locked", ""},
+		{http.MethodPut, "/api/thread/hello/pinned", ""},
+		{http.MethodDelete, "/api/thread/hello/pinned", ""},
+		{http.MethodPut, "/api/thread/hello/archived", ""},
+		{http.MethodDelete, "/api/thread/hello/archived", ""},
+		{http.MethodPut, "/api/forum/go/moderators/bob", ""},
+		{http.MethodDelete, "/api/forum/go/moderators/bob", ""},
+		{http.MethodPost, "/api/forum/go/webhooks", `{"url":"http://example.com"}`},
+		{http.MethodGet, "/api/forum/go/webhooks", ""},
+		{http.MethodDelete, "/api/forum/go/webhooks/1", ""},
+		{http.MethodGet, "/api/forum/go/webhooks/1/deliveries", ""},
+	} {
+		if code := s.do(t, r.method, r.target, r.body, nil); code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: got %d, want %d", r.method, r.target, code, http.StatusUnauthorized)
+		}
+	}
+
+	var thread forum.Thread
+	s.do(t, http.MethodGet, "/api/thread/hello/details", "", &thread)
+	if thread.Title != "Hello" || thread.Forum != "go" || thread.Locked || thread.Pinned || thread.Archived {
+		t.Errorf("thread after anonymous requests = %+v", thread)
+	}
+	var tree []forum.Post
+	s.do(t, http.MethodGet, "/api/thread/hello/posts", "", &tree)
+	if len(tree) != 1 || tree[0].Message != "root" || tree[0].Deleted {
+		t.Errorf("posts after anonymous requests = %+v", tree)
+	}
+	var mods []forum.User
+	s.do(t, http.MethodGet, "/api/forum/go/moderators", "", &mods)
+	if len(mods) != 0 {
+		t.Errorf("moderators after anonymous requests = %+v", mods)
+	}
+	if hooks, _ := s.store.Webhooks.SelectWebhooks(context.Background(), "go"); len(hooks) != 0 {
+		t.Errorf("webhooks after anonymous requests = %+v", hooks)
+	}
+}
+
+func TestThreadFlags(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	bob := s.login(t, "mallory")
+
+	s.header.Set(echo.HeaderAuthorization, bob)
+	if code := s.do(t, http.MethodPut, "/api/thread/hello/locked", "", nil); code != http.StatusForbidden {
+		t.Fatalf("lock by a stranger: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.as(t, "alice")
+	var thread forum.Thread
+	if code := s.do(t, http.MethodPut, "/api/thread/hello/locked", "", &thread); code != http.StatusOK || !thread.Locked {
+		t.Fatalf("lock: %d %+v", code, thread)
+	}
+	s.as(t, "")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"late"}]`, nil); code != http.StatusForbidden {
+		t.Fatalf("post to a locked thread: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/vote", `{"nickname":"bob","voice":1}`, nil); code != http.StatusOK {
+		t.Fatalf("vote in a locked thread: got %d", code)
+	}
+	var threads []forum.Thread
+	s.do(t, http.MethodGet, "/api/forum/go/threads?limit=10", "", &threads)
+	if len(threads) != 1 || !threads[0].Locked {
+		t.Fatalf("thread list = %+v", threads)
+	}
+
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodDelete, "/api/thread/hello/locked", "", nil); code != http.StatusOK {
+		t.Fatalf("unlock: %d", code)
+	}
+	s.as(t, "")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"on time"}]`, nil); code != http.StatusCreated {
+		t.Fatalf("post to an unlocked thread: %d", code)
+	}
+
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPut, "/api/thread/hello/archived", "", nil); code != http.StatusOK {
+		t.Fatalf("archive: %d", code)
+	}
+	s.as(t, "")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/vote", `{"nickname":"bob","voice":-1}`, nil); code != http.StatusForbidden {
+		t.Fatalf("vote in an archived thread: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[]`, nil); code != http.StatusForbidden {
+		t.Fatalf("post to an archived thread: got %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestMoveAndMergeThreads(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	if code := s.do(t, http.MethodPost, "/api/forum/create", `{"slug":"rust","title":"Rust","user":"bob"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create forum: %d", code)
+	}
+	if code := s.do(t, http.MethodPost, "/api/forum/rust/create", `{"slug":"again","title":"Hello again","message":"hi","author":"bob"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create thread: %d", code)
+	}
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/again/create", `[{"author":"bob","message":"dup"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create posts: %d", code)
+	}
+
+	var thread forum.Thread
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/move", `{"forum":"rust"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous move: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/move", `{"forum":"nowhere"}`, nil); code != http.StatusNotFound {
+		t.Fatalf("move to a missing forum: got %d, want %d", code, http.StatusNotFound)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/move", `{"forum":"rust"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("move into a forum the author does not moderate: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.as(t, "bob")
+	if code := s.do(t, http.MethodPut, "/api/forum/rust/moderators/alice", "", nil); code != http.StatusNoContent {
+		t.Fatalf("appoint a moderator: %d", code)
+	}
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/move", `{"forum":"rust"}`, &thread); code != http.StatusOK || thread.Forum != "rust" {
+		t.Fatalf("move: %d %+v", code, thread)
+	}
+	var f forum.Forum
+	s.do(t, http.MethodGet, "/api/forum/go/details", "", &f)
+	if f.Threads != 0 {
+		t.Errorf("go forum after the move = %+v", f)
+	}
+
+	s.as(t, "carol")
+	if code := s.do(t, http.MethodPost, "/api/thread/again/merge", `{"into":"hello"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("merge by a stranger: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.as(t, "")
+	if code := s.do(t, http.MethodPost, "/api/thread/again/merge", `{"into":"hello"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous merge: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	s.as(t, "bob")
+	if code := s.do(t, http.MethodPost, "/api/thread/again/merge", `{"into":"again"}`, nil); code != http.StatusConflict {
+		t.Fatalf("merge into itself: got %d, want %d", code, http.StatusConflict)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/again/merge", `{"into":"hello"}`, &thread); code != http.StatusOK || thread.Slug != "hello" {
+		t.Fatalf("merge: %d %+v", code, thread)
+	}
+	var merged []forum.Post
+	s.do(t, http.MethodGet, "/api/thread/hello/posts", "", &merged)
+	if len(merged) != 1 || merged[0].Id != posts[0].Id || merged[0].Thread != thread.Id {
+		t.Errorf("posts after the merge = %+v", merged)
+	}
+	s.do(t, http.MethodGet, "/api/forum/rust/details", "", &f)
+	if f.Threads != 1 || f.Posts != 1 {
+		t.Errorf("rust forum after the merge = %+v", f)
+	}
+}
+
+func TestSplitThread(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	var roots, replies []forum.Post
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"topic"}]`, &roots)
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"tangent","parent":`+strconv.Itoa(roots[0].Id)+`}]`, &replies)
+	split := "/api/post/" + strconv.Itoa(replies[0].Id) + "/split"
+
+	if code := s.do(t, http.MethodPost, split, `{"title":"Tangent"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous split: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	s.as(t, "alice")
+	if code := s.do(t, http.MethodPost, split, `{}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("split without a title: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := s.do(t, http.MethodPost, split, `{"title":"T","slug":"hello"}`, nil); code != http.StatusConflict {
+		t.Fatalf("split to a taken slug: got %d, want %d", code, http.StatusConflict)
+	}
+	s.header.Set(echo.HeaderAuthorization, s.login(t, "carol"))
+	if code := s.do(t, http.MethodPost, split, `{"title":"Tangent"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("split by a stranger: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.as(t, "alice")
+
+	var thread forum.Thread
+	if code := s.do(t, http.MethodPost, split, `{"title":"Tangent","slug":"tangent"}`, &thread); code != http.StatusCreated {
+		t.Fatalf("split: %d", code)
+	}
+	if thread.Author != "bob" || thread.Forum != "go" || thread.Message != "tangent" {
+		t.Errorf("new thread = %+v", thread)
+	}
+	var posts []forum.Post
+	s.do(t, http.MethodGet, "/api/thread/tangent/posts?sort=tree", "", &posts)
+	if len(posts) != 1 || posts[0].Id != replies[0].Id || posts[0].Parent != 0 {
+		t.Errorf("posts of the new thread = %+v", posts)
+	}
+	var f forum.Forum
+	s.do(t, http.MethodGet, "/api/forum/go/details", "", &f)
+	if f.Threads != 2 || f.Posts != 2 {
+		t.Errorf("forum after the split = %+v", f)
+	}
+}
+
+func TestPostTreeAndContext(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	parent := 0
+	var ids []int
+	for i := 0; i < 3; i++ {
+		var posts []forum.Post
+		s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"m","parent":`+strconv.Itoa(parent)+`}]`, &posts)
+		parent = posts[0].Id
+		ids = append(ids, parent)
+	}
+
+	var tree forum.PostNode
+	if code := s.do(t, http.MethodGet, "/api/post/"+strconv.Itoa(ids[0])+"/tree?depth=1", "", &tree); code != http.StatusOK {
+		t.Fatalf("tree: %d", code)
+	}
+	if tree.Id != ids[0] || len(tree.Replies) != 1 || tree.Replies[0].Id != ids[1] || len(tree.Replies[0].Replies) != 0 {
+		t.Errorf("tree = %+v", tree)
+	}
+	if code := s.do(t, http.MethodGet, "/api/post/"+strconv.Itoa(ids[0])+"/tree?depth=-1", "", nil); code != http.StatusBadRequest {
+		t.Errorf("negative depth: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	var chain []forum.Post
+	if code := s.do(t, http.MethodGet, "/api/post/"+strconv.Itoa(ids[2])+"/context", "", &chain); code != http.StatusOK {
+		t.Fatalf("context: %d", code)
+	}
+	if len(chain) != 3 || chain[0].Id != ids[0] || chain[2].Id != ids[2] {
+		t.Errorf("context = %+v", chain)
+	}
+	if code := s.do(t, http.MethodGet, "/api/post/999/context", "", nil); code != http.StatusNotFound {
+		t.Errorf("context of a missing post: got %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestGetPostsNested(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	var roots, replies []forum.Post
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"a"},{"author":"bob","message":"b"}]`, &roots)
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"a1","parent":`+strconv.Itoa(roots[0].Id)+`},{"author":"bob","message":"a2","parent":`+strconv.Itoa(roots[0].Id)+`}]`, &replies)
+
+	for _, tt := range []struct {
+		query string
+		roots []int
+		first []int
+	}{
+		{"sort=tree", []int{roots[0].Id, roots[1].Id}, []int{replies[0].Id, replies[1].Id}},
+		{"sort=tree&desc=true", []int{roots[1].Id, roots[0].Id}, []int{}},
+		{"sort=parent_tree&desc=true", []int{roots[1].Id, roots[0].Id}, []int{}},
+		{"sort=parent_tree&limit=1", []int{roots[0].Id}, []int{replies[0].Id, replies[1].Id}},
+		{"sort=tree&since=" + strconv.Itoa(roots[0].Id) + "&limit=1", []int{replies[0].Id}, []int{}},
+	} {
+		var nested []forum.PostNode
+		if code := s.do(t, http.MethodGet, "/api/thread/hello/posts?format=nested&"+tt.query, "", &nested); code != http.StatusOK {
+			t.Fatalf("%s: %d", tt.query, code)
+		}
+		gotRoots := []int{}
+		for _, n := range nested {
+			gotRoots = append(gotRoots, n.Id)
+		}
+		gotFirst := []int{}
+		for _, n := range nested[0].Replies {
+			gotFirst = append(gotFirst, n.Id)
+		}
+		if !reflect.DeepEqual(gotRoots, tt.roots) || !reflect.DeepEqual(gotFirst, tt.first) {
+			t.Errorf("%s: roots %v with replies %v, want %v with %v", tt.query, gotRoots, gotFirst, tt.roots, tt.first)
+		}
+	}
+	if code := s.do(t, http.MethodGet, "/api/thread/hello/posts?format=xml", "", nil); code != http.StatusBadRequest {
+		t.Errorf("unknown format: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := s.do(t, http.MethodGet, "/api/thread/hello/posts?sort=newest", "", nil); code != http.StatusBadRequest {
+		t.Errorf("unknown sort: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// walk follows the rel links from target and returns the field of every
+// item on the pages it went through, in listing order, and the last page.
+func (s *testServer) walk(t *testing.T, target, rel, field string) (items []string, last string) {
+	t.Helper()
+	for i := 0; target != "" && i < 20; i++ {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		s.e.ServeHTTP(rec, req)
+		var page []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET %s: %d %s", target, rec.Code, rec.Body.String())
+		}
+		var got []string
+		for _, item := range page {
+			got = append(got, fmt.Sprint(item[field]))
+		}
+		if rel == "prev" {
+			items = append(got, items...)
+		} else {
+			items = append(items, got...)
+		}
+		last, target = target, ""
+		for _, link := range rec.Header()["Link"] {
+			if strings.HasSuffix(link, `; rel="`+rel+`"`) {
+				target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="`+rel+`"`)
+			}
+		}
+	}
+	return items, last
+}
+
+func TestCursorPagination(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	for _, nick := range []string{"carol", "dave", "eve"} {
+		if code := s.do(t, http.MethodPost, "/api/user/"+nick+"/create", `{"email":"`+nick+`@example.com","fullname":"`+nick+`"}`, nil); code != http.StatusCreated {
+			t.Fatalf("create user %s: %d", nick, code)
+		}
+	}
+	for _, slug := range []string{"a", "b", "c", "d"} {
+		body := `{"slug":"` + slug + `","title":"T","message":"m","author":"alice","created":"2020-01-01T00:00:00Z"}`
+		if code := s.do(t, http.MethodPost, "/api/forum/go/create", body, nil); code != http.StatusCreated {
+			t.Fatalf("create thread %s: %d", slug, code)
+		}
+	}
+	var roots []forum.Post
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"1"},{"author":"bob","message":"2"},{"author":"carol","message":"3"},{"author":"dave","message":"4"},{"author":"eve","message":"5"}]`, &roots)
+	s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"1.1","parent":`+strconv.Itoa(roots[0].Id)+`},{"author":"bob","message":"3.1","parent":`+strconv.Itoa(roots[2].Id)+`}]`, nil)
+
+	for _, tt := range []struct {
+		list, page, field string
+	}{
+		{"/api/forum/go/threads?limit=100", "/api/forum/go/threads?limit=2", "id"},
+		{"/api/forum/go/threads?limit=100&desc=true", "/api/forum/go/threads?limit=2&desc=true", "id"},
+		{"/api/forum/go/users", "/api/forum/go/users?limit=2", "nickname"},
+		{"/api/forum/go/users?desc=true", "/api/forum/go/users?limit=2&desc=true", "nickname"},
+		{"/api/thread/hello/posts", "/api/thread/hello/posts?limit=2", "id"},
+		{"/api/thread/hello/posts?desc=true", "/api/thread/hello/posts?limit=3&desc=true", "id"},
+		{"/api/thread/hello/posts?sort=tree", "/api/thread/hello/posts?sort=tree&limit=2", "id"},
+		{"/api/thread/hello/posts?sort=parent_tree", "/api/thread/hello/posts?sort=parent_tree&limit=2", "id"},
+		{"/api/thread/hello/posts?sort=parent_tree&desc=true", "/api/thread/hello/posts?sort=parent_tree&limit=2&desc=true", "id"},
+	} {
+		all, _ := s.walk(t, tt.list, "", tt.field)
+		forwards, last := s.walk(t, tt.page, "next", tt.field)
+		if !reflect.DeepEqual(forwards, all) {
+			t.Errorf("%s forwards: got %v, want %v", tt.page, forwards, all)
+		}
+		lastPage, _ := s.walk(t, last, "", tt.field)
+		backwards, _ := s.walk(t, last, "prev", tt.field)
+		if !reflect.DeepEqual(backwards, all) {
+			t.Errorf("%s backwards from %v: got %v, want %v", tt.page, lastPage, backwards, all)
+		}
+	}
+
+	for _, target := range []string{
+		"/api/thread/hello/posts?cursor=nonsense",
+		"/api/forum/go/threads?limit=2&cursor=" + forum.User{NickName: "bob"}.Cursor(false).String(),
+	} {
+		if code := s.do(t, http.MethodGet, target, "", nil); code != http.StatusBadRequest {
+			t.Errorf("GET %s: got %d, want %d", target, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// nextEvent reads server-sent events from r up to the next one that is not a
+// comment, and returns its name and data.
+func nextEvent(t *testing.T, r *bufio.Reader) (name, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestLiveEvents(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	server := httptest.NewServer(s.e)
+	defer server.Close()
+
+	if res, err := http.Get(server.URL + "/api/thread/nope/live"); err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("feed of a missing thread: %v %v", res, err)
+	}
+
+	res, err := http.Get(server.URL + "/api/forum/go/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	feed := bufio.NewReader(res.Body)
+
+	var posts []forum.Post
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"live"}]`, &posts); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	name, data := nextEvent(t, feed)
+	var e live.Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatal(err)
+	}
+	if name != live.PostCreated || e.Post == nil || e.Post.Id != posts[0].Id || e.Post.Message != "live" {
+		t.Errorf("got %s %s, want the new post", name, data)
+	}
+
+	// a batch larger than a subscriber's buffer comes as one event per post
+	batch := make([]string, 200)
+	for i := range batch {
+		batch[i] = `{"author":"bob","message":"batch"}`
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", "["+strings.Join(batch, ",")+"]", &posts); code != http.StatusCreated {
+		t.Fatalf("create posts: %d", code)
+	}
+	for i := range posts {
+		name, data := nextEvent(t, feed)
+		var e live.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatal(err)
+		}
+		if name != live.PostCreated || e.Post == nil || e.Post.Id != posts[i].Id || e.Posts != nil {
+			t.Fatalf("event %d of the batch: %s %s", i, name, data)
+		}
+	}
+
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/vote", `{"nickname":"bob","voice":1}`, nil); code != http.StatusOK {
+		t.Fatalf("vote: %d", code)
+	}
+	if name, data := nextEvent(t, feed); name != live.VotesChanged || !strings.Contains(data, `"votes":1`) {
+		t.Errorf("got %s %s, want the new vote count", name, data)
+	}
+
+	s.hub.Close()
+	if _, err := io.Copy(ioutil.Discard, feed); err != nil {
+		t.Errorf("feed did not end with the hub: %v", err)
+	}
+}
+
+func TestLiveSocket(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	server := httptest.NewServer(s.e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/thread/hello/live/ws"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var ping map[string]string
+	if err := websocket.JSON.Receive(ws, &ping); err != nil || ping["type"] != "ping" {
+		t.Fatalf("got %v %v, want a ping while idle", ping, err)
+	}
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"alice","message":"over ws"}]`, nil); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+	for {
+		var e live.Event
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "ping" {
+			continue
+		}
+		if e.Type != live.PostCreated || e.Post == nil || e.Post.Message != "over ws" {
+			t.Errorf("got %+v, want the new post", e)
+		}
+		break
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	var got []forum.WebhookPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhook.Verify("shh", body, r.Header.Get(webhook.SignatureHeader)) {
+			t.Errorf("bad signature on %s", body)
+		}
+		var payload forum.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		got = append(got, payload)
+	}))
+	defer receiver.Close()
+
+	if code := s.do(t, http.MethodPost, "/api/forum/go/webhooks", `{"url":"`+receiver.URL+`"}`, nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous register: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	s.as(t, "alice")
+	for _, body := range []string{`{"url":"ftp://example.com"}`, `{"url":"` + receiver.URL + `","events":["post.deleted"]}`} {
+		if code := s.do(t, http.MethodPost, "/api/forum/go/webhooks", body, nil); code != http.StatusBadRequest {
+			t.Errorf("register %s: %d", body, code)
+		}
+	}
+	var hook forum.Webhook
+	body := `{"url":"` + receiver.URL + `","secret":"shh","events":["thread.created","post.created","vote.cast","user.created"]}`
+	if code := s.do(t, http.MethodPost, "/api/forum/go/webhooks", body, &hook); code != http.StatusCreated || hook.Secret != "shh" {
+		t.Fatalf("register: %d %+v", code, hook)
+	}
+	var hooks []forum.Webhook
+	if code := s.do(t, http.MethodGet, "/api/forum/go/webhooks", "", &hooks); code != http.StatusOK || len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Errorf("list: %d %+v, want the webhook without its secret", code, hooks)
+	}
+	s.as(t, "")
+
+	s.do(t, http.MethodPost, "/api/forum/go/create", `{"slug":"news","title":"News","message":"m","author":"bob"}`, nil)
+	s.do(t, http.MethodPost, "/api/thread/news/create", `[{"author":"alice","message":"a"}]`, nil)
+	s.do(t, http.MethodPost, "/api/thread/news/vote", `{"nickname":"alice","voice":1}`, nil)
+	s.do(t, http.MethodPost, "/api/user/carol/create", `{"email":"carol@example.com","fullname":"carol"}`, nil)
+	// failed requests queue nothing
+	s.do(t, http.MethodPost, "/api/thread/news/create", `[{"author":"nobody","message":"a"}]`, nil)
+
+	d := webhook.NewDispatcher(s.store.Webhooks)
+	d.Client, d.Workers = &http.Client{Timeout: time.Second}, 1
+	if n, err := d.DeliverDue(context.Background(), time.Now()); n != 4 || err != nil {
+		t.Fatalf("delivered %d: %v", n, err)
+	}
+	want := []string{forum.EventThreadCreated, forum.EventPostCreated, forum.EventVoteCast, forum.EventUserCreated}
+	if len(got) != len(want) {
+		t.Fatalf("receiver got %+v, want %v", got, want)
+	}
+	for i, p := range got {
+		if p.Event != want[i] {
+			t.Errorf("event %d is %s, want %s", i, p.Event, want[i])
+		}
+	}
+	if vote, _ := got[2].Data.(map[string]interface{}); vote["votes"] != 1.0 || vote["nickname"] != "alice" {
+		t.Errorf("vote.cast data %v", got[2].Data)
+	}
+
+	s.as(t, "alice")
+	var deliveries []forum.WebhookDelivery
+	target := fmt.Sprintf("/api/forum/go/webhooks/%d/deliveries?limit=2", hook.Id)
+	if code := s.do(t, http.MethodGet, target, "", &deliveries); code != http.StatusOK || len(deliveries) != 2 {
+		t.Fatalf("deliveries: %d %+v", code, deliveries)
+	}
+	if d := deliveries[0]; d.Event != forum.EventUserCreated || d.Status != forum.DeliveryDelivered || d.ResponseCode != http.StatusOK {
+		t.Errorf("latest delivery %+v", d)
+	}
+
+	if code := s.do(t, http.MethodDelete, fmt.Sprintf("/api/forum/go/webhooks/%d", hook.Id), "", nil); code != http.StatusNoContent {
+		t.Errorf("delete: %d", code)
+	}
+	if code := s.do(t, http.MethodGet, target, "", nil); code != http.StatusNotFound {
+		t.Errorf("deliveries of a deleted webhook: %d", code)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	strict := Webhooks{ForumService: s.store.Forums, WebhookService: s.store.Webhooks, Policy: auth.NewPolicy(s.store.Roles)}
+	s.e.POST("/strict/forum/:slug/webhooks", strict.CreateWebhook)
+	s.as(t, "alice")
+
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/",
+		"http://192.168.1.1/",
+		"http://[::1]/",
+		"http://[fd00:ec2::254]/",
+		"http://0.0.0.0/",
+	} {
+		if code := s.do(t, http.MethodPost, "/strict/forum/go/webhooks", `{"url":"`+u+`"}`, nil); code != http.StatusBadRequest {
+			t.Errorf("register %s: got %d, want %d", u, code, http.StatusBadRequest)
+		}
+	}
+	if code := s.do(t, http.MethodPost, "/strict/forum/go/webhooks", `{"url":"https://hooks.example.com/forum"}`, nil); code != http.StatusCreated {
+		t.Errorf("register a public URL: %d", code)
+	}
+}
+
+func TestWebhooksOwnerOnly(t *testing.T) {
+	s := newTestServer()
+	owner := s.login(t, "owner")
+	other := s.login(t, "other")
+	if code := s.do(t, http.MethodPost, "/api/forum/create", `{"slug":"mine","title":"Mine","user":"owner"}`, nil); code != http.StatusCreated {
+		t.Fatalf("create forum: %d", code)
+	}
+	s.header.Set(echo.HeaderAuthorization, other)
+	if code := s.do(t, http.MethodPost, "/api/forum/mine/webhooks", `{"url":"http://example.com"}`, nil); code != http.StatusForbidden {
+		t.Errorf("register as another user: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := s.do(t, http.MethodGet, "/api/forum/mine/webhooks", "", nil); code != http.StatusForbidden {
+		t.Errorf("list as another user: got %d, want %d", code, http.StatusForbidden)
+	}
+	s.header.Set(echo.HeaderAuthorization, owner)
+	var hook forum.Webhook
+	if code := s.do(t, http.MethodPost, "/api/forum/mine/webhooks", `{"url":"http://example.com"}`, &hook); code != http.StatusCreated {
+		t.Fatalf("register as the owner: %d", code)
+	}
+	if len(hook.Secret) != 64 || !reflect.DeepEqual(hook.Events, forum.WebhookEvents) {
+		t.Errorf("registered %+v, want a random secret and every event", hook)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+
+	if code := s.do(t, http.MethodGet, "/api/events?wait=0", "", nil); code != http.StatusForbidden {
+		t.Errorf("events without a token: %d", code)
+	}
+	s.header.Set(HeaderAdminToken, testAdminToken)
+	for _, query := range []string{"after=x", "limit=0", "wait=61"} {
+		if code := s.do(t, http.MethodGet, "/api/events?"+query, "", nil); code != http.StatusBadRequest {
+			t.Errorf("events?%s: %d", query, code)
+		}
+	}
+
+	var feed forum.ChangeFeed
+	if code := s.do(t, http.MethodGet, "/api/events?wait=0", "", &feed); code != http.StatusOK {
+		t.Fatalf("events: %d", code)
+	}
+	kinds := []string{}
+	for _, c := range feed.Events {
+		kinds = append(kinds, c.Kind+" "+c.Key)
+	}
+	want := []string{"user.inserted alice", "user.inserted bob", "forum.inserted go", "thread.inserted 1"}
+	if !reflect.DeepEqual(kinds, want) || feed.Last != feed.Events[3].Seq {
+		t.Fatalf("events = %q last %d, want %q", kinds, feed.Last, want)
+	}
+	last := feed.Last
+	if code := s.do(t, http.MethodGet, "/api/events?wait=0&after="+strconv.FormatInt(last, 10), "", &feed); code != http.StatusOK || len(feed.Events) != 0 || feed.Last != last {
+		t.Errorf("events after the last: %d %+v", code, feed)
+	}
+
+	// a held request returns once a change is committed
+	held := make(chan forum.ChangeFeed, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/events?wait=5&after="+strconv.FormatInt(last, 10), nil)
+		req.Header.Set(HeaderAdminToken, testAdminToken)
+		rec := httptest.NewRecorder()
+		s.e.ServeHTTP(rec, req)
+		var feed forum.ChangeFeed
+		json.Unmarshal(rec.Body.Bytes(), &feed)
+		held <- feed
+	}()
+	time.Sleep(30 * time.Millisecond)
+	if code := s.do(t, http.MethodPost, "/api/user/bob/profile", `{"about":"gopher"}`, nil); code != http.StatusOK {
+		t.Fatalf("edit profile: %d", code)
+	}
+	select {
+	case feed = <-held:
+	case <-time.After(2 * time.Second):
+		t.Fatal("held request did not return")
+	}
+	if len(feed.Events) != 1 || feed.Events[0].Kind != forum.ChangeUserUpdated || feed.Events[0].Key != "bob" || feed.Last <= last {
+		t.Errorf("held request got %+v", feed)
+	}
+}
+
+func TestListInjection(t *testing.T) {
+	s := newTestServer()
+	s.seed(t)
+	if code := s.do(t, http.MethodPost, "/api/thread/hello/create", `[{"author":"bob","message":"what? $1 ?"}]`, nil); code != http.StatusCreated {
+		t.Fatalf("create post: %d", code)
+	}
+
+	for _, target := range []string{
+		"/api/thread/hello/posts?since=" + url.QueryEscape("1; DROP TABLE post; --"),
+		"/api/thread/hello/posts?sort=tree&since=" + url.QueryEscape("1 OR 1=1"),
+		"/api/forum/go/threads?limit=10&since=" + url.QueryEscape("' OR 1=1 --"),
+	} {
+		var msg forum.ErrorMessage
+		if code := s.do(t, http.MethodGet, target, "", &msg); code != http.StatusBadRequest || msg.Message != forum.ErrBadPage.Error() {
+			t.Errorf("GET %s: %d %q", target, code, msg.Message)
+		}
+	}
+
+	var posts []forum.Post
+	if code := s.do(t, http.MethodGet, "/api/thread/hello/posts", "", &posts); code != http.StatusOK || len(posts) != 1 || posts[0].Message != "what? $1 ?" {
+		t.Errorf("posts after the attempts: %d %+v", code, posts)
+	}
+}
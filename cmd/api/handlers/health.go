@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"github.com/labstack/echo"
+	"net/http"
+	"time"
+)
+
+// Health serves the orchestrator probes. Neither touches the forum tables:
+// /healthz only says the process is serving and /readyz runs Ready, which
+// should check that the database is reachable and the schema is current.
+type Health struct {
+	Ready   func(ctx context.Context) error
+	Timeout time.Duration
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (h *Health) Live(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, healthStatus{Status: "ok"})
+}
+
+func (h *Health) Readiness(ctx echo.Context) error {
+	if h.Ready == nil {
+		return ctx.JSON(http.StatusOK, healthStatus{Status: "ok"})
+	}
+
+	reqCtx := ctx.Request().Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, h.Timeout)
+		defer cancel()
+	}
+
+	if err := h.Ready(reqCtx); err != nil {
+		return ctx.JSON(http.StatusServiceUnavailable, healthStatus{Status: "unavailable", Error: err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, healthStatus{Status: "ok"})
+}
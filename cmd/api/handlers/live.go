@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+	"tech-db/internal/live"
+	"time"
+)
+
+const defaultKeepAlive = 15 * time.Second
+
+// Live serves the feeds of new posts, edits and votes of a thread or of
+// every thread in a forum, as server-sent events or over a WebSocket.
+type Live struct {
+	Hub           *live.Hub
+	ForumService  forum.ForumRepository
+	ThreadService forum.ThreadRepository
+	// KeepAlive is how often an idle feed sends something so that proxies
+	// keep it open, 15 seconds by default.
+	KeepAlive time.Duration
+}
+
+func (h *Live) ThreadEvents(ctx echo.Context) error {
+	filter, err := h.threadFilter(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return h.stream(ctx, filter)
+}
+
+func (h *Live) ThreadSocket(ctx echo.Context) error {
+	filter, err := h.threadFilter(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return h.socket(ctx, filter)
+}
+
+func (h *Live) ForumEvents(ctx echo.Context) error {
+	filter, err := h.forumFilter(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	return h.stream(ctx, filter)
+}
+
+func (h *Live) ForumSocket(ctx echo.Context) error {
+	filter, err := h.forumFilter(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	return h.socket(ctx, filter)
+}
+
+func (h *Live) threadFilter(ctx echo.Context) (live.Filter, error) {
+	reqCtx := ctx.Request().Context()
+	slugOrId := ctx.Param("slug_or_id")
+	var thread forum.Thread
+	id, err := strconv.Atoi(slugOrId)
+	if err != nil {
+		thread, err = h.ThreadService.FindThreadBySlug(reqCtx, slugOrId)
+	} else {
+		thread, err = h.ThreadService.FindThreadById(reqCtx, id)
+	}
+	return live.Filter{Forum: thread.Forum, Thread: thread.Id}, err
+}
+
+func (h *Live) forumFilter(ctx echo.Context) (live.Filter, error) {
+	f, err := h.ForumService.SelectForumBySlug(ctx.Request().Context(), ctx.Param("slug"))
+	return live.Filter{Forum: f.Slug}, err
+}
+
+func (h *Live) keepAlive() time.Duration {
+	if h.KeepAlive > 0 {
+		return h.KeepAlive
+	}
+	return defaultKeepAlive
+}
+
+// stream writes the feed as server-sent events named after the event
+// types, until the client goes away or the hub drops it for falling
+// behind.
+func (h *Live) stream(ctx echo.Context, filter live.Filter) error {
+	sub := h.Hub.Subscribe(filter)
+	defer sub.Close()
+
+	res := ctx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ticker := time.NewTicker(h.keepAlive())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			for _, e := range e.Split() {
+				data, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return nil
+				}
+			}
+		}
+		res.Flush()
+	}
+}
+
+// socket sends the feed as JSON messages over a WebSocket, with a message
+// of type ping when it is idle. The feeds are as public as the listings, so
+// connections are accepted from any origin. Whatever the client sends is
+// discarded; its closing ends the feed.
+func (h *Live) socket(ctx echo.Context, filter live.Filter) error {
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		sub := h.Hub.Subscribe(filter)
+		defer sub.Close()
+
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		ticker := time.NewTicker(h.keepAlive())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gone:
+				return
+			case <-ticker.C:
+				if err := websocket.Message.Send(ws, `{"type":"ping"}`); err != nil {
+					return
+				}
+			case e, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				for _, e := range e.Split() {
+					if err := websocket.JSON.Send(ws, e); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}}
+	server.ServeHTTP(ctx.Response(), ctx.Request())
+	return nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+	"time"
+)
+
+type moveRequest struct {
+	Forum string `json:"forum"`
+}
+
+type mergeRequest struct {
+	Into   string `json:"into"`
+	Parent int    `json:"parent"`
+}
+
+// MoveThread moves a thread and its posts to another forum. The thread's
+// author or a moderator of its forum may do it, if it also moderates the
+// forum it moves the thread to.
+func (h *Post) MoveThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	var req moveRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	thread, err := h.threadBySlugOrId(ctx, ctx.Param("slug_or_id"))
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	if ok, err := h.moderate(ctx, thread.Forum, thread.Author); !ok {
+		return err
+	}
+	if err := thread.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+	target, err := h.ForumService.SelectForumBySlug(reqCtx, req.Forum)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	if ok, err := allowed(ctx, h.Policy.ModerateForum(reqCtx, target)); !ok {
+		return err
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.MoveThread(reqCtx, tx, thread.Id, req.Forum)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't move thread", err)
+	}
+	thread, err = h.ThreadService.SelectThreadById(reqCtx, thread.Id)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.JSON(http.StatusOK, thread)
+}
+
+// MergeThread folds a thread's posts into another thread, optionally under
+// one of its posts, and deletes the emptied thread. The caller must
+// moderate both forums.
+func (h *Post) MergeThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	var req mergeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	from, err := h.threadBySlugOrId(ctx, ctx.Param("slug_or_id"))
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	into, err := h.threadBySlugOrId(ctx, req.Into)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	for _, slug := range []string{from.Forum, into.Forum} {
+		f, err := h.ForumService.SelectForumBySlug(reqCtx, slug)
+		if err != nil {
+			return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+		}
+		if ok, err := allowed(ctx, h.Policy.ModerateForum(reqCtx, f)); !ok {
+			return err
+		}
+	}
+	if err := from.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+	if err := into.CanPost(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+	by, err := actor(ctx)
+	if ok, err := allowed(ctx, err); !ok {
+		return err
+	}
+
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return forum.MergeThreads(reqCtx, tx, from.Id, into.Id, req.Parent, by)
+	})
+	switch err {
+	case nil:
+	case forum.ErrSameThread, forum.ErrParentConflict:
+		return ctx.JSON(http.StatusConflict, forum.ErrorMessage{Message: err.Error()})
+	default:
+		return errorJSON(ctx, http.StatusNotFound, "Can't merge threads", err)
+	}
+	into, err = h.ThreadService.SelectThreadById(reqCtx, into.Id)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.JSON(http.StatusOK, into)
+}
+
+func (h *Post) threadBySlugOrId(ctx echo.Context, slugOrId string) (forum.Thread, error) {
+	id, err := strconv.Atoi(slugOrId)
+	if err != nil {
+		return h.ThreadService.FindThreadBySlug(ctx.Request().Context(), slugOrId)
+	}
+	return h.ThreadService.FindThreadById(ctx.Request().Context(), id)
+}
+
+type splitRequest struct {
+	Forum   string `json:"forum"`
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// SplitThread moves a post and its replies into a new thread, by default in
+// the same forum and with the post's message. The caller must moderate the
+// forums on both sides.
+func (h *Post) SplitThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+	var req splitRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	if req.Title == "" {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Title is required"})
+	}
+	post, err := h.PostService.SelectPostById(reqCtx, id)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	thread, err := h.ThreadService.FindThreadById(reqCtx, post.Thread)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	if err := thread.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+	if req.Forum == "" {
+		req.Forum = post.Forum
+	}
+	if req.Message == "" {
+		req.Message = post.Message
+	}
+	var target forum.Forum
+	for _, slug := range []string{post.Forum, req.Forum} {
+		if target, err = h.ForumService.SelectForumBySlug(reqCtx, slug); err != nil {
+			return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+		}
+		if ok, err := allowed(ctx, h.Policy.ModerateForum(reqCtx, target)); !ok {
+			return err
+		}
+	}
+	if req.Slug != "" {
+		existing, err := h.ThreadService.SelectThreadBySlug(reqCtx, req.Slug)
+		if err == nil {
+			return ctx.JSON(http.StatusConflict, existing)
+		}
+		if err != forum.ErrNotFound {
+			return errorJSON(ctx, http.StatusBadRequest, "Error", err)
+		}
+	}
+
+	newThread := forum.Thread{
+		Author:  post.Author,
+		Created: time.Now(),
+		Forum:   target.Slug,
+		ForumId: target.Id,
+		Message: req.Message,
+		Slug:    req.Slug,
+		Title:   req.Title,
+	}
+	var newId int
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) (err error) {
+		newId, err = forum.SplitThread(reqCtx, tx, id, newThread)
+		return
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't split thread", err)
+	}
+	newThread, err = h.ThreadService.SelectThreadById(reqCtx, newId)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.JSON(http.StatusCreated, newThread)
+}
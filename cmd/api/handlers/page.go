@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"tech-db/internal/forum"
+)
+
+// cursorParam parses the cursor query parameter, which must be of one of
+// kinds, or returns nil when there is none.
+func cursorParam(ctx echo.Context, kinds ...string) (*forum.Cursor, error) {
+	s := ctx.QueryParam("cursor")
+	if s == "" {
+		return nil, nil
+	}
+	c, err := forum.ParseCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, kind := range kinds {
+		if c.Kind == kind {
+			return &c, nil
+		}
+	}
+	return nil, forum.ErrBadCursor
+}
+
+// setPageLinks adds Link headers to the pages before and after one that
+// holds items, given the cursors of its first and last ones and whether it
+// is full. There is a next page when the page is full or was reached going
+// back, and a previous one when it does not start the listing or is full
+// going back.
+func setPageLinks(ctx echo.Context, page forum.Page, full bool, first, last forum.Cursor) {
+	back := page.After != nil && page.After.Back
+	if back || full {
+		setPageLink(ctx, "next", last)
+	}
+	if (back && full) || (!back && (page.After != nil || page.Since != "")) {
+		first.Back = true
+		setPageLink(ctx, "prev", first)
+	}
+}
+
+func setPageLink(ctx echo.Context, rel string, c forum.Cursor) {
+	u := *ctx.Request().URL
+	q := u.Query()
+	q.Del("since")
+	q.Set("cursor", c.String())
+	u.RawQuery = q.Encode()
+	ctx.Response().Header().Add("Link", "<"+u.RequestURI()+`>; rel="`+rel+`"`)
+}
@@ -6,18 +6,36 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"tech-db/internal/auth"
 	"tech-db/internal/forum"
+	"tech-db/internal/live"
 	"time"
 )
 
 type Post struct {
-	ForumService  *forum.ForumService
-	UserService   *forum.UserService
-	ThreadService *forum.ThreadService
-	PostService   *forum.PostService
+	ForumService  forum.ForumRepository
+	UserService   forum.UserRepository
+	ThreadService forum.ThreadRepository
+	PostService   forum.PostRepository
+	TxManager     forum.Transactor
+	Policy        *auth.Policy
+	Hub           *live.Hub
+}
+
+// moderate checks that the caller may change what author wrote in the
+// forum with slug. When it may not, it answers the request and returns
+// false.
+func (h *Post) moderate(ctx echo.Context, slug, author string) (bool, error) {
+	reqCtx := ctx.Request().Context()
+	f, err := h.ForumService.SelectForumBySlug(reqCtx, slug)
+	if err != nil {
+		return false, errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	return allowed(ctx, h.Policy.Moderate(reqCtx, f, author))
 }
 
 func (h *Post) GetFullPost(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	idStr := ctx.Param("id")
 	if idStr == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
@@ -30,40 +48,40 @@ func (h *Post) GetFullPost(ctx echo.Context) error {
 
 	related := ctx.QueryParam("related")
 
-	post, err := h.PostService.SelectPostById(id)
+	post, err := h.PostService.SelectPostById(reqCtx, id)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find post"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	fullPost := forum.FullPost{Post: post}
 
 	if strings.Contains(related, "user") {
-		user, err := h.UserService.SelectUserByNickName(post.Author)
+		user, err := h.UserService.SelectUserByNickName(reqCtx, post.Author)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find user"})
 			}
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+			return errorJSON(ctx, http.StatusNotFound, "Error", err)
 		}
 		fullPost.Author = user
 	}
 
 	if strings.Contains(related, "forum") {
-		fullForum, err := h.ForumService.SelectForumBySlug(post.Forum)
+		fullForum, err := h.ForumService.SelectForumBySlug(reqCtx, post.Forum)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find forum"})
 			}
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+			return errorJSON(ctx, http.StatusNotFound, "Error", err)
 		}
 		fullPost.Forum = fullForum
 	}
 
 	if strings.Contains(related, "thread") {
-		thread, err := h.ThreadService.SelectThreadById(post.Thread)
+		thread, err := h.ThreadService.SelectThreadById(reqCtx, post.Thread)
 		if err != nil {
 			return ctx.JSON(http.StatusBadRequest, "")
 		}
@@ -74,6 +92,7 @@ func (h *Post) GetFullPost(ctx echo.Context) error {
 }
 
 func (h *Post) EditMessage(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	idStr := ctx.Param("id")
 	if idStr == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
@@ -90,26 +109,51 @@ func (h *Post) EditMessage(ctx echo.Context) error {
 	if err := ctx.Bind(&editMessage); err != nil {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
-	post, err := h.PostService.SelectPostById(id)
+	post, err := h.PostService.SelectPostById(reqCtx, id)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
+	}
+	if ok, err := h.moderate(ctx, post.Forum, post.Author); !ok {
+		return err
+	}
+	thread, err := h.ThreadService.FindThreadById(reqCtx, post.Thread)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	if err := thread.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+	editor, err := actor(ctx)
+	if ok, err := allowed(ctx, err); !ok {
+		return err
 	}
 	if editMessage.Message != "" && editMessage.Message != post.Message {
-		num, err := h.PostService.UpdatePostMessage(editMessage.Message, id)
+		edited := post
+		edited.Message = editMessage.Message
+		edited.IsEdited = true
+		var num int64
+		err := h.TxManager.InTx(reqCtx, func(tx forum.Tx) (err error) {
+			num, err = tx.Posts.UpdatePostMessage(reqCtx, editMessage.Message, id, editor)
+			if err != nil || num != 1 {
+				return err
+			}
+			return forum.EnqueueEvent(reqCtx, tx.Webhooks, post.Forum, forum.EventPostEdited, edited)
+		})
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+			return errorJSON(ctx, http.StatusNotFound, "Error", err)
 		}
 		if num != 1 {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find post"})
 		}
-		post.Message = editMessage.Message
-		post.IsEdited = true
+		post = edited
+		h.Hub.Publish(live.Edited(post))
 	}
 
 	return ctx.JSON(http.StatusOK, post)
 }
 
 func (h *Post) CreatePosts(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	createdTime := time.Now().Format(time.RFC3339Nano)
 	slugOrIdStr := ctx.Param("slug_or_id")
 	newPosts := []forum.Post{}
@@ -120,41 +164,67 @@ func (h *Post) CreatePosts(ctx echo.Context) error {
 	id, err := strconv.Atoi(slugOrIdStr)
 	if err != nil {
 		slug := slugOrIdStr
-		thread, err = h.ThreadService.FindThreadBySlug(slug)
+		thread, err = h.ThreadService.FindThreadBySlug(reqCtx, slug)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	} else {
-		thread, err = h.ThreadService.FindThreadById(id)
+		thread, err = h.ThreadService.FindThreadById(reqCtx, id)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	}
+	if err := thread.CanPost(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
 	if len(newPosts) == 0 {
 		return ctx.JSON(http.StatusCreated, newPosts)
 	}
-	forumPosts, err := h.ForumService.SelectForumBySlug(thread.Forum)
+	for _, post := range newPosts {
+		if ok, err := authorize(ctx, post.Author); !ok {
+			return err
+		}
+	}
+	forumPosts, err := h.ForumService.SelectForumBySlug(reqCtx, thread.Forum)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find forum"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
 	}
 
-	posts, err := h.PostService.CreatePosts(thread,forumPosts.Id, createdTime, newPosts)
-	if err != nil {
-		if err.Error() == "404" {
+	var posts []forum.Post
+	var createErr error
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		posts, createErr = tx.Posts.CreatePosts(reqCtx, thread, forumPosts.Id, createdTime, newPosts)
+		if createErr != nil {
+			return createErr
+		}
+		if err := tx.Forums.UpdatePostCount(reqCtx, thread.Forum, len(newPosts)); err != nil {
+			return err
+		}
+		for _, p := range posts {
+			if err := forum.EnqueueEvent(reqCtx, tx.Webhooks, thread.Forum, forum.EventPostCreated, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if createErr != nil {
+		if createErr == forum.ErrAuthorNotFound {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find post author by nickname:"})
 		}
 		return ctx.JSON(http.StatusConflict, forum.ErrorMessage{Message: "Parent post was created in another thread"})
 	}
-
-	err = h.ForumService.UpdatePostCount(thread.Forum, len(newPosts))
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Unexpected error"})
+		return errorJSON(ctx, http.StatusBadRequest, "Unexpected error", err)
+	}
+	if len(posts) > 0 {
+		h.Hub.Publish(live.PostedBatch(posts))
 	}
 
 	return ctx.JSON(http.StatusCreated, posts)
 }
 
 func (h *Post) EditThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slugOrIdStr := ctx.Param("slug_or_id")
 	var editThread forum.Thread
 	if err := ctx.Bind(&editThread); err != nil {
@@ -164,16 +234,22 @@ func (h *Post) EditThread(ctx echo.Context) error {
 	id, err := strconv.Atoi(slugOrIdStr)
 	if err != nil {
 		slug := slugOrIdStr
-		thread, err = h.ThreadService.FindThreadBySlug(slug)
+		thread, err = h.ThreadService.FindThreadBySlug(reqCtx, slug)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	} else {
-		thread, err = h.ThreadService.FindThreadById(id)
+		thread, err = h.ThreadService.FindThreadById(reqCtx, id)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	}
+	if ok, err := h.moderate(ctx, thread.Forum, thread.Author); !ok {
+		return err
+	}
+	if err := thread.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
 	if editThread.Message != "" {
 		thread.Message = editThread.Message
 	}
@@ -183,13 +259,16 @@ func (h *Post) EditThread(ctx echo.Context) error {
 	if editThread.Message == "" && editThread.Title == "" {
 		return ctx.JSON(http.StatusOK, thread)
 	}
-	err = h.ThreadService.UpdateThread(thread)
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return tx.Threads.UpdateThread(reqCtx, thread)
+	})
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't update thread"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't update thread", err)
 	}
 	return ctx.JSON(http.StatusOK, thread)
 }
 func (h *Post) CreateVote(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slugOrIdStr := ctx.Param("slug_or_id")
 	var newVote forum.Vote
 	if err := ctx.Bind(&newVote); err != nil {
@@ -199,74 +278,62 @@ func (h *Post) CreateVote(ctx echo.Context) error {
 	id, err := strconv.Atoi(slugOrIdStr)
 	if err != nil {
 		slug := slugOrIdStr
-		thread, err = h.ThreadService.FindThreadBySlug(slug)
+		thread, err = h.ThreadService.FindThreadBySlug(reqCtx, slug)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	} else {
-		thread, err = h.ThreadService.FindThreadById(id)
+		thread, err = h.ThreadService.FindThreadById(reqCtx, id)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	}
 
-	user, err := h.UserService.FindUserByNickName(newVote.NickName)
+	if ok, err := authorize(ctx, newVote.NickName); !ok {
+		return err
+	}
+	if err := thread.CanChange(); err != nil {
+		return ctx.JSON(http.StatusForbidden, forum.ErrorMessage{Message: err.Error()})
+	}
+
+	user, err := h.UserService.FindUserByNickName(reqCtx, newVote.NickName)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find user"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't find user", err)
 	}
 	newVote.ThreadId = thread.Id
 	newVote.UserId = user.Id
-	vote, err := h.ThreadService.SelectVote(newVote)
-	if err != nil {
-		err = h.ThreadService.InsertVote(newVote)
-		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't vote"})
-		}
-		err = h.ThreadService.UpdateVoteCount(newVote)
-		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't vote"})
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) (err error) {
+		if err = forum.CastVote(reqCtx, tx.Threads, newVote); err != nil {
+			return err
 		}
-	} else {
-		_, err = h.ThreadService.UpdateVote(newVote)
-		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't vote"})
-		}
-		if vote.Voice == -1 && newVote.Voice == 1 {
-			newVote.Voice = 2
-		} else {
-			if vote.Voice == 1 && newVote.Voice == -1 {
-				newVote.Voice = -2
-			} else {
-				newVote.Voice = 0
-			}
-		}
-		err = h.ThreadService.UpdateVoteCount(newVote)
-		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't vote"})
+		if thread, err = tx.Threads.SelectThreadById(reqCtx, newVote.ThreadId); err != nil {
+			return err
 		}
-	}
-
-	thread, err = h.ThreadService.SelectThreadById(newVote.ThreadId)
+		cast := forum.VoteCast{Thread: thread.Id, NickName: user.NickName, Voice: newVote.Voice, Votes: thread.Votes}
+		return forum.EnqueueEvent(reqCtx, tx.Webhooks, thread.Forum, forum.EventVoteCast, cast)
+	})
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't vote", err)
 	}
+	h.Hub.Publish(live.Voted(thread))
 	return ctx.JSON(http.StatusOK, thread)
 }
 
 func (h *Post) GetThread(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slugOrIdStr := ctx.Param("slug_or_id")
 
 	var thread forum.Thread
 	id, err := strconv.Atoi(slugOrIdStr)
 	if err != nil {
-		thread, err = h.ThreadService.SelectThreadBySlug(slugOrIdStr)
+		thread, err = h.ThreadService.SelectThreadBySlug(reqCtx, slugOrIdStr)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	} else {
-		thread, err = h.ThreadService.SelectThreadById(id)
+		thread, err = h.ThreadService.SelectThreadById(reqCtx, id)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 	}
 
@@ -274,50 +341,72 @@ func (h *Post) GetThread(ctx echo.Context) error {
 }
 
 func (h *Post) GetPosts(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
 	slugOrIdStr := ctx.Param("slug_or_id")
 
-	limit := ctx.QueryParam("limit")
-	since := ctx.QueryParam("since")
 	sort := ctx.QueryParam("sort")
-	desc := ctx.QueryParam("desc")
+	format := ctx.QueryParam("format")
+	if format != "" && format != "list" && format != "nested" {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Unknown format"})
+	}
 
-	if limit == "" {
-		limit = "100"
+	limit, err := intParam(ctx, "limit", 100)
+	if err != nil {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
 	}
 
 	if sort == "" {
 		sort = "flat"
 	}
 
-	if desc == "true" {
-		desc = "desc"
-	} else {
-		desc = ""
+	page := forum.Page{Limit: limit, Since: ctx.QueryParam("since"), Desc: ctx.QueryParam("desc") == "true"}
+	if page.After, err = cursorParam(ctx, forum.FlatCursor, forum.TreeCursor, forum.ParentTreeCursor); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
+	if page.After != nil {
+		sort, page.Desc = page.After.Kind, page.After.Desc
 	}
 
 	var thread forum.Thread
 	id, err := strconv.Atoi(slugOrIdStr)
 	if err != nil {
-		thread, err = h.ThreadService.SelectThreadBySlug(slugOrIdStr)
+		thread, err = h.ThreadService.SelectThreadBySlug(reqCtx, slugOrIdStr)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 		id = thread.Id
 	}
 
-	posts, err := h.ThreadService.SelectPosts(id, limit, since, sort, desc)
+	posts, err := h.ThreadService.SelectPosts(reqCtx, id, sort, page)
+	if err == forum.ErrBadPage || err == forum.ErrBadSort {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't read posts"})
+		return errorJSON(ctx, http.StatusNotFound, "Can't read posts", err)
 	}
 	if len(posts) == 0 {
 
-		thread, err = h.ThreadService.SelectThreadById(id)
+		thread, err = h.ThreadService.SelectThreadById(reqCtx, id)
 		if err != nil {
-			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find thread"})
+			return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
 		}
 
 		postss := []Post{}
 		return ctx.JSON(http.StatusOK, postss)
 	}
+	// a parent_tree page holds up to limit root posts with their replies
+	n := len(posts)
+	if sort == forum.ParentTreeCursor {
+		n = 0
+		for _, p := range posts {
+			if p.Parent == 0 {
+				n++
+			}
+		}
+	}
+	setPageLinks(ctx, page, n == limit, posts[0].Cursor(sort, page.Desc), posts[len(posts)-1].Cursor(sort, page.Desc))
+	if format == "nested" {
+		return ctx.JSON(http.StatusOK, forum.Nest(posts))
+	}
 	return ctx.JSON(http.StatusOK, posts)
 }
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/diff"
+	"tech-db/internal/forum"
+)
+
+type postDiff struct {
+	Post    int           `json:"post"`
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Mode    string        `json:"mode"`
+	Changes []diff.Change `json:"changes"`
+}
+
+func (h *Post) history(ctx echo.Context) ([]forum.PostRevision, error) {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return nil, forum.ErrNotFound
+	}
+	post, err := h.PostService.SelectPostById(reqCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	return forum.PostHistory(reqCtx, h.PostService, post)
+}
+
+func (h *Post) GetHistory(ctx echo.Context) error {
+	revisions, err := h.history(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	return ctx.JSON(http.StatusOK, revisions)
+}
+
+// GetDiff compares two revisions of a post, by default the original and
+// the latest, line by line or, with mode=word, word by word.
+func (h *Post) GetDiff(ctx echo.Context) error {
+	revisions, err := h.history(ctx)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+
+	result := postDiff{Post: revisions[0].Post, From: 0, To: len(revisions) - 1, Mode: ctx.QueryParam("mode")}
+	for param, rev := range map[string]*int{"from": &result.From, "to": &result.To} {
+		value := ctx.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n >= len(revisions) {
+			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find revision " + value})
+		}
+		*rev = n
+	}
+
+	from, to := revisions[result.From].Message, revisions[result.To].Message
+	switch result.Mode {
+	case "", "line":
+		result.Mode = "line"
+		result.Changes = diff.Lines(from, to)
+	case "word":
+		result.Changes = diff.Words(from, to)
+	default:
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "mode must be line or word"})
+	}
+	if result.Changes == nil {
+		result.Changes = []diff.Change{}
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
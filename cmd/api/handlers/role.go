@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"github.com/labstack/echo"
+	"net/http"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+)
+
+type Roles struct {
+	ForumService forum.ForumRepository
+	RoleService  forum.RoleRepository
+	Policy       *auth.Policy
+}
+
+func (h *Roles) GrantAdmin(ctx echo.Context) error {
+	return h.setAdmin(ctx, true)
+}
+
+func (h *Roles) RevokeAdmin(ctx echo.Context) error {
+	return h.setAdmin(ctx, false)
+}
+
+func (h *Roles) setAdmin(ctx echo.Context, admin bool) error {
+	err := h.RoleService.SetAdmin(ctx.Request().Context(), ctx.Param("nickname"), admin)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find user", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (h *Roles) GetModerators(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	f, err := h.ForumService.SelectForumBySlug(reqCtx, ctx.Param("slug"))
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	users, err := h.RoleService.SelectModerators(reqCtx, f.Slug)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't read moderators", err)
+	}
+	if users == nil {
+		users = []forum.User{}
+	}
+	return ctx.JSON(http.StatusOK, users)
+}
+
+func (h *Roles) AddModerator(ctx echo.Context) error {
+	return h.changeModerator(ctx, h.RoleService.InsertModerator)
+}
+
+func (h *Roles) RemoveModerator(ctx echo.Context) error {
+	return h.changeModerator(ctx, h.RoleService.DeleteModerator)
+}
+
+// changeModerator applies change to the forum and user in the path once
+// the caller is found to own the forum.
+func (h *Roles) changeModerator(ctx echo.Context, change func(reqCtx context.Context, forum, nickName string) error) error {
+	reqCtx := ctx.Request().Context()
+	f, err := h.ForumService.SelectForumBySlug(reqCtx, ctx.Param("slug"))
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	if ok, err := allowed(ctx, h.Policy.Own(reqCtx, f)); !ok {
+		return err
+	}
+	if err := change(reqCtx, f.Slug, ctx.Param("nickname")); err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find user", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
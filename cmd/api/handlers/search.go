@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type Search struct {
+	SearchService forum.SearchRepository
+}
+
+type searchPage struct {
+	Results []forum.SearchResult `json:"results"`
+	Next    string               `json:"next,omitempty"`
+}
+
+// Search serves GET /api/search?q=&forum=&author=&since=&limit=&cursor=.
+// A full page carries the cursor of its last result in next.
+func (h *Search) Search(ctx echo.Context) error {
+	query := forum.SearchQuery{
+		Text:   ctx.QueryParam("q"),
+		Forum:  ctx.QueryParam("forum"),
+		Author: ctx.QueryParam("author"),
+		Limit:  defaultSearchLimit,
+	}
+	if query.Text == "" {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "q is required"})
+	}
+	if since := ctx.QueryParam("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "since must be an RFC 3339 time"})
+		}
+		query.Since = t
+	}
+	if limit := ctx.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "limit must be between 1 and 100"})
+		}
+		query.Limit = n
+	}
+	if cursor := ctx.QueryParam("cursor"); cursor != "" {
+		after, err := forum.ParseSearchCursor(cursor)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+		}
+		query.After = &after
+	}
+
+	results, err := h.SearchService.Search(ctx.Request().Context(), query)
+	if err != nil {
+		return errorJSON(ctx, http.StatusInternalServerError, "Can't search", err)
+	}
+	page := searchPage{Results: results}
+	if page.Results == nil {
+		page.Results = []forum.SearchResult{}
+	}
+	if len(results) == query.Limit {
+		page.Next = results[len(results)-1].Cursor().String()
+	}
+	return ctx.JSON(http.StatusOK, page)
+}
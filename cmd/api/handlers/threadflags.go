@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+)
+
+func (h *Post) LockThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Locked = true })
+}
+
+func (h *Post) UnlockThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Locked = false })
+}
+
+func (h *Post) PinThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Pinned = true })
+}
+
+func (h *Post) UnpinThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Pinned = false })
+}
+
+func (h *Post) ArchiveThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Archived = true })
+}
+
+func (h *Post) UnarchiveThread(ctx echo.Context) error {
+	return h.setThreadFlag(ctx, func(t *forum.Thread) { t.Archived = false })
+}
+
+// setThreadFlag applies set to the thread in the path and stores its flags,
+// once the caller is found to moderate the thread's forum.
+func (h *Post) setThreadFlag(ctx echo.Context, set func(t *forum.Thread)) error {
+	reqCtx := ctx.Request().Context()
+	slugOrIdStr := ctx.Param("slug_or_id")
+	var thread forum.Thread
+	id, err := strconv.Atoi(slugOrIdStr)
+	if err != nil {
+		thread, err = h.ThreadService.SelectThreadBySlug(reqCtx, slugOrIdStr)
+	} else {
+		thread, err = h.ThreadService.SelectThreadById(reqCtx, id)
+	}
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	f, err := h.ForumService.SelectForumBySlug(reqCtx, thread.Forum)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	if ok, err := allowed(ctx, h.Policy.ModerateForum(reqCtx, f)); !ok {
+		return err
+	}
+
+	set(&thread)
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return tx.Threads.UpdateThreadFlags(reqCtx, thread)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find thread", err)
+	}
+	return ctx.JSON(http.StatusOK, thread)
+}
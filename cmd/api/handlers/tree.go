@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"tech-db/internal/forum"
+)
+
+// GetTree returns a post with its replies nested under it, depth levels
+// down (all by default) and at most limit posts in all.
+func (h *Post) GetTree(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+	depth, err := intParam(ctx, "depth", 0)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Bad depth"})
+	}
+	limit, err := intParam(ctx, "limit", 100)
+	if err != nil || limit == 0 {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Bad limit"})
+	}
+
+	posts, err := h.PostService.SelectSubtree(reqCtx, id, depth, limit)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	return ctx.JSON(http.StatusOK, forum.Nest(posts)[0])
+}
+
+// GetContext returns the chain of posts from a post's root down to the post.
+func (h *Post) GetContext(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id < 0 {
+		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+	}
+	posts, err := h.PostService.SelectAncestors(reqCtx, id)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find post", err)
+	}
+	return ctx.JSON(http.StatusOK, posts)
+}
+
+// intParam reads a non-negative integer query parameter, or def when it is
+// absent.
+func intParam(ctx echo.Context, name string, def int) (int, error) {
+	s := ctx.QueryParam(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err == nil && n < 0 {
+		err = strconv.ErrRange
+	}
+	return n, err
+}
@@ -4,57 +4,88 @@ import (
 	"github.com/jackc/pgx"
 	"github.com/labstack/echo"
 	"net/http"
+	"tech-db/internal/auth"
 	"tech-db/internal/forum"
 )
 
 type User struct {
-	UserService *forum.UserService
+	UserService forum.UserRepository
+	TxManager   forum.Transactor
 }
 
 func (h *User) CreateUser(ctx echo.Context) (Err error) {
+	reqCtx := ctx.Request().Context()
 	nickName := ctx.Param("nickname")
 	if nickName == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Empty nickname"})
 	}
-	newUser := forum.User{}
-	if err := ctx.Bind(&newUser); err != nil {
+	registration := struct {
+		forum.User
+		Password string `json:"password"`
+	}{}
+	if err := ctx.Bind(&registration); err != nil {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
 	}
+	newUser := registration.User
 	newUser.NickName = nickName
 
-	userSlice, err := h.UserService.SelectUserByNickNameOrEmail(newUser.NickName, newUser.Email)
+	userSlice, err := h.UserService.SelectUserByNickNameOrEmail(reqCtx, newUser.NickName, newUser.Email)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+		return errorJSON(ctx, http.StatusBadRequest, err.Error(), err)
 	}
 
 	if len(userSlice) > 0 {
 		return ctx.JSON(http.StatusConflict, userSlice)
 	}
 
-	if err = h.UserService.InsertUser(newUser); err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: err.Error()})
+	var hash string
+	if registration.Password != "" {
+		if hash, err = auth.HashPassword(registration.Password); err != nil {
+			return errorJSON(ctx, http.StatusBadRequest, "Can't set password", err)
+		}
+	}
+	var insertErr error
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		if insertErr = tx.Users.InsertUser(reqCtx, newUser); insertErr != nil {
+			return insertErr
+		}
+		if err := forum.EnqueueEvent(reqCtx, tx.Webhooks, "", forum.EventUserCreated, newUser); err != nil {
+			return err
+		}
+		if hash == "" {
+			return nil
+		}
+		return tx.Users.UpdatePassword(reqCtx, newUser.NickName, hash)
+	})
+	if insertErr != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: insertErr.Error()})
+	}
+	if err != nil {
+		return errorJSON(ctx, http.StatusBadRequest, "Can't create user", err)
 	}
 
 	return ctx.JSON(http.StatusCreated, newUser)
 }
 
 func (h *User) GetProfile(ctx echo.Context) (Err error) {
+	reqCtx := ctx.Request().Context()
 	nickName := ctx.Param("nickname")
 	if nickName == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
-	user, err := h.UserService.SelectUserByNickName(nickName)
+	user, err := h.UserService.SelectUserByNickName(reqCtx, nickName)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Can't find user"})
 		}
-		return ctx.JSON(http.StatusNotFound, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusNotFound, "Error", err)
 	}
 
 	return ctx.JSON(http.StatusOK, user)
 }
 
 func (h *User) EditProfile(ctx echo.Context) (Err error) {
+	reqCtx := ctx.Request().Context()
 	nickName := ctx.Param("nickname")
 	if nickName == "" {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
@@ -65,10 +96,13 @@ func (h *User) EditProfile(ctx echo.Context) (Err error) {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 	editUser.NickName = nickName
+	if ok, err := authorize(ctx, nickName); !ok {
+		return err
+	}
 
-	userSlice, err := h.UserService.SelectUserByNickNameOrEmail(editUser.NickName, editUser.Email)
+	userSlice, err := h.UserService.SelectUserByNickNameOrEmail(reqCtx, editUser.NickName, editUser.Email)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+		return errorJSON(ctx, http.StatusBadRequest, "Error", err)
 	}
 	if len(userSlice) > 1 {
 		return ctx.JSON(http.StatusConflict, forum.ErrorMessage{Message: "This email is already registered by user"})
@@ -90,9 +124,56 @@ func (h *User) EditProfile(ctx echo.Context) (Err error) {
 		editUser.FullName = userSlice[0].FullName
 	}
 
-	if err = h.UserService.UpdateUser(editUser); err != nil {
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return tx.Users.UpdateUser(reqCtx, editUser)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
 	}
 
 	return ctx.JSON(http.StatusOK, editUser)
 }
+
+// SetPassword sets the password of the user in the path, who must be the
+// authenticated caller, whether or not authentication is required.
+func (h *User) SetPassword(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	err := auth.ErrUnauthenticated
+	if _, ok := auth.FromContext(reqCtx); ok {
+		err = auth.Authorize(reqCtx, ctx.Param("nickname"))
+	}
+	if ok, err := allowed(ctx, err); !ok {
+		return err
+	}
+	return h.setPassword(ctx)
+}
+
+// ResetPassword sets the password of the user in the path for an admin, so
+// that users created without one can log in.
+func (h *User) ResetPassword(ctx echo.Context) error {
+	return h.setPassword(ctx)
+}
+
+func (h *User) setPassword(ctx echo.Context) error {
+	reqCtx := ctx.Request().Context()
+	req := struct {
+		Password string `json:"password"`
+	}{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	if req.Password == "" {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Empty password"})
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return errorJSON(ctx, http.StatusBadRequest, "Can't set password", err)
+	}
+	err = h.TxManager.InTx(reqCtx, func(tx forum.Tx) error {
+		return tx.Users.UpdatePassword(reqCtx, ctx.Param("nickname"), hash)
+	})
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find user", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/url"
+	"strconv"
+	"tech-db/internal/auth"
+	"tech-db/internal/forum"
+	"tech-db/internal/webhook"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// Webhooks manages the webhooks of a forum. Only the forum's owner and
+// admins may see or change them, since they carry the signing secrets.
+type Webhooks struct {
+	ForumService   forum.ForumRepository
+	WebhookService forum.WebhookRepository
+	Policy         *auth.Policy
+	// AllowPrivate lets webhooks point at loopback and private addresses,
+	// which are refused otherwise. Tests use it for local receivers.
+	AllowPrivate bool
+}
+
+// owned looks up the forum in the path and checks that the caller owns it.
+// When it does not, it answers the request and returns false.
+func (h *Webhooks) owned(ctx echo.Context) (forum.Forum, bool, error) {
+	reqCtx := ctx.Request().Context()
+	f, err := h.ForumService.SelectForumBySlug(reqCtx, ctx.Param("slug"))
+	if err != nil {
+		return f, false, errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	ok, err := allowed(ctx, h.Policy.Own(reqCtx, f))
+	return f, ok, err
+}
+
+// webhook looks up the webhook in the path, which must belong to f.
+func (h *Webhooks) webhook(ctx echo.Context, f forum.Forum) (forum.Webhook, error) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return forum.Webhook{}, forum.ErrNotFound
+	}
+	hook, err := h.WebhookService.SelectWebhook(ctx.Request().Context(), id)
+	if err == nil && hook.Forum != f.Slug {
+		return forum.Webhook{}, forum.ErrNotFound
+	}
+	return hook, err
+}
+
+// CreateWebhook registers a webhook from {"url", "events", "secret"}. All
+// events are sent when none are listed, and a random secret is made when
+// none is given. The response is the only one to show the secret.
+func (h *Webhooks) CreateWebhook(ctx echo.Context) error {
+	f, ok, err := h.owned(ctx)
+	if !ok {
+		return err
+	}
+	hook := forum.Webhook{}
+	if err := ctx.Bind(&hook); err != nil {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Error"})
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "url must be an absolute http or https URL"})
+	}
+	if err := webhook.CheckURL(u); err != nil && !h.AllowPrivate {
+		return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "url must not point at a local or private address"})
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = forum.WebhookEvents
+	}
+	for _, event := range hook.Events {
+		if !forum.IsWebhookEvent(event) {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "Unknown event " + event})
+		}
+	}
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return errorJSON(ctx, http.StatusInternalServerError, "Can't make a secret", err)
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	hook.Forum = f.Slug
+
+	hook, err = h.WebhookService.InsertWebhook(ctx.Request().Context(), hook)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find forum", err)
+	}
+	return ctx.JSON(http.StatusCreated, hook)
+}
+
+func (h *Webhooks) GetWebhooks(ctx echo.Context) error {
+	f, ok, err := h.owned(ctx)
+	if !ok {
+		return err
+	}
+	hooks, err := h.WebhookService.SelectWebhooks(ctx.Request().Context(), f.Slug)
+	if err != nil {
+		return errorJSON(ctx, http.StatusInternalServerError, "Can't read webhooks", err)
+	}
+	if hooks == nil {
+		hooks = []forum.Webhook{}
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return ctx.JSON(http.StatusOK, hooks)
+}
+
+func (h *Webhooks) DeleteWebhook(ctx echo.Context) error {
+	f, ok, err := h.owned(ctx)
+	if !ok {
+		return err
+	}
+	hook, err := h.webhook(ctx, f)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find webhook", err)
+	}
+	if err := h.WebhookService.DeleteWebhook(ctx.Request().Context(), hook.Id); err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find webhook", err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// GetDeliveries serves the delivery log of a webhook, newest first, with
+// ?limit= entries at most.
+func (h *Webhooks) GetDeliveries(ctx echo.Context) error {
+	f, ok, err := h.owned(ctx)
+	if !ok {
+		return err
+	}
+	hook, err := h.webhook(ctx, f)
+	if err != nil {
+		return errorJSON(ctx, http.StatusNotFound, "Can't find webhook", err)
+	}
+	limit := defaultDeliveryLimit
+	if s := ctx.QueryParam("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			return ctx.JSON(http.StatusBadRequest, forum.ErrorMessage{Message: "limit must be between 1 and 500"})
+		}
+	}
+	deliveries, err := h.WebhookService.SelectDeliveries(ctx.Request().Context(), hook.Id, limit)
+	if err != nil {
+		return errorJSON(ctx, http.StatusInternalServerError, "Can't read deliveries", err)
+	}
+	if deliveries == nil {
+		deliveries = []forum.WebhookDelivery{}
+	}
+	return ctx.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,54 @@
+// Command events-tail copies the change feed of the forum API into NDJSON
+// files. It remembers where it stopped, so it can be restarted at will:
+//
+//	events-tail -url http://localhost:5000 -dir /var/lib/forum/changes
+//
+// The admin token is read from -token or ADMIN_TOKEN.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+func main() {
+	t := &Tailer{Client: &http.Client{}, Logf: log.Printf}
+	flag.StringVar(&t.URL, "url", "http://localhost:5000", "base URL of the forum API")
+	flag.StringVar(&t.Token, "token", os.Getenv("ADMIN_TOKEN"), "admin token, ADMIN_TOKEN by default")
+	flag.StringVar(&t.Dir, "dir", ".", "directory to write the NDJSON files to")
+	flag.StringVar(&t.State, "state", "", "file keeping the last seq written (default <dir>/.last-seq)")
+	flag.DurationVar(&t.Wait, "wait", 30*time.Second, "how long each request waits for changes, at most 60s")
+	flag.IntVar(&t.Limit, "limit", 500, "how many changes to fetch at once, at most 1000")
+	flag.DurationVar(&t.Retry, "retry", 5*time.Second, "pause after a failed request")
+	flag.Parse()
+
+	if t.Wait < 0 || t.Wait > time.Minute || t.Limit < 1 || t.Limit > 1000 {
+		fmt.Fprintln(os.Stderr, "events-tail: -wait must be within 0-60s and -limit within 1-1000")
+		os.Exit(2)
+	}
+	if t.State == "" {
+		t.State = filepath.Join(t.Dir, ".last-seq")
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	// the request outlives the wait by a margin for the response itself
+	t.Client.Timeout = t.Wait + 30*time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+	t.Run(ctx)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+// Tailer copies the change feed of the API into NDJSON files, one per day
+// of changes, and keeps the last seq written in a state file. Lines are
+// synced to disk before the state moves past them, so a crash may write a
+// change twice but never skips one.
+type Tailer struct {
+	// URL is the base URL of the API.
+	URL string
+	// Token is sent as the admin token the feed requires.
+	Token string
+	// Dir is where the NDJSON files are written.
+	Dir string
+	// State is the file keeping the last seq written.
+	State  string
+	Client *http.Client
+	// Wait is how long the API holds a request when there are no changes.
+	Wait time.Duration
+	// Limit is how many changes are asked for at once.
+	Limit int
+	// Retry is the pause after a failed request.
+	Retry time.Duration
+	Logf  func(format string, args ...interface{})
+
+	last   int64
+	loaded bool
+}
+
+// fileName is the file the changes created on day go to.
+func fileName(day time.Time) string {
+	return "changes-" + day.UTC().Format("2006-01-02") + ".ndjson"
+}
+
+// Run tails the feed until ctx is done.
+func (t *Tailer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if _, err := t.Poll(ctx); err != nil && ctx.Err() == nil {
+			t.Logf("events-tail: %s", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(t.Retry):
+			}
+		}
+	}
+}
+
+// Poll asks for the changes after the last one written, writes them and
+// returns how many there were.
+func (t *Tailer) Poll(ctx context.Context) (int, error) {
+	if !t.loaded {
+		last, err := t.loadState()
+		if err != nil {
+			return 0, err
+		}
+		t.last, t.loaded = last, true
+	}
+	feed, err := t.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(feed.Events) == 0 {
+		return 0, nil
+	}
+	if err := t.write(feed.Events); err != nil {
+		return 0, err
+	}
+	if err := t.saveState(feed.Last); err != nil {
+		return 0, err
+	}
+	t.last = feed.Last
+	return len(feed.Events), nil
+}
+
+func (t *Tailer) fetch(ctx context.Context) (feed forum.ChangeFeed, err error) {
+	query := url.Values{}
+	query.Set("after", strconv.FormatInt(t.last, 10))
+	query.Set("limit", strconv.Itoa(t.Limit))
+	query.Set("wait", strconv.Itoa(int(t.Wait/time.Second)))
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(t.URL, "/")+"/api/events?"+query.Encode(), nil)
+	if err != nil {
+		return feed, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Admin-Token", t.Token)
+	res, err := t.Client.Do(req)
+	if err != nil {
+		return feed, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return feed, fmt.Errorf("%s answered %s: %s", req.URL.Host, res.Status, strings.TrimSpace(string(body)))
+	}
+	err = json.NewDecoder(res.Body).Decode(&feed)
+	return feed, err
+}
+
+// write appends changes to the files of their days and syncs them.
+func (t *Tailer) write(changes []forum.Change) error {
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	buffers := map[string]*bufio.Writer{}
+	var names []string
+	for _, c := range changes {
+		name := fileName(c.Created)
+		w, ok := buffers[name]
+		if !ok {
+			f, err := os.OpenFile(filepath.Join(t.Dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			files[name] = f
+			w = bufio.NewWriter(f)
+			buffers[name] = w
+			names = append(names, name)
+		}
+		line, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		w.Write(line)
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := buffers[name].Flush(); err != nil {
+			return err
+		}
+		if err := files[name].Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tailer) loadState() (int64, error) {
+	b, err := ioutil.ReadFile(t.State)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	last, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("state file %s: %v", t.State, err)
+	}
+	return last, nil
+}
+
+// saveState replaces the state file through a rename, so that it holds
+// either the old seq or the new one.
+func (t *Tailer) saveState(last int64) error {
+	tmp := t.State + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(last, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.State)
+}
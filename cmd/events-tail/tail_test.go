@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+func feedServer(t *testing.T, changes []forum.Change, fail *bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/events" || r.Header.Get("X-Admin-Token") != "secret" {
+			t.Errorf("request %s with token %q", r.URL, r.Header.Get("X-Admin-Token"))
+		}
+		if *fail {
+			http.Error(w, `{"message":"down"}`, http.StatusServiceUnavailable)
+			return
+		}
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		feed := forum.ChangeFeed{Events: []forum.Change{}, Last: after}
+		for _, c := range changes {
+			if c.Seq > after && len(feed.Events) < limit {
+				feed.Events = append(feed.Events, c)
+				feed.Last = c.Seq
+			}
+		}
+		json.NewEncoder(w).Encode(feed)
+	}))
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestTailer(t *testing.T) {
+	day := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	var changes []forum.Change
+	for i := 1; i <= 5; i++ {
+		changes = append(changes, forum.Change{
+			Seq:     int64(i),
+			Kind:    forum.ChangeUserInserted,
+			Key:     "user" + strconv.Itoa(i),
+			Data:    json.RawMessage(`{"nickname":"user` + strconv.Itoa(i) + `"}`),
+			Created: day.Add(time.Duration(i) * 20 * time.Minute),
+		})
+	}
+	fail := false
+	server := feedServer(t, changes, &fail)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "events-tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newTailer := func() *Tailer {
+		return &Tailer{URL: server.URL, Token: "secret", Dir: dir, State: filepath.Join(dir, ".last-seq"), Client: server.Client(), Limit: 3}
+	}
+	ctx := context.Background()
+
+	tailer := newTailer()
+	if n, err := tailer.Poll(ctx); n != 3 || err != nil {
+		t.Fatalf("first poll wrote %d: %v", n, err)
+	}
+	if n, err := tailer.Poll(ctx); n != 2 || err != nil {
+		t.Fatalf("second poll wrote %d: %v", n, err)
+	}
+	if n, err := tailer.Poll(ctx); n != 0 || err != nil {
+		t.Fatalf("poll at the end wrote %d: %v", n, err)
+	}
+
+	// the changes after midnight go to the next day's file
+	first := readLines(t, filepath.Join(dir, "changes-2020-01-01.ndjson"))
+	second := readLines(t, filepath.Join(dir, "changes-2020-01-02.ndjson"))
+	if len(first) != 2 || len(second) != 3 {
+		t.Fatalf("files hold %d and %d lines, want 2 and 3", len(first), len(second))
+	}
+	var c forum.Change
+	if err := json.Unmarshal([]byte(second[2]), &c); err != nil || c.Seq != 5 || c.Key != "user5" || string(c.Data) != `{"nickname":"user5"}` {
+		t.Errorf("last line %s: %v", second[2], err)
+	}
+	if state := readLines(t, tailer.State); state[0] != "5" {
+		t.Errorf("state = %q, want 5", state)
+	}
+
+	// a new tailer resumes after the state, and a failed request leaves it
+	fail = true
+	resumed := newTailer()
+	if _, err := resumed.Poll(ctx); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("poll of a failing server: %v", err)
+	}
+	fail = false
+	if n, err := resumed.Poll(ctx); n != 0 || err != nil {
+		t.Errorf("resumed tailer wrote %d: %v", n, err)
+	}
+	if state := readLines(t, tailer.State); state[0] != "5" {
+		t.Errorf("state after resuming = %q, want 5", state)
+	}
+}
@@ -0,0 +1,135 @@
+// Package auth issues and checks the signed tokens that identify forum
+// users, and decides whether a request may act as a given user.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"tech-db/internal/forum"
+)
+
+var (
+	ErrUnauthenticated = errors.New("Authentication required")
+	ErrForbidden       = errors.New("Not allowed to act as this user")
+	ErrInvalidToken    = errors.New("Invalid token")
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	NickName string
+}
+
+type Authenticator struct {
+	secret   []byte
+	ttl      time.Duration
+	required bool
+}
+
+// New returns an Authenticator signing tokens valid for ttl with secret.
+// When required is set, requests acting as a user must be authenticated;
+// otherwise anonymous requests may still act as anyone.
+func New(secret []byte, ttl time.Duration, required bool) *Authenticator {
+	return &Authenticator{secret: secret, ttl: ttl, required: required}
+}
+
+// Issue signs a token for nickName.
+func (a *Authenticator) Issue(nickName string) (token string, expires time.Time, err error) {
+	now := time.Now()
+	expires = now.Add(a.ttl)
+	claims := jwt.StandardClaims{
+		Subject:   nickName,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+	return
+}
+
+// Parse checks a token's signature and expiry and returns whom it was
+// issued to.
+func (a *Authenticator) Parse(token string) (Principal, error) {
+	claims := jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, ErrInvalidToken
+		}
+		return a.secret, nil
+	})
+	if err != nil || claims.Subject == "" {
+		return Principal{}, ErrInvalidToken
+	}
+	return Principal{NickName: claims.Subject}, nil
+}
+
+type contextKey struct{}
+
+type state struct {
+	principal *Principal
+	required  bool
+}
+
+// Middleware resolves the caller from an "Authorization: Bearer" header
+// and stores it in the request context. Requests with a bad token are
+// refused; requests without one go through anonymously.
+func (a *Authenticator) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			st := state{required: a.required}
+			header := ctx.Request().Header.Get(echo.HeaderAuthorization)
+			if header != "" {
+				token := strings.TrimPrefix(header, "Bearer ")
+				principal, err := a.Parse(token)
+				if err != nil || token == header {
+					return ctx.JSON(http.StatusUnauthorized, forum.ErrorMessage{Message: ErrInvalidToken.Error()})
+				}
+				st.principal = &principal
+			}
+			req := ctx.Request()
+			ctx.SetRequest(req.WithContext(context.WithValue(req.Context(), contextKey{}, st)))
+			return next(ctx)
+		}
+	}
+}
+
+// FromContext returns the authenticated caller, if there is one.
+func FromContext(ctx context.Context) (Principal, bool) {
+	st, _ := ctx.Value(contextKey{}).(state)
+	if st.principal == nil {
+		return Principal{}, false
+	}
+	return *st.principal, true
+}
+
+// Authorize checks that the caller may act as nickName: it must be that
+// user, or anonymous while authentication is not required.
+func Authorize(ctx context.Context, nickName string) error {
+	st, _ := ctx.Value(contextKey{}).(state)
+	if st.principal == nil {
+		if st.required {
+			return ErrUnauthenticated
+		}
+		return nil
+	}
+	if !strings.EqualFold(st.principal.NickName, nickName) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches hash. An empty hash, for
+// a user who never set a password, matches nothing.
+func CheckPassword(hash, password string) bool {
+	return hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func TestIssueAndParse(t *testing.T) {
+	a := New([]byte("secret"), time.Hour, false)
+	token, expires, err := a.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("token expires in %s", d)
+	}
+	p, err := a.Parse(token)
+	if err != nil || p.NickName != "alice" {
+		t.Errorf("Parse = %+v, %v", p, err)
+	}
+
+	if _, err := New([]byte("other"), time.Hour, false).Parse(token); err != ErrInvalidToken {
+		t.Errorf("token signed with another secret: %v", err)
+	}
+	expired, _, _ := New([]byte("secret"), -time.Minute, false).Issue("alice")
+	if _, err := a.Parse(expired); err != ErrInvalidToken {
+		t.Errorf("expired token: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	a := New([]byte("secret"), time.Hour, false)
+	token, _, _ := a.Issue("alice")
+
+	for _, tt := range []struct {
+		header string
+		code   int
+		as     string
+		want   error
+	}{
+		{"", http.StatusOK, "bob", nil},
+		{"Bearer " + token, http.StatusOK, "ALICE", nil},
+		{"Bearer " + token, http.StatusOK, "bob", ErrForbidden},
+		{"Bearer nonsense", http.StatusUnauthorized, "", nil},
+		{token, http.StatusUnauthorized, "", nil},
+	} {
+		var got error
+		handler := a.Middleware()(func(ctx echo.Context) error {
+			got = Authorize(ctx.Request().Context(), tt.as)
+			return ctx.NoContent(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(echo.HeaderAuthorization, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.code || got != tt.want {
+			t.Errorf("%q acting as %q: %d, %v; want %d, %v", tt.header, tt.as, rec.Code, got, tt.code, tt.want)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	a := New([]byte("secret"), time.Hour, true)
+	var got error
+	handler := a.Middleware()(func(ctx echo.Context) error {
+		got = Authorize(ctx.Request().Context(), "alice")
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if got != ErrUnauthenticated {
+		t.Errorf("anonymous request with auth required: %v", got)
+	}
+	if err := Authorize(context.Background(), "alice"); err != nil {
+		t.Errorf("Authorize outside the middleware: %v", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "hunter2") || CheckPassword(hash, "hunter3") || CheckPassword("", "") {
+		t.Error("CheckPassword")
+	}
+}
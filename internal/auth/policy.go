@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"tech-db/internal/forum"
+)
+
+// Policy decides what the caller may do to content it did not write,
+// using the roles kept in the database: admins may do anything, and a
+// forum's owner and moderators may edit and delete in that forum. Its
+// checks always want an authenticated caller, whether or not
+// authentication is required to act as a user.
+type Policy struct {
+	Roles forum.RoleRepository
+}
+
+func NewPolicy(roles forum.RoleRepository) *Policy {
+	return &Policy{Roles: roles}
+}
+
+// Admin checks that the caller is authenticated and holds the admin role.
+// Unlike Authorize it never lets anonymous requests through.
+func (p *Policy) Admin(ctx context.Context) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	admin, err := p.Roles.IsAdmin(ctx, principal.NickName)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Moderate checks that the caller may change something author wrote in f:
+// the caller is author or may moderate f. Anonymous callers may not, even
+// while authentication is not required.
+func (p *Policy) Moderate(ctx context.Context, f forum.Forum, author string) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if strings.EqualFold(principal.NickName, author) {
+		return nil
+	}
+	return p.ModerateForum(ctx, f)
+}
+
+// ModerateForum checks that the caller is authenticated and is f's owner,
+// one of its moderators or an admin.
+func (p *Policy) ModerateForum(ctx context.Context, f forum.Forum) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if strings.EqualFold(principal.NickName, f.User) {
+		return nil
+	}
+	moderator, err := p.Roles.IsModerator(ctx, f.Slug, principal.NickName)
+	if err != nil || moderator {
+		return err
+	}
+	return p.Admin(ctx)
+}
+
+// Own checks that the caller is authenticated and may manage f: it is f's
+// owner or an admin.
+func (p *Policy) Own(ctx context.Context, f forum.Forum) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if strings.EqualFold(principal.NickName, f.User) {
+		return nil
+	}
+	return p.Admin(ctx)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"tech-db/internal/forum"
+	"tech-db/internal/forum/memory"
+)
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	for _, nick := range []string{"owner", "mod", "admin", "author", "other"} {
+		if err := store.Users.InsertUser(ctx, forum.User{NickName: nick, Email: nick + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Forums.InsertForum(ctx, forum.Forum{Slug: "go", Title: "Go", User: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Roles.InsertModerator(ctx, "go", "mod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Roles.SetAdmin(ctx, "admin", true); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := store.Forums.SelectForumBySlug(ctx, "go")
+	p := NewPolicy(store.Roles)
+
+	as := func(nick string, required bool) context.Context {
+		st := state{required: required}
+		if nick != "" {
+			st.principal = &Principal{NickName: nick}
+		}
+		return context.WithValue(ctx, contextKey{}, st)
+	}
+	for _, tt := range []struct {
+		caller                 string
+		required               bool
+		admin, mod, forum, own error
+	}{
+		{"", false, ErrUnauthenticated, ErrUnauthenticated, ErrUnauthenticated, ErrUnauthenticated},
+		{"", true, ErrUnauthenticated, ErrUnauthenticated, ErrUnauthenticated, ErrUnauthenticated},
+		{"author", false, ErrForbidden, nil, ErrForbidden, ErrForbidden},
+		{"other", false, ErrForbidden, ErrForbidden, ErrForbidden, ErrForbidden},
+		{"mod", false, ErrForbidden, nil, nil, ErrForbidden},
+		{"OWNER", false, ErrForbidden, nil, nil, nil},
+		{"admin", true, nil, nil, nil, nil},
+	} {
+		c := as(tt.caller, tt.required)
+		if err := p.Admin(c); err != tt.admin {
+			t.Errorf("%q Admin = %v, want %v", tt.caller, err, tt.admin)
+		}
+		if err := p.Moderate(c, f, "author"); err != tt.mod {
+			t.Errorf("%q Moderate = %v, want %v", tt.caller, err, tt.mod)
+		}
+		if err := p.ModerateForum(c, f); err != tt.forum {
+			t.Errorf("%q ModerateForum = %v, want %v", tt.caller, err, tt.forum)
+		}
+		if err := p.Own(c, f); err != tt.own {
+			t.Errorf("%q Own = %v, want %v", tt.caller, err, tt.own)
+		}
+	}
+}
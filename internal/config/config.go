@@ -0,0 +1,350 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Duration is a time.Duration that can be read from "5s"-style strings in
+// YAML and TOML files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+type Database struct {
+	URL              string   `yaml:"url" toml:"url"`
+	Host             string   `yaml:"host" toml:"host"`
+	Port             int      `yaml:"port" toml:"port"`
+	Name             string   `yaml:"name" toml:"name"`
+	User             string   `yaml:"user" toml:"user"`
+	Password         string   `yaml:"password" toml:"password"`
+	SSLMode          string   `yaml:"sslmode" toml:"sslmode"`
+	MaxConnections   int      `yaml:"max_connections" toml:"max_connections"`
+	AcquireTimeout   Duration `yaml:"acquire_timeout" toml:"acquire_timeout"`
+	StatementTimeout Duration `yaml:"statement_timeout" toml:"statement_timeout"`
+}
+
+type Auth struct {
+	Secret   string   `yaml:"secret" toml:"secret"`
+	TokenTTL Duration `yaml:"token_ttl" toml:"token_ttl"`
+	Required bool     `yaml:"required" toml:"required"`
+}
+
+type Config struct {
+	Listen          string   `yaml:"listen" toml:"listen"`
+	LogLevel        string   `yaml:"log_level" toml:"log_level"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Migrate         bool     `yaml:"migrate" toml:"migrate"`
+	SlowQuery       Duration `yaml:"slow_query" toml:"slow_query"`
+	AdminToken      string   `yaml:"admin_token" toml:"admin_token"`
+	LiveNotify      bool     `yaml:"live_notify" toml:"live_notify"`
+	WebhookInterval Duration `yaml:"webhook_interval" toml:"webhook_interval"`
+	Auth            Auth     `yaml:"auth" toml:"auth"`
+	Database        Database `yaml:"database" toml:"database"`
+}
+
+var logLevels = []string{"debug", "info", "warn", "error", "off"}
+
+func Default() Config {
+	return Config{
+		Listen:          "0.0.0.0:5000",
+		LogLevel:        "warn",
+		ShutdownTimeout: Duration(10 * time.Second),
+		Migrate:         true,
+		SlowQuery:       Duration(500 * time.Millisecond),
+		WebhookInterval: Duration(time.Second),
+		Auth: Auth{
+			TokenTTL: Duration(24 * time.Hour),
+		},
+		Database: Database{
+			Host:           "localhost",
+			Port:           5432,
+			Name:           "forum",
+			User:           "forum",
+			Password:       "forum",
+			SSLMode:        "disable",
+			MaxConnections: 2000,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, an optional YAML/TOML file, the environment and the
+// command line flags in args. The arguments left after the flags are
+// returned as well.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	path := os.Getenv("CONFIG_FILE")
+	if p, ok := lookupFlag(args, "config"); ok {
+		path = p
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, nil, err
+	}
+
+	fs := flag.NewFlagSet("tech-db", flag.ContinueOnError)
+	fs.String("config", path, "path to a YAML or TOML config file")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "address to listen on")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: "+strings.Join(logLevels, ", "))
+	fs.DurationVar((*time.Duration)(&cfg.ShutdownTimeout), "shutdown-timeout", time.Duration(cfg.ShutdownTimeout), "how long to wait for in-flight requests on shutdown")
+	fs.DurationVar((*time.Duration)(&cfg.SlowQuery), "slow-query", time.Duration(cfg.SlowQuery), "log statements taking at least this long, 0 disables it")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "token admin requests send in X-Admin-Token, empty disables admin endpoints")
+	fs.StringVar(&cfg.Auth.Secret, "jwt-secret", cfg.Auth.Secret, "key signing login tokens, random per process if empty")
+	fs.DurationVar((*time.Duration)(&cfg.Auth.TokenTTL), "token-ttl", time.Duration(cfg.Auth.TokenTTL), "how long login tokens stay valid")
+	fs.BoolVar(&cfg.Auth.Required, "auth-required", cfg.Auth.Required, "refuse anonymous requests that act as a user")
+	fs.BoolVar(&cfg.LiveNotify, "live-notify", cfg.LiveNotify, "share live feed events with other instances through postgres LISTEN/NOTIFY")
+	fs.DurationVar((*time.Duration)(&cfg.WebhookInterval), "webhook-interval", time.Duration(cfg.WebhookInterval), "how often to send queued webhook deliveries, 0 leaves them to other instances")
+	fs.BoolVar(&cfg.Migrate, "migrate", cfg.Migrate, "apply pending schema migrations on startup")
+	fs.StringVar(&cfg.Database.URL, "db-url", cfg.Database.URL, "postgres connection string, overrides the other db-* connection flags")
+	fs.StringVar(&cfg.Database.Host, "db-host", cfg.Database.Host, "postgres host")
+	fs.IntVar(&cfg.Database.Port, "db-port", cfg.Database.Port, "postgres port")
+	fs.StringVar(&cfg.Database.Name, "db-name", cfg.Database.Name, "postgres database")
+	fs.StringVar(&cfg.Database.User, "db-user", cfg.Database.User, "postgres user")
+	fs.StringVar(&cfg.Database.Password, "db-password", cfg.Database.Password, "postgres password")
+	fs.StringVar(&cfg.Database.SSLMode, "db-sslmode", cfg.Database.SSLMode, "postgres sslmode")
+	fs.IntVar(&cfg.Database.MaxConnections, "db-max-conns", cfg.Database.MaxConnections, "connection pool size")
+	fs.DurationVar((*time.Duration)(&cfg.Database.AcquireTimeout), "db-acquire-timeout", time.Duration(cfg.Database.AcquireTimeout), "how long to wait for a pooled connection, 0 waits forever")
+	fs.DurationVar((*time.Duration)(&cfg.Database.StatementTimeout), "db-statement-timeout", time.Duration(cfg.Database.StatementTimeout), "postgres statement_timeout, 0 disables it")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return errors.Wrap(err, "invalid listen address")
+	}
+	if !isLogLevel(c.LogLevel) {
+		return errors.Errorf("unknown log level %q", c.LogLevel)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+	if c.SlowQuery < 0 {
+		return errors.New("slow query threshold must not be negative")
+	}
+	if c.WebhookInterval < 0 {
+		return errors.New("webhook interval must not be negative")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		return errors.New("token ttl must be positive")
+	}
+	db := c.Database
+	if db.URL == "" {
+		if db.Host == "" || db.Name == "" || db.User == "" {
+			return errors.New("database host, name and user are required")
+		}
+		if db.Port <= 0 || db.Port > 65535 {
+			return errors.Errorf("invalid database port %d", db.Port)
+		}
+	}
+	if db.MaxConnections < 1 {
+		return errors.Errorf("database max connections must be positive, got %d", db.MaxConnections)
+	}
+	if db.AcquireTimeout < 0 || db.StatementTimeout < 0 {
+		return errors.New("database timeouts must not be negative")
+	}
+	return nil
+}
+
+// ConnectionString returns Database.URL when set and otherwise builds a
+// postgres URL from the individual connection settings.
+func (db Database) ConnectionString() string {
+	if db.URL != "" {
+		return db.URL
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(db.User, db.Password),
+		Host:     net.JoinHostPort(db.Host, strconv.Itoa(db.Port)),
+		Path:     "/" + db.Name,
+		RawQuery: url.Values{"sslmode": {db.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "read config")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".toml":
+		_, err = toml.Decode(string(data), cfg)
+	default:
+		return errors.Errorf("unsupported config file %q, expected .yaml, .yml or .toml", path)
+	}
+	return errors.Wrapf(err, "parse config %s", path)
+}
+
+func loadEnv(cfg *Config) error {
+	db := &cfg.Database
+	if port, ok := os.LookupEnv("PORT"); ok {
+		host, _, err := net.SplitHostPort(cfg.Listen)
+		if err != nil {
+			host = ""
+		}
+		cfg.Listen = net.JoinHostPort(host, port)
+	}
+	envString("LISTEN", &cfg.Listen)
+	envString("LOG_LEVEL", &cfg.LogLevel)
+	envString("ADMIN_TOKEN", &cfg.AdminToken)
+	envString("JWT_SECRET", &cfg.Auth.Secret)
+	if err := envDuration("TOKEN_TTL", &cfg.Auth.TokenTTL); err != nil {
+		return err
+	}
+	if err := envBool("AUTH_REQUIRED", &cfg.Auth.Required); err != nil {
+		return err
+	}
+	envString("DATABASE_URL", &db.URL)
+	envString("POSTGRES_HOST", &db.Host)
+	envString("POSTGRES_DB", &db.Name)
+	envString("POSTGRES_USER", &db.User)
+	envString("POSTGRES_PASSWORD", &db.Password)
+	envString("POSTGRES_SSLMODE", &db.SSLMode)
+	if err := envDuration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout); err != nil {
+		return err
+	}
+	if err := envDuration("SLOW_QUERY", &cfg.SlowQuery); err != nil {
+		return err
+	}
+	if err := envBool("MIGRATE", &cfg.Migrate); err != nil {
+		return err
+	}
+	if err := envBool("LIVE_NOTIFY", &cfg.LiveNotify); err != nil {
+		return err
+	}
+	if err := envDuration("WEBHOOK_INTERVAL", &cfg.WebhookInterval); err != nil {
+		return err
+	}
+	if err := envInt("POSTGRES_PORT", &db.Port); err != nil {
+		return err
+	}
+	if err := envInt("DB_MAX_CONNECTIONS", &db.MaxConnections); err != nil {
+		return err
+	}
+	if err := envDuration("DB_ACQUIRE_TIMEOUT", &db.AcquireTimeout); err != nil {
+		return err
+	}
+	return envDuration("DB_STATEMENT_TIMEOUT", &db.StatementTimeout)
+}
+
+func envString(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = v
+	}
+}
+
+func envInt(name string, dst *int) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", name)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", name)
+	}
+	*dst = b
+	return nil
+}
+
+func envDuration(name string, dst *Duration) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	return errors.Wrapf(dst.UnmarshalText([]byte(v)), "invalid %s", name)
+}
+
+// lookupFlag finds -name/--name in args without parsing the rest, so the
+// config file can be loaded before the flags that override it.
+func lookupFlag(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		trimmed := strings.TrimLeft(arg, "-")
+		if trimmed == arg || len(arg)-len(trimmed) > 2 {
+			continue
+		}
+		if trimmed == name && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(trimmed, name+"=") {
+			return strings.TrimPrefix(trimmed, name+"="), true
+		}
+	}
+	return "", false
+}
+
+func isLogLevel(level string) bool {
+	for _, l := range logLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) String() string {
+	db := c.Database
+	db.Password = "xxx"
+	if db.URL != "" {
+		if u, err := url.Parse(db.URL); err == nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "xxx")
+			}
+			db.URL = u.String()
+		}
+	}
+	return fmt.Sprintf("listen=%s log_level=%s shutdown_timeout=%s slow_query=%s admin=%t auth_required=%t token_ttl=%s migrate=%t live_notify=%t webhook_interval=%s db=%s max_conns=%d acquire_timeout=%s statement_timeout=%s",
+		c.Listen, c.LogLevel, time.Duration(c.ShutdownTimeout), time.Duration(c.SlowQuery), c.AdminToken != "", c.Auth.Required, time.Duration(c.Auth.TokenTTL), c.Migrate, c.LiveNotify, time.Duration(c.WebhookInterval), db.ConnectionString(), db.MaxConnections,
+		time.Duration(db.AcquireTimeout), time.Duration(db.StatementTimeout))
+}
@@ -0,0 +1,135 @@
+// Package diff computes line and word diffs between two texts.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Change is a run of text that is in both texts, only in the new one or
+// only in the old one.
+type Change struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table. Texts that would need a bigger one are
+// reported as replaced wholesale after their common prefix and suffix.
+const maxCells = 4 << 20
+
+// Lines diffs a and b line by line. Every line keeps its newline so the
+// changes concatenate back to the texts.
+func Lines(a, b string) []Change {
+	return diff(splitLines(a), splitLines(b))
+}
+
+// Words diffs a and b word by word, treating runs of whitespace as words of
+// their own.
+func Words(a, b string) []Change {
+	return diff(splitWords(a), splitWords(b))
+}
+
+func diff(a, b []string) []Change {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var changes []Change
+	add := func(op Op, tokens ...string) {
+		if len(tokens) == 0 {
+			return
+		}
+		text := strings.Join(tokens, "")
+		if n := len(changes); n > 0 && changes[n-1].Op == op {
+			changes[n-1].Text += text
+			return
+		}
+		changes = append(changes, Change{Op: op, Text: text})
+	}
+
+	add(Equal, a[:prefix]...)
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(midA)+1)*(len(midB)+1) > maxCells {
+		add(Delete, midA...)
+		add(Insert, midB...)
+	} else {
+		lcs(midA, midB, add)
+	}
+	add(Equal, a[len(a)-suffix:]...)
+	return changes
+}
+
+// lcs walks a longest common subsequence table of a and b, reporting
+// deletions before insertions within each changed run.
+func lcs(a, b []string, add func(op Op, tokens ...string)) {
+	width := len(b) + 1
+	table := make([]int, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else if table[(i+1)*width+j] >= table[i*width+j+1] {
+				table[i*width+j] = table[(i+1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	var inserted []string
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			add(Insert, inserted...)
+			inserted = inserted[:0]
+			add(Equal, a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && table[(i+1)*width+j] >= table[i*width+j+1]):
+			add(Delete, a[i])
+			i++
+		default:
+			inserted = append(inserted, b[j])
+			j++
+		}
+	}
+	add(Insert, inserted...)
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitWords(s string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want []Change
+	}{
+		{"", "", nil},
+		{"same\n", "same\n", []Change{{Equal, "same\n"}}},
+		{"", "new\n", []Change{{Insert, "new\n"}}},
+		{"a\nb\nc\n", "a\nB\nc\n", []Change{{Equal, "a\n"}, {Delete, "b\n"}, {Insert, "B\n"}, {Equal, "c\n"}}},
+		{"a\nb\nc", "a\nc\nd", []Change{{Equal, "a\n"}, {Delete, "b\nc"}, {Insert, "c\nd"}}},
+		{"x\na\ny\nb\n", "a\nz\nb\n", []Change{{Delete, "x\n"}, {Equal, "a\n"}, {Delete, "y\n"}, {Insert, "z\n"}, {Equal, "b\n"}}},
+	} {
+		if got := Lines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	got := Words("the quick  brown fox", "the slow  brown dog")
+	want := []Change{
+		{Equal, "the "},
+		{Delete, "quick"},
+		{Insert, "slow"},
+		{Equal, "  brown "},
+		{Delete, "fox"},
+		{Insert, "dog"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words = %v, want %v", got, want)
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	a := "één twee\ndrie\n\nvier vijf zes\n"
+	b := "één 2\ndrie\nvier vijf\nzeven\n"
+	for name, changes := range map[string][]Change{"lines": Lines(a, b), "words": Words(a, b)} {
+		var old, new strings.Builder
+		for _, c := range changes {
+			if c.Op != Insert {
+				old.WriteString(c.Text)
+			}
+			if c.Op != Delete {
+				new.WriteString(c.Text)
+			}
+		}
+		if old.String() != a || new.String() != b {
+			t.Errorf("%s: rebuilt %q and %q", name, old.String(), new.String())
+		}
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	a := strings.Repeat("a\n", 3000)
+	b := strings.Repeat("b\n", 3000)
+	got := Lines("head\n"+a+"tail\n", "head\n"+b+"tail\n")
+	want := []Change{{Equal, "head\n"}, {Delete, a}, {Insert, b}, {Equal, "tail\n"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lines over the size limit = %d changes", len(got))
+	}
+}
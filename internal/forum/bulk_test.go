@@ -0,0 +1,101 @@
+package forum_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+// BenchmarkCreatePosts compares adding a batch of posts with one INSERT
+// after per-post lookups to adding it with COPY after set-based ones. Each
+// batch is rolled back, so every run starts from the same thread. Sizes
+// stop at 8000 posts: the INSERT binds up to 8 parameters a post, and a
+// Postgres statement takes at most 65535.
+//
+//	go test ./internal/forum -run '^$' -bench CreatePosts
+func BenchmarkCreatePosts(b *testing.B) {
+	if db == nil {
+		b.Skip(skipReason)
+	}
+	ctx := context.Background()
+	thread, forumId, roots := benchThread(b, ctx)
+
+	for _, size := range []int{10, 100, 1000, 8000} {
+		batch := make([]forum.Post, size)
+		for i := range batch {
+			batch[i] = forum.Post{Author: "user" + strconv.Itoa(i%100), Message: "message " + strconv.Itoa(i)}
+			if i%2 == 1 {
+				batch[i].Parent = roots[i%len(roots)].Id
+			}
+		}
+		for _, path := range []struct {
+			name   string
+			create func(*forum.PostService, context.Context, forum.Thread, int, string, []forum.Post) ([]forum.Post, error)
+		}{
+			{"insert", forum.InsertPosts},
+			{"copy", forum.CopyPosts},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", path.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					tx, err := db.BeginEx(ctx, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+					posts, err := path.create(forum.NewPostService(tx), ctx, thread, forumId, "2020-01-01T00:00:00Z", batch)
+					b.StopTimer()
+					tx.Rollback()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(posts) != size {
+						b.Fatalf("created %d posts, want %d", len(posts), size)
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+// benchThread makes a thread with 100 root posts, written by 100 users.
+func benchThread(b *testing.B, ctx context.Context) (forum.Thread, int, []forum.Post) {
+	forums := forum.NewForumService(db)
+	if err := forums.Clean(ctx); err != nil {
+		b.Fatal(err)
+	}
+	users := forum.NewUserService(db)
+	for i := 0; i < 100; i++ {
+		nickName := "user" + strconv.Itoa(i)
+		if err := users.InsertUser(ctx, forum.User{NickName: nickName, Email: nickName + "@example.com"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := forums.InsertForum(ctx, forum.Forum{Slug: "bench", Title: "Bench", User: "user0"}); err != nil {
+		b.Fatal(err)
+	}
+	f, err := forums.SelectForumBySlug(ctx, "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	thread := forum.Thread{Author: "user0", Created: time.Now(), Forum: f.Slug, ForumId: f.Id, Message: "bench", Title: "Bench"}
+	if thread.Id, err = forum.NewThreadService(db).InsertThread(ctx, thread); err != nil {
+		b.Fatal(err)
+	}
+
+	roots := make([]forum.Post, 100)
+	for i := range roots {
+		roots[i] = forum.Post{Author: "user" + strconv.Itoa(i), Message: "root " + strconv.Itoa(i)}
+	}
+	err = forum.NewTxManager(db).InTx(ctx, func(tx forum.Tx) error {
+		roots, err = tx.Posts.CreatePosts(ctx, thread, f.Id, "2020-01-01T00:00:00Z", roots)
+		return err
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return thread, f.Id, roots
+}
@@ -0,0 +1,45 @@
+package forum
+
+import "context"
+
+// DeletePost soft-deletes a post on behalf of by and takes it off its
+// forum's post count. The post stays in place as a tombstone. It should run
+// inside a transaction.
+func DeletePost(ctx context.Context, tx Tx, id int, by string) error {
+	removal, err := tx.Posts.DeletePost(ctx, id, by)
+	if err != nil {
+		return err
+	}
+	return tx.Forums.UpdateCounts(ctx, removal.Forum, -removal.Threads, -removal.Posts)
+}
+
+// DeleteThread soft-deletes a thread on behalf of by, hiding it and its
+// posts, and takes them off its forum's counts. It should run inside a
+// transaction.
+func DeleteThread(ctx context.Context, tx Tx, id int, by string) error {
+	removal, err := tx.Threads.DeleteThread(ctx, id, by)
+	if err != nil {
+		return err
+	}
+	return tx.Forums.UpdateCounts(ctx, removal.Forum, -removal.Threads, -removal.Posts)
+}
+
+// PurgePost removes a post and every reply under it for good, deleted or
+// not. It should run inside a transaction.
+func PurgePost(ctx context.Context, tx Tx, id int) error {
+	removal, err := tx.Posts.PurgePost(ctx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Forums.UpdateCounts(ctx, removal.Forum, -removal.Threads, -removal.Posts)
+}
+
+// PurgeThread removes a thread with its posts and votes for good, deleted
+// or not. It should run inside a transaction.
+func PurgeThread(ctx context.Context, tx Tx, id int) error {
+	removal, err := tx.Threads.PurgeThread(ctx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Forums.UpdateCounts(ctx, removal.Forum, -removal.Threads, -removal.Posts)
+}
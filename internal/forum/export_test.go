@@ -0,0 +1,7 @@
+package forum
+
+// The two ways CreatePosts adds posts, for BenchmarkCreatePosts to compare.
+var (
+	InsertPosts = (*PostService).insertPosts
+	CopyPosts   = (*PostService).copyPosts
+)
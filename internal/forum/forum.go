@@ -1,81 +1,127 @@
 package forum
 
-import (
-	"github.com/jackc/pgx"
-)
+import "context"
 
 type ForumService struct {
-	db *pgx.ConnPool
+	db Querier
 }
 
-func NewForumService(db *pgx.ConnPool) *ForumService {
+func NewForumService(db Querier) *ForumService {
 	return &ForumService{db: db}
 }
 
-func (fs *ForumService) SelectFullForumBySlug(slug string) (forum Forum, err error) {
+func (fs *ForumService) SelectFullForumBySlug(ctx context.Context, slug string) (forum Forum, err error) {
 	sqlQuery := `SELECT f.slug, f.title, f.user FROM forum as f where f.slug=$1`
-	err = fs.db.QueryRow(sqlQuery, slug).Scan(&forum.Slug, &forum.Title, &forum.User)
+	err = fs.db.QueryRowEx(ctx, sqlQuery, nil, slug).Scan(&forum.Slug, &forum.Title, &forum.User)
 	if err != nil {
 		return
 	}
-	sqlQuery = `SELECT count(*) FROM thread as t where t.forum=$1`
-	err = fs.db.QueryRow(sqlQuery, slug).Scan(&forum.Threads)
+	sqlQuery = `SELECT count(*) FROM thread as t where t.forum=$1 AND t.deleted_at IS NULL`
+	err = fs.db.QueryRowEx(ctx, sqlQuery, nil, slug).Scan(&forum.Threads)
 	if err != nil {
 		return
 	}
 	sqlQuery = `
-	SELECT count(*) FROM post as p where p.forum=$1`
-	err = fs.db.QueryRow(sqlQuery, slug).Scan(&forum.Posts)
+	SELECT count(*) FROM post as p JOIN thread as t ON t.id = p.thread
+	where p.forum=$1 AND p.deleted_at IS NULL AND t.deleted_at IS NULL`
+	err = fs.db.QueryRowEx(ctx, sqlQuery, nil, slug).Scan(&forum.Posts)
 	return
 }
 
-func (fs *ForumService) SelectForumBySlug(slug string) (forum Forum, err error) {
+func (fs *ForumService) SelectForumBySlug(ctx context.Context, slug string) (forum Forum, err error) {
 	sqlQuery := `
 	SELECT f.id, f.slug, f.title, f.user, f.threads, f.posts FROM forum as f where f.slug = $1`
-	err = fs.db.QueryRow(sqlQuery, slug).Scan(&forum.Id, &forum.Slug, &forum.Title, &forum.User, &forum.Threads, &forum.Posts)
+	err = fs.db.QueryRowEx(ctx, sqlQuery, nil, slug).Scan(&forum.Id, &forum.Slug, &forum.Title, &forum.User, &forum.Threads, &forum.Posts)
 	return
 }
 
-func (fs *ForumService) InsertForum(forum Forum) (err error) {
+func (fs *ForumService) InsertForum(ctx context.Context, forum Forum) (err error) {
 	sqlQuery := `INSERT INTO forum (slug, title, "user") VALUES ($1,$2,$3)`
-	_, err = fs.db.Exec(sqlQuery, forum.Slug, forum.Title, forum.User)
-	return
+	if _, err = fs.db.ExecEx(ctx, sqlQuery, nil, forum.Slug, forum.Title, forum.User); err != nil {
+		return
+	}
+	return appendChange(ctx, fs.db, ChangeForumInserted, forum.Slug, forum)
 }
 
-func (fs *ForumService) Clean() (err error) {
-	sqlQuery := `TRUNCATE vote, post, thread, forum, "user", forum_user RESTART IDENTITY CASCADE;`
-	_, err = fs.db.Exec(sqlQuery)
+// Clean empties every table but the outbox, which keeps its seq going for
+// readers of the change feed and gets an all.cleared change instead. Sent
+// without arguments, the statements go as one simple query and commit
+// together.
+func (fs *ForumService) Clean(ctx context.Context) (err error) {
+	sqlQuery := `
+	TRUNCATE vote, post, thread, forum, "user", forum_user, forum_moderator, webhook, webhook_delivery RESTART IDENTITY CASCADE;
+	INSERT INTO outbox (kind, key, data) VALUES ('` + ChangeAllCleared + `', '', '{}');`
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil)
 	return
 }
 
-func (fs *ForumService) SelectStatus() (status Status, err error) {
+// SelectStatus sums the shard rows that triggers on each table keep in
+// status_counter, so it costs the same regardless of how much data is
+// stored. Soft-deleted threads and posts are counted until they are purged.
+func (fs *ForumService) SelectStatus(ctx context.Context) (status Status, err error) {
 	sqlQuery := `
-	SELECT *
-	FROM (SELECT COUNT(*) AS post FROM post) AS Post,
-		 (SELECT COUNT(*) AS thread FROM thread) AS Thread,
-		 (SELECT COUNT(*) AS forum FROM forum) AS Forum,
-		 (SELECT COUNT(*) AS "user" FROM "user") AS Users;`
-	err = fs.db.QueryRow(sqlQuery).Scan(&status.Post, &status.Thread, &status.Forum, &status.User)
+	SELECT COALESCE(SUM(value) FILTER (WHERE name = 'post'), 0)::bigint,
+		   COALESCE(SUM(value) FILTER (WHERE name = 'thread'), 0)::bigint,
+		   COALESCE(SUM(value) FILTER (WHERE name = 'forum'), 0)::bigint,
+		   COALESCE(SUM(value) FILTER (WHERE name = 'user'), 0)::bigint
+	FROM status_counter;`
+	err = fs.db.QueryRowEx(ctx, sqlQuery, nil).Scan(&status.Post, &status.Thread, &status.Forum, &status.User)
 	return
 }
 
-func (fs *ForumService) UpdateThreadCount(forumId int) (err error) {
+func (fs *ForumService) UpdateThreadCount(ctx context.Context, forumId int) (err error) {
 	sqlQuery := `
 	UPDATE forum SET threads=threads+1 WHERE forum.id=$1`
-	_, err = fs.db.Exec(sqlQuery, forumId)
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil, forumId)
 	return
 }
 
-func (fs *ForumService) UpdatePostCount(forum string, count int) (err error) {
+func (fs *ForumService) UpdatePostCount(ctx context.Context, forum string, count int) (err error) {
 	sqlQuery := `
 	UPDATE forum SET posts=posts+$2 WHERE forum.slug=$1`
-	_, err = fs.db.Exec(sqlQuery, forum, count)
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil, forum, count)
+	return
+}
+
+// UpdateCounts adds threads and posts, which may be negative, to a forum's
+// counters.
+func (fs *ForumService) UpdateCounts(ctx context.Context, forum string, threads, posts int) (err error) {
+	sqlQuery := `
+	UPDATE forum SET threads=threads+$2, posts=posts+$3 WHERE forum.slug=$1`
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil, forum, threads, posts)
 	return
 }
 
-func (fs *ForumService) InsertForumUser(forumId int, userId int) (err error) {
+func (fs *ForumService) InsertForumUser(ctx context.Context, forumId int, userId int) (err error) {
 	sqlQuery := `
-	INSERT INTO forum_user (forum_id, user_id) VALUES ($1,$2)`
-	_, err = fs.db.Exec(sqlQuery, forumId, userId)
+	INSERT INTO forum_user (forum_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil, forumId, userId)
+	return
+}
+
+// SyncForumUsers makes each of the named users a member of the forum if
+// they wrote a thread or post in it, and takes them off it otherwise.
+func (fs *ForumService) SyncForumUsers(ctx context.Context, forum string, nickNames []string) (err error) {
+	if len(nickNames) == 0 {
+		return nil
+	}
+	sqlQuery := `
+	INSERT INTO forum_user (forum_id, user_id)
+	SELECT f.id, u.id FROM forum as f, "user" as u
+	WHERE f.slug=$1 AND u.nick_name = ANY ($2::text[]::citext[])
+		AND (EXISTS (SELECT 1 FROM thread as t WHERE t.forum=f.slug AND t.author=u.nick_name)
+			OR EXISTS (SELECT 1 FROM post as p WHERE p.author=u.nick_name AND p.forum=f.slug))
+	ON CONFLICT DO NOTHING`
+	if _, err = fs.db.ExecEx(ctx, sqlQuery, nil, forum, nickNames); err != nil {
+		return
+	}
+	sqlQuery = `
+	DELETE FROM forum_user as fu
+	USING forum as f, "user" as u
+	WHERE fu.forum_id=f.id AND fu.user_id=u.id
+		AND f.slug=$1 AND u.nick_name = ANY ($2::text[]::citext[])
+		AND NOT EXISTS (SELECT 1 FROM thread as t WHERE t.forum=f.slug AND t.author=u.nick_name)
+		AND NOT EXISTS (SELECT 1 FROM post as p WHERE p.author=u.nick_name AND p.forum=f.slug)`
+	_, err = fs.db.ExecEx(ctx, sqlQuery, nil, forum, nickNames)
 	return
 }
@@ -0,0 +1,129 @@
+package forumtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	"tech-db/internal/migrate"
+)
+
+// DatabaseURLEnv names an existing database to run the Postgres tests
+// against instead of starting a throwaway server. Its contents are wiped.
+const DatabaseURLEnv = "TECH_DB_TEST_DATABASE_URL"
+
+// ErrNoPostgres is returned by StartPostgres when neither DatabaseURLEnv is
+// set nor initdb and pg_ctl can be found.
+var ErrNoPostgres = errors.New("no postgres available: set " + DatabaseURLEnv + " or put initdb and pg_ctl on PATH")
+
+// StartPostgres connects to the database in DatabaseURLEnv or initializes
+// and starts a temporary server, and migrates it to the latest schema. The
+// returned stop function closes the pool and removes the temporary server.
+func StartPostgres() (db *pgx.ConnPool, stop func(), err error) {
+	url := os.Getenv(DatabaseURLEnv)
+	stop = func() {}
+	if url == "" {
+		url, stop, err = startServer()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err = connect(url)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	if _, err = migrate.New(db).Up(); err != nil {
+		db.Close()
+		stop()
+		return nil, nil, errors.Wrap(err, "migrate test database")
+	}
+
+	stopServer := stop
+	return db, func() {
+		db.Close()
+		stopServer()
+	}, nil
+}
+
+func connect(url string) (db *pgx.ConnPool, err error) {
+	config, err := pgx.ParseURI(url)
+	if err != nil {
+		return nil, err
+	}
+	// a fresh server can take a moment to accept connections
+	for i := 0; i < 50; i++ {
+		db, err = pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: config, MaxConnections: 10})
+		if err == nil {
+			return db, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+func startServer() (url string, stop func(), err error) {
+	initdb, errInit := findBinary("initdb")
+	pgCtl, errCtl := findBinary("pg_ctl")
+	if errInit != nil || errCtl != nil {
+		return "", nil, ErrNoPostgres
+	}
+
+	dir, err := ioutil.TempDir("", "tech-db-pg")
+	if err != nil {
+		return "", nil, err
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(initdb, "-D", data, "-U", "forum", "-A", "trust", "-E", "UTF8").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, errors.Wrapf(err, "initdb: %s", out)
+	}
+
+	options := fmt.Sprintf("-p %d -c listen_addresses=127.0.0.1 -k %s -F", port, dir)
+	out, err = exec.Command(pgCtl, "-D", data, "-o", options, "-l", filepath.Join(dir, "log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, errors.Wrapf(err, "pg_ctl start: %s", out)
+	}
+
+	stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("postgres://forum@127.0.0.1:%d/postgres?sslmode=disable", port), stop, nil
+}
+
+func findBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	// Debian and Ubuntu keep the server binaries out of PATH
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) > 0 {
+		return matches[len(matches)-1], nil
+	}
+	return "", errors.Errorf("%s not found", name)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
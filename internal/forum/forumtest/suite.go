@@ -0,0 +1,2129 @@
+// Package forumtest holds a conformance suite that every implementation of
+// the forum repositories must pass, and a helper to run it on Postgres.
+package forumtest
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+// Backend is one implementation of the forum repositories.
+type Backend struct {
+	Users    forum.UserRepository
+	Forums   forum.ForumRepository
+	Threads  forum.ThreadRepository
+	Posts    forum.PostRepository
+	Roles    forum.RoleRepository
+	Search   forum.SearchRepository
+	Webhooks forum.WebhookRepository
+	Outbox   forum.OutboxRepository
+	Tx       forum.Transactor
+}
+
+// Run runs the suite. newBackend is called once per test and must return a
+// backend with no data in it, though its outbox may hold earlier changes.
+func Run(t *testing.T, newBackend func(t *testing.T) Backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, b Backend)
+	}{
+		{"UserConflicts", testUserConflicts},
+		{"UpdateUser", testUpdateUser},
+		{"Password", testPassword},
+		{"Roles", testRoles},
+		{"ForumSlugConflict", testForumSlugConflict},
+		{"ForumCounters", testForumCounters},
+		{"ForumUsers", testForumUsers},
+		{"ThreadLookup", testThreadLookup},
+		{"ThreadsByForum", testThreadsByForum},
+		{"ThreadFlags", testThreadFlags},
+		{"CreatePosts", testCreatePosts},
+		{"CreatePostsErrors", testCreatePostsErrors},
+		{"CreatePostsLargeBatch", testCreatePostsLargeBatch},
+		{"EditPost", testEditPost},
+		{"PostHistory", testPostHistory},
+		{"SelectPosts", testSelectPosts},
+		{"Cursors", testCursors},
+		{"PageInjection", testPageInjection},
+		{"Votes", testVotes},
+		{"Rollback", testRollback},
+		{"StatusAndClean", testStatusAndClean},
+		{"DeletePost", testDeletePost},
+		{"DeleteThread", testDeleteThread},
+		{"Purge", testPurge},
+		{"MoveThread", testMoveThread},
+		{"MergeThreads", testMergeThreads},
+		{"SplitThread", testSplitThread},
+		{"Subtree", testSubtree},
+		{"Search", testSearch},
+		{"SearchEscapesSnippets", testSearchEscapesSnippets},
+		{"Webhooks", testWebhooks},
+		{"Outbox", testOutbox},
+		{"OutboxMovesAndRemovals", testOutboxMovesAndRemovals},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newBackend(t))
+		})
+	}
+}
+
+func mustUser(t *testing.T, b Backend, nickName string) forum.User {
+	ctx := context.Background()
+	t.Helper()
+	err := b.Users.InsertUser(ctx, forum.User{NickName: nickName, Email: nickName + "@example.com", FullName: "Full " + nickName, About: "about " + nickName})
+	if err != nil {
+		t.Fatalf("insert user %s: %v", nickName, err)
+	}
+	user, err := b.Users.FindUserByNickName(ctx, nickName)
+	if err != nil {
+		t.Fatalf("find user %s: %v", nickName, err)
+	}
+	return user
+}
+
+func mustForum(t *testing.T, b Backend, slug, owner string) forum.Forum {
+	ctx := context.Background()
+	t.Helper()
+	if err := b.Forums.InsertForum(ctx, forum.Forum{Slug: slug, Title: "Forum " + slug, User: owner}); err != nil {
+		t.Fatalf("insert forum %s: %v", slug, err)
+	}
+	f, err := b.Forums.SelectForumBySlug(ctx, slug)
+	if err != nil {
+		t.Fatalf("select forum %s: %v", slug, err)
+	}
+	return f
+}
+
+func mustThread(t *testing.T, b Backend, f forum.Forum, slug, author string, created time.Time) forum.Thread {
+	ctx := context.Background()
+	t.Helper()
+	thread := forum.Thread{Author: author, Created: created, Forum: f.Slug, ForumId: f.Id, Message: "message " + slug, Slug: slug, Title: "Title " + slug}
+	id, err := b.Threads.InsertThread(ctx, thread)
+	if err != nil {
+		t.Fatalf("insert thread %s: %v", slug, err)
+	}
+	if err = b.Forums.UpdateThreadCount(ctx, f.Id); err != nil {
+		t.Fatalf("update thread count: %v", err)
+	}
+	thread.Id = id
+	return thread
+}
+
+func mustPosts(t *testing.T, b Backend, thread forum.Thread, forumId int, created string, posts ...forum.Post) []forum.Post {
+	ctx := context.Background()
+	t.Helper()
+	var result []forum.Post
+	err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+		var err error
+		result, err = tx.Posts.CreatePosts(ctx, thread, forumId, created, posts)
+		if err != nil {
+			return err
+		}
+		return tx.Forums.UpdatePostCount(ctx, thread.Forum, len(posts))
+	})
+	if err != nil {
+		t.Fatalf("create posts: %v", err)
+	}
+	return result
+}
+
+func postIds(posts []forum.Post) []int {
+	ids := []int{}
+	for _, p := range posts {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func testUserConflicts(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+
+	if err := b.Users.InsertUser(ctx, forum.User{NickName: "ALICE", Email: "new@example.com", FullName: "x"}); err == nil {
+		t.Error("inserting a nickname that differs only in case succeeded")
+	}
+	if err := b.Users.InsertUser(ctx, forum.User{NickName: "carol", Email: "BOB@example.com", FullName: "x"}); err == nil {
+		t.Error("inserting a duplicate email succeeded")
+	}
+
+	users, err := b.Users.SelectUserByNickNameOrEmail(ctx, "Alice", "bob@EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nicks []string
+	for _, u := range users {
+		nicks = append(nicks, u.NickName)
+	}
+	if len(nicks) != 2 || !(nicks[0] == "alice" && nicks[1] == "bob" || nicks[0] == "bob" && nicks[1] == "alice") {
+		t.Errorf("conflicting users = %v, want alice and bob", nicks)
+	}
+
+	user, err := b.Users.SelectUserByNickName(ctx, "ALICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := forum.User{NickName: "alice", Email: "alice@example.com", FullName: "Full alice", About: "about alice"}
+	if user != want {
+		t.Errorf("SelectUserByNickName = %+v, want %+v", user, want)
+	}
+
+	if _, err := b.Users.SelectUserByNickName(ctx, "nobody"); err != forum.ErrNotFound {
+		t.Errorf("missing user: got %v, want ErrNotFound", err)
+	}
+	if _, err := b.Users.FindUserByNickName(ctx, "nobody"); err != forum.ErrNotFound {
+		t.Errorf("missing user: got %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, b Backend) {
+	ctx := context.Background()
+	alice := mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+
+	err := b.Users.UpdateUser(ctx, forum.User{Id: alice.Id, Email: "alice@new.example.com", FullName: "Alice A", About: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := b.Users.SelectUserByNickName(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := forum.User{NickName: "alice", Email: "alice@new.example.com", FullName: "Alice A", About: "hi"}
+	if user != want {
+		t.Errorf("after update = %+v, want %+v", user, want)
+	}
+
+	if err := b.Users.UpdateUser(ctx, forum.User{Id: alice.Id, Email: "bob@example.com", FullName: "x"}); err == nil {
+		t.Error("updating to a taken email succeeded")
+	}
+}
+
+func testPassword(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+
+	if hash, err := b.Users.SelectPasswordHash(ctx, "alice"); err != nil || hash != "" {
+		t.Errorf("hash before setting a password = %q, %v", hash, err)
+	}
+	if err := b.Users.UpdatePassword(ctx, "ALICE", "$2a$10$hash"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := b.Users.SelectPasswordHash(ctx, "alice"); err != nil || hash != "$2a$10$hash" {
+		t.Errorf("hash = %q, %v", hash, err)
+	}
+	if err := b.Users.UpdatePassword(ctx, "nobody", "x"); err != forum.ErrNotFound {
+		t.Errorf("password of a missing user: got %v, want ErrNotFound", err)
+	}
+	if _, err := b.Users.SelectPasswordHash(ctx, "nobody"); err != forum.ErrNotFound {
+		t.Errorf("hash of a missing user: got %v, want ErrNotFound", err)
+	}
+}
+
+func testRoles(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	mustForum(t, b, "golang", "alice")
+
+	if admin, err := b.Roles.IsAdmin(ctx, "bob"); err != nil || admin {
+		t.Errorf("IsAdmin before granting = %v, %v", admin, err)
+	}
+	if err := b.Roles.SetAdmin(ctx, "BOB", true); err != nil {
+		t.Fatal(err)
+	}
+	if admin, err := b.Roles.IsAdmin(ctx, "bob"); err != nil || !admin {
+		t.Errorf("IsAdmin after granting = %v, %v", admin, err)
+	}
+	if err := b.Roles.SetAdmin(ctx, "bob", false); err != nil {
+		t.Fatal(err)
+	}
+	if admin, err := b.Roles.IsAdmin(ctx, "bob"); err != nil || admin {
+		t.Errorf("IsAdmin after revoking = %v, %v", admin, err)
+	}
+	if err := b.Roles.SetAdmin(ctx, "nobody", true); err != forum.ErrNotFound {
+		t.Errorf("SetAdmin of a missing user: got %v, want ErrNotFound", err)
+	}
+	if admin, err := b.Roles.IsAdmin(ctx, "nobody"); err != nil || admin {
+		t.Errorf("IsAdmin of a missing user = %v, %v", admin, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Roles.InsertModerator(ctx, "GOLANG", "Bob"); err != nil {
+			t.Fatalf("InsertModerator #%d: %v", i, err)
+		}
+	}
+	if mod, err := b.Roles.IsModerator(ctx, "golang", "bob"); err != nil || !mod {
+		t.Errorf("IsModerator = %v, %v", mod, err)
+	}
+	if mod, err := b.Roles.IsModerator(ctx, "golang", "alice"); err != nil || mod {
+		t.Errorf("owner IsModerator = %v, %v", mod, err)
+	}
+	mods, err := b.Roles.SelectModerators(ctx, "golang")
+	if err != nil || len(mods) != 1 || mods[0].NickName != "bob" {
+		t.Errorf("SelectModerators = %+v, %v", mods, err)
+	}
+	if err := b.Roles.InsertModerator(ctx, "rust", "bob"); err != forum.ErrNotFound {
+		t.Errorf("moderator of a missing forum: got %v, want ErrNotFound", err)
+	}
+	if err := b.Roles.InsertModerator(ctx, "golang", "nobody"); err != forum.ErrNotFound {
+		t.Errorf("missing moderator: got %v, want ErrNotFound", err)
+	}
+
+	if err := b.Roles.DeleteModerator(ctx, "golang", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Roles.DeleteModerator(ctx, "golang", "bob"); err != forum.ErrNotFound {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+	if mod, err := b.Roles.IsModerator(ctx, "golang", "bob"); err != nil || mod {
+		t.Errorf("IsModerator after removal = %v, %v", mod, err)
+	}
+}
+
+func testForumSlugConflict(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	if f.Slug != "golang" || f.Title != "Forum golang" || f.User != "alice" {
+		t.Errorf("forum = %+v", f)
+	}
+
+	if err := b.Forums.InsertForum(ctx, forum.Forum{Slug: "GoLang", Title: "dup", User: "alice"}); err == nil {
+		t.Error("inserting a slug that differs only in case succeeded")
+	}
+	if _, err := b.Forums.SelectForumBySlug(ctx, "GOLANG"); err != nil {
+		t.Errorf("case-insensitive lookup: %v", err)
+	}
+	if _, err := b.Forums.SelectForumBySlug(ctx, "rust"); err != forum.ErrNotFound {
+		t.Errorf("missing forum: got %v, want ErrNotFound", err)
+	}
+}
+
+func testForumCounters(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t1", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	mustThread(t, b, f, "t2", "alice", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "a"}, forum.Post{Author: "alice", Message: "b"})
+
+	f, err := b.Forums.SelectForumBySlug(ctx, "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Threads != 2 || f.Posts != 2 {
+		t.Errorf("counters threads=%d posts=%d, want 2 and 2", f.Threads, f.Posts)
+	}
+
+	full, err := b.Forums.SelectFullForumBySlug(ctx, "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Threads != 2 || full.Posts != 2 || full.Slug != "golang" || full.User != "alice" {
+		t.Errorf("full forum = %+v", full)
+	}
+}
+
+func testForumUsers(t *testing.T, b Backend) {
+	ctx := context.Background()
+	var users []forum.User
+	for _, nick := range []string{"dave", "alice", "Carol", "bob"} {
+		users = append(users, mustUser(t, b, nick))
+	}
+	mustUser(t, b, "outsider")
+	f := mustForum(t, b, "golang", "alice")
+	for _, u := range users {
+		if err := b.Forums.InsertForumUser(ctx, f.Id, u.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Forums.InsertForumUser(ctx, f.Id, users[0].Id); err != nil {
+		t.Errorf("inserting a forum user twice: %v", err)
+	}
+
+	for _, tt := range []struct {
+		limit int
+		since string
+		desc  string
+		want  []string
+	}{
+		{100, "", "false", []string{"alice", "bob", "Carol", "dave"}},
+		{2, "", "false", []string{"alice", "bob"}},
+		{100, "", "true", []string{"dave", "Carol", "bob", "alice"}},
+		{100, "bob", "false", []string{"Carol", "dave"}},
+		{1, "carol", "true", []string{"bob"}},
+	} {
+		got, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: tt.limit, Since: tt.since, Desc: tt.desc == "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nicks := []string{}
+		for _, u := range got {
+			nicks = append(nicks, u.NickName)
+		}
+		if !reflect.DeepEqual(nicks, tt.want) {
+			t.Errorf("limit=%d since=%q desc=%s: got %v, want %v", tt.limit, tt.since, tt.desc, nicks, tt.want)
+		}
+	}
+}
+
+func testThreadLookup(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	thread := mustThread(t, b, f, "Hello-World", "alice", created)
+
+	for _, got := range []func() (forum.Thread, error){
+		func() (forum.Thread, error) { return b.Threads.SelectThreadBySlug(ctx, "hello-world") },
+		func() (forum.Thread, error) { return b.Threads.SelectThreadById(ctx, thread.Id) },
+		func() (forum.Thread, error) { return b.Threads.FindThreadBySlug(ctx, "HELLO-WORLD") },
+		func() (forum.Thread, error) { return b.Threads.FindThreadById(ctx, thread.Id) },
+	} {
+		th, err := got()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if th.Id != thread.Id || th.Slug != "Hello-World" || th.Author != "alice" || th.Forum != "golang" || th.Title != thread.Title || !th.Created.Equal(created) {
+			t.Errorf("thread = %+v, want %+v", th, thread)
+		}
+	}
+
+	if _, err := b.Threads.SelectThreadBySlug(ctx, "missing"); err != forum.ErrNotFound {
+		t.Errorf("missing slug: got %v, want ErrNotFound", err)
+	}
+	if _, err := b.Threads.FindThreadById(ctx, thread.Id+100); err != forum.ErrNotFound {
+		t.Errorf("missing id: got %v, want ErrNotFound", err)
+	}
+
+	thread.Title = "New title"
+	thread.Message = "New message"
+	if err := b.Threads.UpdateThread(ctx, thread); err != nil {
+		t.Fatal(err)
+	}
+	th, err := b.Threads.SelectThreadById(ctx, thread.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Title != "New title" || th.Message != "New message" {
+		t.Errorf("after update = %+v", th)
+	}
+}
+
+func testThreadsByForum(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	other := mustForum(t, b, "rust", "alice")
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var threads []forum.Thread
+	for i := 0; i < 4; i++ {
+		threads = append(threads, mustThread(t, b, f, "t"+strconv.Itoa(i), "alice", base.Add(time.Duration(i)*time.Hour)))
+	}
+	mustThread(t, b, other, "elsewhere", "alice", base)
+
+	since := base.Add(time.Hour).Format(time.RFC3339Nano)
+	for _, tt := range []struct {
+		limit int
+		since string
+		desc  bool
+		want  []int
+	}{
+		{100, "", false, []int{0, 1, 2, 3}},
+		{2, "", false, []int{0, 1}},
+		{100, "", true, []int{3, 2, 1, 0}},
+		{100, since, false, []int{1, 2, 3}},
+		{100, since, true, []int{1, 0}},
+		{1, since, true, []int{1}},
+	} {
+		got, err := b.Threads.SelectThreadByForum(ctx, "GoLang", forum.Page{Limit: tt.limit, Since: tt.since, Desc: tt.desc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, gotIds []int
+		for _, i := range tt.want {
+			want = append(want, threads[i].Id)
+		}
+		for _, th := range got {
+			gotIds = append(gotIds, th.Id)
+		}
+		if !reflect.DeepEqual(gotIds, want) {
+			t.Errorf("limit=%d since=%q desc=%t: got %v, want %v", tt.limit, tt.since, tt.desc, gotIds, want)
+		}
+	}
+}
+
+func testThreadFlags(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var threads []forum.Thread
+	for i := 0; i < 4; i++ {
+		threads = append(threads, mustThread(t, b, f, "t"+strconv.Itoa(i), "alice", base.Add(time.Duration(i)*time.Hour)))
+	}
+
+	pinned := threads[1]
+	pinned.Pinned = true
+	if err := b.Threads.UpdateThreadFlags(ctx, pinned); err != nil {
+		t.Fatal(err)
+	}
+	closed := threads[2]
+	closed.Locked, closed.Archived = true, true
+	if err := b.Threads.UpdateThreadFlags(ctx, closed); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Threads.SelectThreadById(ctx, closed.Id)
+	if err != nil || !got.Locked || !got.Archived || got.Pinned {
+		t.Errorf("flags by id = %+v, %v", got, err)
+	}
+	if got, err = b.Threads.FindThreadBySlug(ctx, "t2"); err != nil || !got.Locked || !got.Archived {
+		t.Errorf("flags by slug = %+v, %v", got, err)
+	}
+	if got.CanPost() != forum.ErrThreadArchived || got.CanChange() != forum.ErrThreadArchived {
+		t.Errorf("archived thread: CanPost %v, CanChange %v", got.CanPost(), got.CanChange())
+	}
+
+	since := base.Add(2 * time.Hour).Format(time.RFC3339Nano)
+	for _, tt := range []struct {
+		limit int
+		since string
+		desc  bool
+		want  []int
+	}{
+		{100, "", false, []int{1, 0, 2, 3}},
+		{100, "", true, []int{1, 3, 2, 0}},
+		{2, since, false, []int{1, 2}},
+		{100, since, true, []int{1, 2, 0}},
+	} {
+		got, err := b.Threads.SelectThreadByForum(ctx, "golang", forum.Page{Limit: tt.limit, Since: tt.since, Desc: tt.desc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, gotIds []int
+		for _, i := range tt.want {
+			want = append(want, threads[i].Id)
+		}
+		for _, th := range got {
+			gotIds = append(gotIds, th.Id)
+		}
+		if !reflect.DeepEqual(gotIds, want) {
+			t.Errorf("limit=%d since=%q desc=%t: got %v, want %v", tt.limit, tt.since, tt.desc, gotIds, want)
+		}
+	}
+
+	if err := b.Threads.UpdateThreadFlags(ctx, forum.Thread{Id: 999}); err != forum.ErrNotFound {
+		t.Errorf("flags of a missing thread: got %v, want ErrNotFound", err)
+	}
+}
+
+func testCreatePosts(t *testing.T, b Backend) {
+	ctx := context.Background()
+	alice := mustUser(t, b, "alice")
+	bob := mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z",
+		forum.Post{Author: "alice", Message: "root"},
+		forum.Post{Author: "bob", Message: "other root"})
+	if len(roots) != 2 {
+		t.Fatalf("created %d posts, want 2", len(roots))
+	}
+	replies := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:01Z",
+		forum.Post{Author: "bob", Message: "reply", Parent: roots[0].Id})
+
+	for _, p := range append(roots, replies...) {
+		if p.Id == 0 || p.Thread != thread.Id || p.Forum != "golang" || p.IsEdited {
+			t.Errorf("created post = %+v", p)
+		}
+	}
+
+	post, err := b.Posts.SelectPostById(ctx, replies[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := forum.Post{Author: "bob", Created: "2020-01-01T00:00:01Z", Forum: "golang", Id: replies[0].Id, Message: "reply", Parent: roots[0].Id, Thread: thread.Id}
+	if !reflect.DeepEqual(post, want) {
+		t.Errorf("SelectPostById = %+v, want %+v", post, want)
+	}
+	batch, err := b.Posts.SelectPostsByIds(ctx, []int{replies[0].Id, roots[0].Id, 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || batch[0].Id != roots[0].Id || !reflect.DeepEqual(batch[1], want) {
+		t.Errorf("SelectPostsByIds = %+v, want the root and %+v", batch, want)
+	}
+
+	if err := b.Posts.FindPostById(ctx, roots[0].Id, thread.Id); err != nil {
+		t.Errorf("FindPostById: %v", err)
+	}
+	if err := b.Posts.FindPostById(ctx, roots[0].Id, thread.Id+1); err != forum.ErrNotFound {
+		t.Errorf("FindPostById in another thread: got %v, want ErrNotFound", err)
+	}
+
+	users, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].NickName != alice.NickName || users[1].NickName != bob.NickName {
+		t.Errorf("forum users after posting = %+v", users)
+	}
+}
+
+func testCreatePostsErrors(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	other := mustThread(t, b, f, "other", "alice", time.Now())
+	foreign := mustPosts(t, b, other, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "x"})
+
+	for _, tt := range []struct {
+		post forum.Post
+		want error
+	}{
+		{forum.Post{Author: "nobody", Message: "x"}, forum.ErrAuthorNotFound},
+		{forum.Post{Author: "alice", Message: "x", Parent: foreign[0].Id}, forum.ErrParentConflict},
+		{forum.Post{Author: "alice", Message: "x", Parent: foreign[0].Id + 100}, forum.ErrParentConflict},
+	} {
+		err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+			_, err := tx.Posts.CreatePosts(ctx, thread, f.Id, "2020-01-01T00:00:00Z", []forum.Post{{Author: "alice", Message: "ok"}, tt.post})
+			return err
+		})
+		if err != tt.want {
+			t.Errorf("post %+v: got %v, want %v", tt.post, err, tt.want)
+		}
+	}
+
+	posts, err := b.Threads.SelectPosts(ctx, thread.Id, "flat", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("failed batches left %d posts behind", len(posts))
+	}
+}
+
+// testCreatePostsLargeBatch covers batches big enough for Postgres to load
+// them with COPY.
+func testCreatePostsLargeBatch(t *testing.T, b Backend) {
+	ctx := context.Background()
+	alice := mustUser(t, b, "alice")
+	bob := mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	other := mustThread(t, b, f, "other", "alice", time.Now())
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z",
+		forum.Post{Author: "alice", Message: "root"},
+		forum.Post{Author: "alice", Message: "other root"})
+	foreign := mustPosts(t, b, other, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "x"})
+
+	batch := make([]forum.Post, 250)
+	for i := range batch {
+		batch[i] = forum.Post{Author: []string{"Alice", "bob"}[i%2], Message: "m" + strconv.Itoa(i)}
+		if i%3 > 0 {
+			batch[i].Parent = roots[i%3-1].Id
+		}
+	}
+
+	for _, tt := range []struct {
+		post forum.Post
+		want error
+	}{
+		{forum.Post{Author: "nobody", Message: "x"}, forum.ErrAuthorNotFound},
+		{forum.Post{Author: "alice", Message: "x", Parent: foreign[0].Id}, forum.ErrParentConflict},
+	} {
+		err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+			_, err := tx.Posts.CreatePosts(ctx, thread, f.Id, "2020-01-01T00:00:01Z", append(batch[:len(batch):len(batch)], tt.post))
+			return err
+		})
+		if err != tt.want {
+			t.Errorf("batch with %+v: got %v, want %v", tt.post, err, tt.want)
+		}
+	}
+
+	created := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:01Z", batch...)
+	if len(created) != len(batch) {
+		t.Fatalf("created %d posts, want %d", len(created), len(batch))
+	}
+	for i, p := range created {
+		want := forum.Post{Id: p.Id, Author: batch[i].Author, Created: "2020-01-01T00:00:01Z", Forum: "golang", Message: batch[i].Message, Parent: batch[i].Parent, Thread: thread.Id}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("created post %d = %+v, want %+v", i, p, want)
+		}
+		if i > 0 && p.Id <= created[i-1].Id {
+			t.Errorf("post %d has id %d after %d", i, p.Id, created[i-1].Id)
+		}
+	}
+
+	// in tree order every reply follows the root it answers
+	tree, err := b.Threads.SelectPosts(ctx, thread.Id, "tree", forum.Page{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != len(batch)+len(roots) {
+		t.Fatalf("thread has %d posts, want %d", len(tree), len(batch)+len(roots))
+	}
+	root := 0
+	for _, p := range tree {
+		if p.Parent == 0 {
+			root = p.Id
+		} else if p.Parent != root {
+			t.Fatalf("post %d with parent %d follows root %d in the tree", p.Id, p.Parent, root)
+		}
+	}
+
+	users, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].NickName != alice.NickName || users[1].NickName != bob.NickName {
+		t.Errorf("forum users after posting = %+v", users)
+	}
+}
+
+func testEditPost(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "before"})
+
+	n, err := b.Posts.UpdatePostMessage(ctx, "after", posts[0].Id, "alice")
+	if err != nil || n != 1 {
+		t.Fatalf("UpdatePostMessage = %d, %v", n, err)
+	}
+	post, err := b.Posts.SelectPostById(ctx, posts[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Message != "after" || !post.IsEdited {
+		t.Errorf("edited post = %+v", post)
+	}
+
+	if n, err := b.Posts.UpdatePostMessage(ctx, "x", posts[0].Id+100, "alice"); err != nil || n != 0 {
+		t.Errorf("editing a missing post = %d, %v", n, err)
+	}
+}
+
+func testPostHistory(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "first"})
+	post := posts[0]
+
+	history, err := forum.PostHistory(ctx, b.Posts, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if len(history) != 1 || history[0].Revision != 0 || history[0].Message != "first" || history[0].Editor != "alice" || !history[0].Created.Equal(created) {
+		t.Fatalf("history of an unedited post = %+v", history)
+	}
+
+	for _, edit := range []struct{ message, editor string }{{"second", "alice"}, {"third", "bob"}} {
+		err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+			_, err := tx.Posts.UpdatePostMessage(ctx, edit.message, post.Id, edit.editor)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("edit to %q: %v", edit.message, err)
+		}
+	}
+
+	history, err = forum.PostHistory(ctx, b.Posts, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i, r := range history {
+		if r.Revision != i || r.Post != post.Id {
+			t.Errorf("revision %d = %+v", i, r)
+		}
+		got = append(got, r.Message+"/"+r.Editor)
+	}
+	if want := []string{"first/alice", "second/alice", "third/bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if !history[0].Created.Equal(created) || history[2].Created.Before(history[1].Created) {
+		t.Errorf("revision times %v, %v, %v", history[0].Created, history[1].Created, history[2].Created)
+	}
+
+	err = b.Tx.InTx(ctx, func(tx forum.Tx) error { return forum.PurgePost(ctx, tx, post.Id) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err := b.Posts.SelectPostRevisions(ctx, post.Id); err != nil || len(revisions) != 0 {
+		t.Errorf("revisions of a purged post = %+v, %v", revisions, err)
+	}
+}
+
+// testSelectPosts builds the thread
+//
+//	1
+//	├── 4
+//	│   └── 7
+//	└── 5
+//	2
+//	└── 6
+//	3
+//
+// where each line of ids was created in one batch.
+func testSelectPosts(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	other := mustThread(t, b, f, "other", "alice", time.Now())
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+
+	p := func(parent int) forum.Post { return forum.Post{Author: "alice", Message: "m", Parent: parent} }
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(0), p(0), p(0))
+	children := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:01Z", p(roots[0].Id), p(roots[0].Id), p(roots[1].Id))
+	grandchildren := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:02Z", p(children[0].Id))
+	mustPosts(t, b, other, f.Id, "2020-01-01T00:00:00Z", p(0))
+
+	// n maps the ids in the comment above to real ids
+	all := append(append(roots, children...), grandchildren...)
+	n := func(ids ...int) []int {
+		real := []int{}
+		for _, id := range ids {
+			real = append(real, all[id-1].Id)
+		}
+		return real
+	}
+	since := func(id int) string { return strconv.Itoa(all[id-1].Id) }
+
+	for _, tt := range []struct {
+		sort  string
+		limit int
+		since string
+		desc  bool
+		want  []int
+	}{
+		{"flat", 100, "", false, n(1, 2, 3, 4, 5, 6, 7)},
+		{"flat", 100, "", true, n(7, 6, 5, 4, 3, 2, 1)},
+		{"flat", 2, since(3), false, n(4, 5)},
+		{"flat", 10, since(5), true, n(4, 3, 2, 1)},
+
+		{"tree", 100, "", false, n(1, 4, 7, 5, 2, 6, 3)},
+		{"tree", 100, "", true, n(3, 6, 2, 5, 7, 4, 1)},
+		{"tree", 3, since(4), false, n(7, 5, 2)},
+		{"tree", 3, since(2), true, n(5, 7, 4)},
+
+		{"parent_tree", 2, "", false, n(1, 4, 7, 5, 2, 6)},
+		{"parent_tree", 1, "", true, n(3)},
+		{"parent_tree", 2, "", true, n(3, 2, 6)},
+		{"parent_tree", 5, since(1), false, n(2, 6, 3)},
+		{"parent_tree", 5, since(3), true, n(2, 6, 1, 4, 7, 5)},
+		{"parent_tree", 5, since(7), false, n(2, 6, 3)},
+	} {
+		posts, err := b.Threads.SelectPosts(ctx, thread.Id, tt.sort, forum.Page{Limit: tt.limit, Since: tt.since, Desc: tt.desc})
+		if err != nil {
+			t.Fatalf("%s limit=%d since=%s desc=%t: %v", tt.sort, tt.limit, tt.since, tt.desc, err)
+		}
+		if got := postIds(posts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s limit=%d since=%s desc=%t: got %v, want %v", tt.sort, tt.limit, tt.since, tt.desc, got, tt.want)
+		}
+	}
+
+	posts, err := b.Threads.SelectPosts(ctx, thread.Id, "tree", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPath := []int64{int64(all[0].Id), int64(all[3].Id), int64(all[6].Id)}
+	if !reflect.DeepEqual(posts[2].Path, wantPath) {
+		t.Errorf("path of post 7 = %v, want %v", posts[2].Path, wantPath)
+	}
+}
+
+// cursorPage is one page of a listing: what is on it and the cursor of
+// each item.
+type cursorPage struct {
+	items   []string
+	cursors []forum.Cursor
+}
+
+func (p *cursorPage) add(item string, c forum.Cursor) {
+	p.items = append(p.items, item)
+	p.cursors = append(p.cursors, c)
+}
+
+// testCursors pages through every listing two items at a time, forwards
+// from the start and then back from the end, and checks that the pages
+// join up into the whole listing. Threads and posts share creation times,
+// so only their ids keep the order stable.
+func testCursors(t *testing.T, b Backend) {
+	ctx := context.Background()
+	f := mustForum(t, b, "golang", mustUser(t, b, "alice").NickName)
+	for _, nick := range []string{"dave", "alice", "Carol", "bob", "eve"} {
+		u, err := b.Users.FindUserByNickName(ctx, nick)
+		if err != nil {
+			u = mustUser(t, b, nick)
+		}
+		if err := b.Forums.InsertForumUser(ctx, f.Id, u.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var threads []forum.Thread
+	for i, hours := range []int{0, 1, 0, 1, 0} {
+		threads = append(threads, mustThread(t, b, f, "t"+strconv.Itoa(i), "alice", base.Add(time.Duration(hours)*time.Hour)))
+	}
+	pinned := threads[3]
+	pinned.Pinned = true
+	if err := b.Threads.UpdateThreadFlags(ctx, pinned); err != nil {
+		t.Fatal(err)
+	}
+	thread := threads[0]
+	p := func(parent int) forum.Post { return forum.Post{Author: "alice", Message: "m", Parent: parent} }
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(0), p(0), p(0))
+	children := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(roots[2].Id), p(roots[0].Id), p(roots[2].Id))
+	mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(children[1].Id), p(0))
+
+	listings := map[string]func(limit int, after *forum.Cursor) (cursorPage, error){}
+	for _, desc := range []bool{false, true} {
+		desc := desc
+		suffix := " desc=" + strconv.FormatBool(desc)
+		listings["threads"+suffix] = func(limit int, after *forum.Cursor) (page cursorPage, err error) {
+			got, err := b.Threads.SelectThreadByForum(ctx, "golang", forum.Page{Limit: limit, Desc: desc, After: after})
+			for _, th := range got {
+				page.add(th.Slug, th.Cursor(desc))
+			}
+			return page, err
+		}
+		listings["users"+suffix] = func(limit int, after *forum.Cursor) (page cursorPage, err error) {
+			got, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: limit, Desc: desc, After: after})
+			for _, u := range got {
+				page.add(u.NickName, u.Cursor(desc))
+			}
+			return page, err
+		}
+		for _, sort := range []string{"flat", "tree", "parent_tree"} {
+			sort := sort
+			listings[sort+suffix] = func(limit int, after *forum.Cursor) (page cursorPage, err error) {
+				got, err := b.Threads.SelectPosts(ctx, thread.Id, sort, forum.Page{Limit: limit, Desc: desc, After: after})
+				for _, p := range got {
+					page.add(strconv.Itoa(p.Id), p.Cursor(sort, desc))
+				}
+				return page, err
+			}
+		}
+	}
+
+	for name, list := range listings {
+		all, err := list(100, nil)
+		if err != nil || len(all.items) < 5 {
+			t.Fatalf("%s: %v, %v", name, all.items, err)
+		}
+
+		var forwards []string
+		var after *forum.Cursor
+		for i := 0; i <= len(all.items); i++ {
+			page, err := list(2, after)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(page.items) == 0 {
+				break
+			}
+			forwards = append(forwards, page.items...)
+			after = &page.cursors[len(page.cursors)-1]
+		}
+		if !reflect.DeepEqual(forwards, all.items) {
+			t.Errorf("%s forwards: got %v, want %v", name, forwards, all.items)
+		}
+
+		// Going back from the last item leaves out everything at its
+		// position, which for parent_tree is its whole tree.
+		last := all.cursors[len(all.cursors)-1]
+		var want []string
+		for i, c := range all.cursors {
+			if !reflect.DeepEqual(c, last) {
+				want = append(want, all.items[i])
+			}
+		}
+		var backwards []string
+		before := last
+		for i := 0; i <= len(all.items); i++ {
+			before.Back = true
+			page, err := list(2, &before)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(page.items) == 0 {
+				break
+			}
+			backwards = append(page.items, backwards...)
+			before = page.cursors[0]
+		}
+		if !reflect.DeepEqual(backwards, want) {
+			t.Errorf("%s backwards: got %v, want %v", name, backwards, want)
+		}
+	}
+
+	page, err := listings["threads desc=false"](100, nil)
+	if want := []string{"t3", "t0", "t2", "t4", "t1"}; err != nil || !reflect.DeepEqual(page.items, want) {
+		t.Errorf("threads with equal creation times: got %v, %v, want %v", page.items, err, want)
+	}
+	page, err = listings["flat desc=true"](100, nil)
+	if want := postIds([]forum.Post{roots[0], roots[1], roots[2], children[0]}); err != nil || len(page.items) != 8 || page.items[7] != strconv.Itoa(want[0]) {
+		t.Errorf("flat posts with equal creation times, descending: got %v, %v", page.items, err)
+	}
+	if _, err := forum.ParseCursor("bm9uc2Vuc2U"); err != forum.ErrBadCursor {
+		t.Errorf("malformed cursor: got %v, want ErrBadCursor", err)
+	}
+}
+
+// testPageInjection checks that since, limit and messages only ever reach
+// the database as values, whatever they hold.
+func testPageInjection(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	messages := []string{"what? $1 ?", "'); DELETE FROM post; --", "$$ || pg_sleep(10) || $$"}
+	var batch []forum.Post
+	for _, m := range messages {
+		batch = append(batch, forum.Post{Author: "bob", Message: m})
+	}
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", batch...)
+	for i, p := range posts {
+		stored, err := b.Posts.SelectPostById(ctx, p.Id)
+		if err != nil || stored.Message != messages[i] {
+			t.Errorf("post %d message = %q, %v; want %q", p.Id, stored.Message, err, messages[i])
+		}
+	}
+
+	for _, sort := range []string{"flat", "tree", "parent_tree"} {
+		for _, since := range []string{"1; DROP TABLE post; --", "1 OR 1=1", "x"} {
+			if _, err := b.Threads.SelectPosts(ctx, thread.Id, sort, forum.Page{Limit: 10, Since: since}); err != forum.ErrBadPage {
+				t.Errorf("%s posts since %q: got %v, want ErrBadPage", sort, since, err)
+			}
+		}
+		if _, err := b.Threads.SelectPosts(ctx, thread.Id, sort, forum.Page{Limit: -1}); err != forum.ErrBadPage {
+			t.Errorf("%s posts with a negative limit: got %v, want ErrBadPage", sort, err)
+		}
+	}
+	if _, err := b.Threads.SelectPosts(ctx, thread.Id, "newest", forum.Page{Limit: 10}); err != forum.ErrBadSort {
+		t.Errorf("posts in an unknown sort: got %v, want ErrBadSort", err)
+	}
+	for _, since := range []string{"' OR 1=1 --", "2020-01-01T00:00:00Z'; DROP TABLE thread; --"} {
+		if _, err := b.Threads.SelectThreadByForum(ctx, "golang", forum.Page{Limit: 10, Since: since}); err != forum.ErrBadPage {
+			t.Errorf("threads since %q: got %v, want ErrBadPage", since, err)
+		}
+	}
+	if _, err := b.Threads.SelectThreadByForum(ctx, "golang", forum.Page{Limit: -1}); err != forum.ErrBadPage {
+		t.Errorf("threads with a negative limit: got %v, want ErrBadPage", err)
+	}
+
+	// a nickname since is any string, compared as one
+	users, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: 10, Since: "zzz' OR '1'='1"})
+	if err != nil || len(users) != 0 {
+		t.Errorf("users since an injected nickname = %+v, %v", users, err)
+	}
+	if _, err := b.Users.SelectUsersByForum(ctx, f.Id, forum.Page{Limit: -1}); err != forum.ErrBadPage {
+		t.Errorf("users with a negative limit: got %v, want ErrBadPage", err)
+	}
+
+	flat, err := b.Threads.SelectPosts(ctx, thread.Id, "flat", forum.Page{Limit: 10})
+	if err != nil || len(flat) != len(messages) {
+		t.Errorf("thread holds %d posts after the attempts, want %d: %v", len(flat), len(messages), err)
+	}
+}
+
+func testVotes(t *testing.T, b Backend) {
+	ctx := context.Background()
+	alice := mustUser(t, b, "alice")
+	bob := mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+
+	for _, step := range []struct {
+		user  forum.User
+		voice int
+		want  int
+	}{
+		{alice, 1, 1},
+		{alice, 1, 1},
+		{bob, 1, 2},
+		{alice, -1, 0},
+		{bob, -1, -2},
+		{bob, 1, 0},
+	} {
+		err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+			return forum.CastVote(ctx, tx.Threads, forum.Vote{UserId: step.user.Id, ThreadId: thread.Id, Voice: step.voice})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		th, err := b.Threads.SelectThreadById(ctx, thread.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if th.Votes != step.want {
+			t.Errorf("after %s voted %d: votes = %d, want %d", step.user.NickName, step.voice, th.Votes, step.want)
+		}
+	}
+
+	vote, err := b.Threads.SelectVote(ctx, forum.Vote{UserId: bob.Id, ThreadId: thread.Id})
+	if err != nil || vote.Voice != 1 {
+		t.Errorf("SelectVote = %+v, %v", vote, err)
+	}
+	if _, err := b.Threads.SelectVote(ctx, forum.Vote{UserId: bob.Id, ThreadId: thread.Id + 1}); err != forum.ErrNotFound {
+		t.Errorf("missing vote: got %v, want ErrNotFound", err)
+	}
+}
+
+func testRollback(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+
+	errAbort := forumtestError("abort")
+	err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+		if err := tx.Users.InsertUser(ctx, forum.User{NickName: "bob", Email: "bob@example.com", FullName: "Bob"}); err != nil {
+			return err
+		}
+		if err := tx.Forums.UpdateThreadCount(ctx, f.Id); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("InTx returned %v, want the callback's error", err)
+	}
+
+	if _, err := b.Users.FindUserByNickName(ctx, "bob"); err != forum.ErrNotFound {
+		t.Errorf("user inserted in a rolled back transaction: %v", err)
+	}
+	f, err = b.Forums.SelectForumBySlug(ctx, "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Threads != 0 {
+		t.Errorf("thread counter changed in a rolled back transaction: %d", f.Threads)
+	}
+}
+
+func testStatusAndClean(t *testing.T, b Backend) {
+	ctx := context.Background()
+	start := lastSeq(t, b)
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "bob", Message: "x"}, forum.Post{Author: "bob", Message: "y"})
+
+	status, err := b.Forums.SelectStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (forum.Status{Post: 2, Thread: 1, User: 2, Forum: 1}); status != want {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+
+	// soft-deleted posts are counted until they are purged
+	err = b.Tx.InTx(ctx, func(tx forum.Tx) error { return forum.DeletePost(ctx, tx, posts[0].Id, "bob") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err = b.Forums.SelectStatus(ctx); err != nil || status.Post != 2 {
+		t.Errorf("status after a delete = %+v, %v", status, err)
+	}
+	err = b.Tx.InTx(ctx, func(tx forum.Tx) error { return forum.PurgePost(ctx, tx, posts[0].Id) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err = b.Forums.SelectStatus(ctx); err != nil || status.Post != 1 {
+		t.Errorf("status after a purge = %+v, %v", status, err)
+	}
+
+	if err := b.Forums.Clean(ctx); err != nil {
+		t.Fatal(err)
+	}
+	status, err = b.Forums.SelectStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (forum.Status{}) {
+		t.Errorf("status after clean = %+v", status)
+	}
+
+	// the outbox is kept, and the clean is the last change in it
+	changes, err := b.Outbox.SelectChanges(ctx, start, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) < 2 || changes[0].Kind != forum.ChangeUserInserted || changes[0].Key != "alice" ||
+		changes[len(changes)-1].Kind != forum.ChangeAllCleared {
+		t.Errorf("changes after clean = %+v", changes)
+	}
+
+	// ids restart after a clean
+	if user := mustUser(t, b, "carol"); user.Id != 1 {
+		t.Errorf("first user after clean has id %d, want 1", user.Id)
+	}
+}
+
+func mustCounters(t *testing.T, b Backend, slug string, threads, posts int) {
+	t.Helper()
+	ctx := context.Background()
+	f, err := b.Forums.SelectForumBySlug(ctx, slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := b.Forums.SelectFullForumBySlug(ctx, slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Threads != threads || f.Posts != posts || full.Threads != threads || full.Posts != posts {
+		t.Errorf("counters threads=%d/%d posts=%d/%d, want %d and %d", f.Threads, full.Threads, f.Posts, full.Posts, threads, posts)
+	}
+}
+
+func inTx(b Backend, fn func(ctx context.Context, tx forum.Tx) error) error {
+	ctx := context.Background()
+	return b.Tx.InTx(ctx, func(tx forum.Tx) error { return fn(ctx, tx) })
+}
+
+func testDeletePost(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	p := func(parent int) forum.Post { return forum.Post{Author: "alice", Message: "m", Parent: parent} }
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(0), p(0))
+	replies := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:01Z", p(roots[0].Id))
+
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, roots[0].Id, "alice") })
+	if err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 2)
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, roots[0].Id, "alice") })
+	if err != forum.ErrNotFound {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+	mustCounters(t, b, "golang", 1, 2)
+
+	if _, err := b.Posts.SelectPostById(ctx, roots[0].Id); err != forum.ErrNotFound {
+		t.Errorf("SelectPostById of a deleted post: got %v, want ErrNotFound", err)
+	}
+	if got, err := b.Posts.SelectPostsByIds(ctx, []int{roots[0].Id, roots[1].Id}); err != nil || len(got) != 1 || got[0].Id != roots[1].Id {
+		t.Errorf("SelectPostsByIds with a deleted post = %+v, %v", got, err)
+	}
+	if n, err := b.Posts.UpdatePostMessage(ctx, "edited", roots[0].Id, "alice"); err != nil || n != 0 {
+		t.Errorf("UpdatePostMessage of a deleted post: %d, %v", n, err)
+	}
+
+	flat, err := b.Threads.SelectPosts(ctx, thread.Id, "flat", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := postIds(flat), []int{roots[1].Id, replies[0].Id}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flat = %v, want %v", got, want)
+	}
+
+	for _, sort := range []string{"tree", "parent_tree"} {
+		posts, err := b.Threads.SelectPosts(ctx, thread.Id, sort, forum.Page{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := postIds(posts), []int{roots[0].Id, replies[0].Id, roots[1].Id}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s = %v, want %v", sort, got, want)
+		}
+		tomb := posts[0]
+		if !tomb.Deleted || tomb.Author != "" || tomb.Message != "" || tomb.Parent != 0 || tomb.Thread != thread.Id {
+			t.Errorf("%s tombstone = %+v", sort, tomb)
+		}
+		if posts[1].Deleted || posts[1].Author != "alice" {
+			t.Errorf("%s reply to a deleted post = %+v", sort, posts[1])
+		}
+	}
+}
+
+func testDeleteThread(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "doomed", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	kept := mustThread(t, b, f, "kept", "alice", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "a"}, forum.Post{Author: "alice", Message: "b"})
+	mustPosts(t, b, kept, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "c"})
+
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, posts[0].Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeleteThread(ctx, tx, thread.Id, "alice") })
+	if err != nil {
+		t.Fatalf("DeleteThread: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 1)
+
+	if _, err := b.Threads.SelectThreadById(ctx, thread.Id); err != forum.ErrNotFound {
+		t.Errorf("SelectThreadById: got %v, want ErrNotFound", err)
+	}
+	if _, err := b.Threads.FindThreadBySlug(ctx, "doomed"); err != forum.ErrNotFound {
+		t.Errorf("FindThreadBySlug: got %v, want ErrNotFound", err)
+	}
+	threads, err := b.Threads.SelectThreadByForum(ctx, "golang", forum.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 || threads[0].Id != kept.Id {
+		t.Errorf("threads by forum = %+v, want only %q", threads, kept.Slug)
+	}
+	if got, err := b.Threads.SelectPosts(ctx, thread.Id, "tree", forum.Page{Limit: 100}); err != nil || len(got) != 0 {
+		t.Errorf("posts of a deleted thread = %v, %v", postIds(got), err)
+	}
+	if _, err := b.Posts.SelectPostById(ctx, posts[1].Id); err != forum.ErrNotFound {
+		t.Errorf("SelectPostById in a deleted thread: got %v, want ErrNotFound", err)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeleteThread(ctx, tx, thread.Id, "alice") })
+	if err != forum.ErrNotFound {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func forumUsers(t *testing.T, b Backend, f forum.Forum) []string {
+	t.Helper()
+	users, err := b.Users.SelectUsersByForum(context.Background(), f.Id, forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.NickName)
+	}
+	return names
+}
+
+func testMoveThread(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	golang := mustForum(t, b, "golang", "alice")
+	rust := mustForum(t, b, "rust", "alice")
+	lost := mustThread(t, b, golang, "lost", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	kept := mustThread(t, b, golang, "kept", "alice", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	posts := mustPosts(t, b, lost, golang.Id, "2020-01-01T00:00:00Z",
+		forum.Post{Author: "bob", Message: "in the wrong place"}, forum.Post{Author: "bob", Message: "gone"})
+	mustPosts(t, b, kept, golang.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "stays"})
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, posts[1].Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.MoveThread(ctx, tx, lost.Id, "RUST") })
+	if err != nil {
+		t.Fatalf("MoveThread: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 1)
+	mustCounters(t, b, "rust", 1, 1)
+	if got, err := b.Threads.SelectThreadById(ctx, lost.Id); err != nil || got.Forum != "rust" {
+		t.Errorf("moved thread = %+v, %v", got, err)
+	}
+	if got, err := b.Posts.SelectPostById(ctx, posts[0].Id); err != nil || got.Forum != "rust" {
+		t.Errorf("moved post = %+v, %v", got, err)
+	}
+	if got := forumUsers(t, b, golang); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("golang users = %v", got)
+	}
+	if got := forumUsers(t, b, rust); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("rust users = %v", got)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.MoveThread(ctx, tx, lost.Id, "haskell") })
+	if err != forum.ErrNotFound {
+		t.Errorf("move to a missing forum: got %v, want ErrNotFound", err)
+	}
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.MoveThread(ctx, tx, 999, "golang") })
+	if err != forum.ErrNotFound {
+		t.Errorf("move a missing thread: got %v, want ErrNotFound", err)
+	}
+}
+
+func testMergeThreads(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	golang := mustForum(t, b, "golang", "alice")
+	rust := mustForum(t, b, "rust", "alice")
+	into := mustThread(t, b, golang, "into", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	dup := mustThread(t, b, rust, "dup", "alice", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	target := mustPosts(t, b, into, golang.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "original"})
+	roots := mustPosts(t, b, dup, rust.Id, "2020-01-01T00:00:01Z", forum.Post{Author: "bob", Message: "duplicate"})
+	replies := mustPosts(t, b, dup, rust.Id, "2020-01-01T00:00:02Z", forum.Post{Author: "bob", Message: "reply", Parent: roots[0].Id})
+
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		return forum.MergeThreads(ctx, tx, dup.Id, into.Id, replies[0].Id, "alice")
+	})
+	if err != forum.ErrParentConflict {
+		t.Errorf("merge under a post of another thread: got %v, want ErrParentConflict", err)
+	}
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		return forum.MergeThreads(ctx, tx, dup.Id, dup.Id, 0, "alice")
+	})
+	if err != forum.ErrSameThread {
+		t.Errorf("merge into itself: got %v, want ErrSameThread", err)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		return forum.MergeThreads(ctx, tx, dup.Id, into.Id, target[0].Id, "alice")
+	})
+	if err != nil {
+		t.Fatalf("MergeThreads: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 3)
+	mustCounters(t, b, "rust", 0, 0)
+	if _, err := b.Threads.SelectThreadById(ctx, dup.Id); err != forum.ErrNotFound {
+		t.Errorf("merged thread: got %v, want ErrNotFound", err)
+	}
+
+	got, err := b.Threads.SelectPosts(ctx, into.Id, "tree", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{target[0].Id, roots[0].Id, replies[0].Id}
+	if !reflect.DeepEqual(postIds(got), want) {
+		t.Fatalf("tree after merge = %v, want %v", postIds(got), want)
+	}
+	wantPath := []int64{int64(target[0].Id), int64(roots[0].Id), int64(replies[0].Id)}
+	if !reflect.DeepEqual(got[2].Path, wantPath) || got[1].Parent != target[0].Id || got[2].Forum != "golang" {
+		t.Errorf("merged reply = %+v, want path %v", got[2], wantPath)
+	}
+	if got := forumUsers(t, b, golang); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("golang users = %v", got)
+	}
+	if got := forumUsers(t, b, rust); len(got) != 0 {
+		t.Errorf("rust users = %v", got)
+	}
+}
+
+func testSplitThread(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	golang := mustForum(t, b, "golang", "alice")
+	rust := mustForum(t, b, "rust", "alice")
+	long := mustThread(t, b, golang, "long", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	roots := mustPosts(t, b, long, golang.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "on topic"})
+	drift := mustPosts(t, b, long, golang.Id, "2020-01-01T00:00:01Z", forum.Post{Author: "bob", Message: "off topic", Parent: roots[0].Id})
+	deep := mustPosts(t, b, long, golang.Id, "2020-01-01T00:00:02Z",
+		forum.Post{Author: "bob", Message: "more", Parent: drift[0].Id},
+		forum.Post{Author: "bob", Message: "gone", Parent: drift[0].Id})
+	stay := mustPosts(t, b, long, golang.Id, "2020-01-01T00:00:03Z", forum.Post{Author: "alice", Message: "back", Parent: roots[0].Id})
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, deep[1].Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var split int
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) (err error) {
+		split, err = forum.SplitThread(ctx, tx, drift[0].Id, forum.Thread{
+			Author: "bob", Created: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Forum: rust.Slug, ForumId: rust.Id, Slug: "drift", Title: "Drift",
+		})
+		return
+	})
+	if err != nil {
+		t.Fatalf("SplitThread: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 2)
+	mustCounters(t, b, "rust", 1, 2)
+
+	got, err := b.Threads.SelectPosts(ctx, split, "tree", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{drift[0].Id, deep[0].Id, deep[1].Id}; !reflect.DeepEqual(postIds(got), want) {
+		t.Fatalf("split thread = %v, want %v", postIds(got), want)
+	}
+	if got[0].Parent != 0 || !reflect.DeepEqual(got[0].Path, []int64{int64(drift[0].Id)}) || got[0].Forum != "rust" {
+		t.Errorf("new root = %+v", got[0])
+	}
+	if want := []int64{int64(drift[0].Id), int64(deep[0].Id)}; got[1].Parent != drift[0].Id || !reflect.DeepEqual(got[1].Path, want) {
+		t.Errorf("moved reply = %+v, want path %v", got[1], want)
+	}
+	if !got[2].Deleted {
+		t.Errorf("deleted reply lost its tombstone: %+v", got[2])
+	}
+	left, err := b.Threads.SelectPosts(ctx, long.Id, "tree", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{roots[0].Id, stay[0].Id}; !reflect.DeepEqual(postIds(left), want) {
+		t.Errorf("thread after split = %v, want %v", postIds(left), want)
+	}
+	if got := forumUsers(t, b, rust); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Errorf("rust users = %v", got)
+	}
+	if got := forumUsers(t, b, golang); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("golang users = %v", got)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		_, err := forum.SplitThread(ctx, tx, 999, forum.Thread{Author: "bob", Forum: rust.Slug, ForumId: rust.Id, Title: "Nothing"})
+		return err
+	})
+	if err != forum.ErrNotFound {
+		t.Errorf("split a missing post: got %v, want ErrNotFound", err)
+	}
+	mustCounters(t, b, "rust", 1, 2)
+}
+
+func testSubtree(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	reply := func(parent int) forum.Post {
+		return mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "m", Parent: parent})[0]
+	}
+	root := reply(0)
+	a := reply(root.Id)
+	aa := reply(a.Id)
+	aaa := reply(aa.Id)
+	b1 := reply(root.Id)
+	other := reply(0)
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, a.Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		id, depth, limit int
+		want             []int
+	}{
+		{root.Id, 0, 100, []int{root.Id, a.Id, aa.Id, aaa.Id, b1.Id}},
+		{root.Id, 1, 100, []int{root.Id, a.Id, b1.Id}},
+		{root.Id, 0, 3, []int{root.Id, a.Id, aa.Id}},
+		{aa.Id, 0, 100, []int{aa.Id, aaa.Id}},
+		{other.Id, 2, 100, []int{other.Id}},
+	} {
+		got, err := b.Posts.SelectSubtree(ctx, tt.id, tt.depth, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(postIds(got), tt.want) {
+			t.Errorf("subtree of %d depth=%d limit=%d = %v, want %v", tt.id, tt.depth, tt.limit, postIds(got), tt.want)
+		}
+	}
+	got, _ := b.Posts.SelectSubtree(ctx, root.Id, 1, 100)
+	if len(got) > 1 && (!got[1].Deleted || got[1].Message != "") {
+		t.Errorf("deleted reply in a subtree = %+v", got[1])
+	}
+
+	chain, err := b.Posts.SelectAncestors(ctx, aaa.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{root.Id, a.Id, aa.Id, aaa.Id}; !reflect.DeepEqual(postIds(chain), want) {
+		t.Errorf("ancestors = %v, want %v", postIds(chain), want)
+	}
+	if !chain[1].Deleted {
+		t.Errorf("deleted ancestor = %+v", chain[1])
+	}
+	if _, err := b.Posts.SelectSubtree(ctx, a.Id, 0, 100); err != forum.ErrNotFound {
+		t.Errorf("subtree of a deleted post: got %v, want ErrNotFound", err)
+	}
+	if _, err := b.Posts.SelectAncestors(ctx, 999); err != forum.ErrNotFound {
+		t.Errorf("ancestors of a missing post: got %v, want ErrNotFound", err)
+	}
+}
+
+func testPurge(t *testing.T, b Backend) {
+	ctx := context.Background()
+	alice := mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	other := mustThread(t, b, f, "other", "alice", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	p := func(parent int) forum.Post { return forum.Post{Author: "alice", Message: "m", Parent: parent} }
+	roots := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", p(0), p(0))
+	replies := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:01Z", p(roots[0].Id), p(roots[0].Id))
+	mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:02Z", p(replies[0].Id))
+	mustPosts(t, b, other, f.Id, "2020-01-01T00:00:00Z", p(0), p(0))
+	if err := inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		return forum.CastVote(ctx, tx.Threads, forum.Vote{UserId: alice.Id, ThreadId: other.Id, Voice: 1})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mustCounters(t, b, "golang", 2, 7)
+
+	// a deleted reply is already off the counters
+	err := inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, replies[1].Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.PurgePost(ctx, tx, roots[0].Id) })
+	if err != nil {
+		t.Fatalf("PurgePost: %v", err)
+	}
+	mustCounters(t, b, "golang", 2, 3)
+	posts, err := b.Threads.SelectPosts(ctx, thread.Id, "tree", forum.Page{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := postIds(posts), []int{roots[1].Id}; !reflect.DeepEqual(got, want) {
+		t.Errorf("posts after purge = %v, want %v", got, want)
+	}
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.PurgePost(ctx, tx, roots[0].Id) })
+	if err != forum.ErrNotFound {
+		t.Errorf("purging twice: got %v, want ErrNotFound", err)
+	}
+
+	// purging a soft-deleted thread leaves the counters alone
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.DeleteThread(ctx, tx, thread.Id, "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCounters(t, b, "golang", 1, 2)
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.PurgeThread(ctx, tx, thread.Id) })
+	if err != nil {
+		t.Fatalf("PurgeThread of a deleted thread: %v", err)
+	}
+	mustCounters(t, b, "golang", 1, 2)
+
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error { return forum.PurgeThread(ctx, tx, other.Id) })
+	if err != nil {
+		t.Fatalf("PurgeThread: %v", err)
+	}
+	mustCounters(t, b, "golang", 0, 0)
+	if _, err := b.Threads.SelectVote(ctx, forum.Vote{UserId: alice.Id, ThreadId: other.Id}); err != forum.ErrNotFound {
+		t.Errorf("vote on a purged thread: got %v, want ErrNotFound", err)
+	}
+
+	status, err := b.Forums.SelectStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (forum.Status{User: 1, Forum: 1}); status != want {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+}
+
+func testSearch(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	golang := mustForum(t, b, "golang", "alice")
+	rust := mustForum(t, b, "rust", "bob")
+	gophers := mustThread(t, b, golang, "gophers", "alice", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	crabs := mustThread(t, b, rust, "crabs", "bob", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+	gophers.Title, gophers.Message = "Gopher meetup", "bring your gopher"
+	if err := b.Threads.UpdateThread(ctx, gophers); err != nil {
+		t.Fatal(err)
+	}
+	posts := mustPosts(t, b, gophers, golang.Id, "2020-01-02T00:00:00Z",
+		forum.Post{Author: "alice", Message: "the gopher is blue"},
+		forum.Post{Author: "bob", Message: "a gopher and a ferris"},
+		forum.Post{Author: "bob", Message: "nothing to see"},
+	)
+	crabPosts := mustPosts(t, b, crabs, rust.Id, "2020-02-02T00:00:00Z",
+		forum.Post{Author: "bob", Message: "ferris likes the gopher"},
+		forum.Post{Author: "alice", Message: "ferris only"},
+	)
+
+	key := func(kind string, id int) string { return kind + ":" + strconv.Itoa(id) }
+	search := func(q forum.SearchQuery) []string {
+		t.Helper()
+		if q.Limit == 0 {
+			q.Limit = 100
+		}
+		results, err := b.Search.Search(ctx, q)
+		if err != nil {
+			t.Fatalf("search %+v: %v", q, err)
+		}
+		keys := []string{}
+		for _, r := range results {
+			keys = append(keys, key(r.Kind, r.Id))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	sorted := func(keys ...string) []string {
+		sort.Strings(keys)
+		return keys
+	}
+
+	for _, tt := range []struct {
+		query forum.SearchQuery
+		want  []string
+	}{
+		{forum.SearchQuery{Text: "gopher"}, sorted(key("thread", gophers.Id), key("post", posts[0].Id), key("post", posts[1].Id), key("post", crabPosts[0].Id))},
+		{forum.SearchQuery{Text: "gopher ferris"}, sorted(key("post", posts[1].Id), key("post", crabPosts[0].Id))},
+		{forum.SearchQuery{Text: "gopher", Forum: "RUST"}, sorted(key("post", crabPosts[0].Id))},
+		{forum.SearchQuery{Text: "gopher", Author: "alice"}, sorted(key("thread", gophers.Id), key("post", posts[0].Id))},
+		{forum.SearchQuery{Text: "ferris", Since: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)}, sorted(key("post", crabPosts[0].Id), key("post", crabPosts[1].Id))},
+		{forum.SearchQuery{Text: "penguin"}, []string{}},
+	} {
+		if got := search(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %+v = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	results, err := b.Search.Search(ctx, forum.SearchQuery{Text: "gopher", Author: "alice", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !strings.Contains(r.Snippet, "<b>gopher</b>") && !strings.Contains(r.Snippet, "<b>Gopher</b>") {
+			t.Errorf("snippet of %s %d = %q", r.Kind, r.Id, r.Snippet)
+		}
+		if r.Kind == "thread" && (r.Title != "Gopher meetup" || r.Thread != gophers.Id) {
+			t.Errorf("thread result = %+v", r)
+		}
+		if r.Kind == "post" && (r.Thread != gophers.Id || r.Forum != "golang" || r.Author != "alice") {
+			t.Errorf("post result = %+v", r)
+		}
+	}
+
+	// paging one result at a time visits every result once, in order
+	var paged []string
+	var after *forum.SearchCursor
+	for i := 0; i < 10; i++ {
+		page, err := b.Search.Search(ctx, forum.SearchQuery{Text: "gopher", Limit: 1, After: after})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if after != nil && !after.Precedes(page[0]) {
+			t.Errorf("page %d starts at %+v, not after %+v", i, page[0], after)
+		}
+		paged = append(paged, key(page[0].Kind, page[0].Id))
+		c := page[0].Cursor()
+		after = &c
+	}
+	if got, want := sorted(paged...), search(forum.SearchQuery{Text: "gopher"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("paged results = %v, want %v", got, want)
+	}
+
+	// edits are searchable and deleted posts are not
+	err = b.Tx.InTx(ctx, func(tx forum.Tx) error {
+		if _, err := tx.Posts.UpdatePostMessage(ctx, "a gopher after all", posts[2].Id, "bob"); err != nil {
+			return err
+		}
+		return forum.DeletePost(ctx, tx, posts[0].Id, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sorted(key("post", posts[1].Id), key("post", posts[2].Id))
+	if got := search(forum.SearchQuery{Text: "gopher", Forum: "golang", Author: "bob"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("search after edit and delete = %v, want %v", got, want)
+	}
+}
+
+func testSearchEscapesSnippets(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	thread.Title = `<img src=x onerror="alert(1)"> gopher`
+	if err := b.Threads.UpdateThread(ctx, thread); err != nil {
+		t.Fatal(err)
+	}
+	mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "<script>alert('x')</script> gopher & co"})
+
+	results, err := b.Search.Search(ctx, forum.SearchQuery{Text: "gopher", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, r := range results {
+		if strings.Contains(strings.Replace(strings.Replace(r.Snippet, "<b>", "", -1), "</b>", "", -1), "<") {
+			t.Errorf("snippet of %s %d has markup other than <b>: %q", r.Kind, r.Id, r.Snippet)
+		}
+		if !strings.Contains(r.Snippet, "<b>gopher</b>") {
+			t.Errorf("snippet of %s %d = %q", r.Kind, r.Id, r.Snippet)
+		}
+		if r.Kind == "post" && !strings.Contains(r.Snippet, "&lt;script&gt;") {
+			t.Errorf("post snippet = %q, want the markup escaped", r.Snippet)
+		}
+	}
+}
+
+func testWebhooks(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustForum(t, b, "golang", "alice")
+	mustForum(t, b, "rust", "alice")
+
+	if _, err := b.Webhooks.InsertWebhook(ctx, forum.Webhook{Forum: "nope", URL: "http://x", Secret: "s", Events: []string{forum.EventPostCreated}}); err != forum.ErrNotFound {
+		t.Errorf("webhook of an unknown forum: %v", err)
+	}
+	insert := func(f string, events ...string) forum.Webhook {
+		t.Helper()
+		hook, err := b.Webhooks.InsertWebhook(ctx, forum.Webhook{Forum: f, URL: "http://example.com/" + f, Secret: "secret", Events: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hook
+	}
+	posts := insert("GoLang", forum.EventPostCreated, forum.EventUserCreated)
+	rust := insert("rust", forum.EventPostCreated)
+	votes := insert("golang", forum.EventVoteCast)
+	if posts.Forum != "golang" || posts.Id == 0 || posts.Created.IsZero() {
+		t.Errorf("inserted %+v, want the forum's slug, an id and a creation time", posts)
+	}
+
+	hooks, err := b.Webhooks.SelectWebhooks(ctx, "GOLANG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || hooks[0].Id != posts.Id || hooks[1].Id != votes.Id {
+		t.Fatalf("webhooks of golang = %+v", hooks)
+	}
+	if hooks[0].Secret != "secret" || !reflect.DeepEqual(hooks[0].Events, posts.Events) {
+		t.Errorf("selected %+v, want the secret and events inserted", hooks[0])
+	}
+
+	enqueue := []struct {
+		forum, event string
+		queued       int
+	}{
+		{"golang", forum.EventPostCreated, 1},
+		{"", forum.EventUserCreated, 1},
+		{"rust", forum.EventPostCreated, 1},
+		{"golang", forum.EventThreadCreated, 0},
+	}
+	for _, e := range enqueue {
+		n, err := b.Webhooks.EnqueueDelivery(ctx, e.forum, e.event, []byte(`{"event":"`+e.event+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != e.queued {
+			t.Errorf("%s in %q queued %d deliveries, want %d", e.event, e.forum, n, e.queued)
+		}
+	}
+
+	// a rolled back change queues nothing
+	errAbort := forumtestError("abort")
+	err = inTx(b, func(ctx context.Context, tx forum.Tx) error {
+		if _, err := tx.Webhooks.EnqueueDelivery(ctx, "golang", forum.EventVoteCast, []byte(`{}`)); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(time.Minute)
+	claimed, err := b.Webhooks.ClaimDeliveries(ctx, now, time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].Webhook != posts.Id || claimed[1].Webhook != posts.Id || claimed[0].Event != forum.EventPostCreated {
+		t.Fatalf("claimed %+v, want the two deliveries to %d", claimed, posts.Id)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(claimed[0].Payload, &payload); err != nil || payload["event"] != forum.EventPostCreated {
+		t.Errorf("payload %s: %v", claimed[0].Payload, err)
+	}
+	if claimed[0].Status != forum.DeliveryPending || claimed[0].Attempts != 0 {
+		t.Errorf("claimed %+v, want it pending with no attempts", claimed[0])
+	}
+	rest, err := b.Webhooks.ClaimDeliveries(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].Webhook != rust.Id {
+		t.Fatalf("claimed %+v, want only the delivery to %d that was not leased", rest, rust.Id)
+	}
+	if again, _ := b.Webhooks.ClaimDeliveries(ctx, now.Add(2*time.Minute), time.Minute, 10); len(again) != 3 {
+		t.Errorf("claimed %d deliveries after the lease, want 3", len(again))
+	}
+
+	delivered := claimed[0]
+	at := now.Truncate(time.Second)
+	delivered.Status, delivered.Attempts, delivered.ResponseCode, delivered.Delivered = forum.DeliveryDelivered, 1, 200, &at
+	if err := b.Webhooks.UpdateDelivery(ctx, delivered); err != nil {
+		t.Fatal(err)
+	}
+	failed := claimed[1]
+	failed.Status, failed.Attempts, failed.ResponseCode, failed.Error = forum.DeliveryFailed, 5, 500, "boom"
+	if err := b.Webhooks.UpdateDelivery(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+	missing := failed
+	missing.Id = 1 << 40
+	if err := b.Webhooks.UpdateDelivery(ctx, missing); err != forum.ErrNotFound {
+		t.Errorf("update of a missing delivery: %v", err)
+	}
+	log, err := b.Webhooks.SelectDeliveries(ctx, posts.Id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[0].Id != failed.Id || log[1].Id != delivered.Id {
+		t.Fatalf("deliveries of %d = %+v, want newest first", posts.Id, log)
+	}
+	if log[0].Status != forum.DeliveryFailed || log[0].Error != "boom" || log[0].Attempts != 5 || log[0].Delivered != nil {
+		t.Errorf("failed delivery = %+v", log[0])
+	}
+	if log[1].Status != forum.DeliveryDelivered || log[1].ResponseCode != 200 || log[1].Delivered == nil || !log[1].Delivered.Equal(at) {
+		t.Errorf("delivered delivery = %+v", log[1])
+	}
+	if log, _ := b.Webhooks.SelectDeliveries(ctx, posts.Id, 1); len(log) != 1 {
+		t.Errorf("got %d deliveries with limit 1", len(log))
+	}
+	if again, _ := b.Webhooks.ClaimDeliveries(ctx, now.Add(time.Hour), time.Minute, 10); len(again) != 1 || again[0].Webhook != rust.Id {
+		t.Errorf("claimed %+v, want only the delivery still pending", again)
+	}
+
+	if err := b.Webhooks.DeleteWebhook(ctx, posts.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Webhooks.DeleteWebhook(ctx, posts.Id); err != forum.ErrNotFound {
+		t.Errorf("second delete: %v", err)
+	}
+	if _, err := b.Webhooks.SelectWebhook(ctx, posts.Id); err != forum.ErrNotFound {
+		t.Errorf("deleted webhook found: %v", err)
+	}
+	if log, _ := b.Webhooks.SelectDeliveries(ctx, posts.Id, 10); len(log) != 0 {
+		t.Errorf("deliveries of a deleted webhook: %+v", log)
+	}
+	if hook, err := b.Webhooks.SelectWebhook(ctx, rust.Id); err != nil || hook.URL != rust.URL {
+		t.Errorf("SelectWebhook = %+v, %v", hook, err)
+	}
+}
+
+func testOutbox(t *testing.T, b Backend) {
+	ctx := context.Background()
+	start := lastSeq(t, b)
+	alice := mustUser(t, b, "alice")
+	alice.About = "gopher"
+	if err := b.Users.UpdateUser(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	f := mustForum(t, b, "golang", "alice")
+	thread := mustThread(t, b, f, "t", "alice", time.Now())
+	thread.Title = "Renamed"
+	if err := b.Threads.UpdateThread(ctx, thread); err != nil {
+		t.Fatal(err)
+	}
+	posts := mustPosts(t, b, thread, f.Id, "2020-01-01T00:00:00Z", forum.Post{Author: "alice", Message: "first"}, forum.Post{Author: "alice", Message: "second"})
+	if _, err := b.Posts.UpdatePostMessage(ctx, "edited", posts[0].Id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, voice := range []int{1, -1} {
+		err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+			return forum.CastVote(ctx, tx.Threads, forum.Vote{UserId: alice.Id, ThreadId: thread.Id, Voice: voice})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	errAbort := forumtestError("abort")
+	err := b.Tx.InTx(ctx, func(tx forum.Tx) error {
+		if err := tx.Users.InsertUser(ctx, forum.User{NickName: "bob", Email: "bob@example.com"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("InTx returned %v, want the callback's error", err)
+	}
+
+	changes, err := b.Outbox.SelectChanges(ctx, start, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threadKey, voteKey := strconv.Itoa(thread.Id), forum.VoteKey(forum.Vote{UserId: alice.Id, ThreadId: thread.Id})
+	want := []string{
+		forum.ChangeUserInserted + " alice",
+		forum.ChangeUserUpdated + " alice",
+		forum.ChangeForumInserted + " golang",
+		forum.ChangeThreadInserted + " " + threadKey,
+		forum.ChangeThreadUpdated + " " + threadKey,
+		forum.ChangePostInserted + " " + strconv.Itoa(posts[0].Id),
+		forum.ChangePostInserted + " " + strconv.Itoa(posts[1].Id),
+		forum.ChangePostUpdated + " " + strconv.Itoa(posts[0].Id),
+		forum.ChangeVoteInserted + " " + voteKey,
+		forum.ChangeVoteUpdated + " " + voteKey,
+	}
+	got := []string{}
+	for i, c := range changes {
+		got = append(got, c.Kind+" "+c.Key)
+		if i > 0 && c.Seq <= changes[i-1].Seq {
+			t.Errorf("seq %d follows %d", c.Seq, changes[i-1].Seq)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+
+	var user forum.User
+	if err := json.Unmarshal(changes[1].Data, &user); err != nil || user.About != "gopher" {
+		t.Errorf("user.updated data %s: %v", changes[1].Data, err)
+	}
+	var post forum.Post
+	if err := json.Unmarshal(changes[7].Data, &post); err != nil || post.Message != "edited" || !post.IsEdited || post.Thread != thread.Id {
+		t.Errorf("post.updated data %s: %v", changes[7].Data, err)
+	}
+	var vote forum.VoteChange
+	if err := json.Unmarshal(changes[9].Data, &vote); err != nil || vote != (forum.VoteChange{Thread: thread.Id, User: alice.Id, Voice: -1}) {
+		t.Errorf("vote.updated data %s: %v", changes[9].Data, err)
+	}
+
+	page, err := b.Outbox.SelectChanges(ctx, changes[2].Seq, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Seq != changes[3].Seq || page[1].Seq != changes[4].Seq {
+		t.Errorf("changes after %d = %+v", changes[2].Seq, page)
+	}
+	if page, err := b.Outbox.SelectChanges(ctx, changes[9].Seq, 10); err != nil || len(page) != 0 {
+		t.Errorf("changes after the last = %+v, %v", page, err)
+	}
+}
+
+func testOutboxMovesAndRemovals(t *testing.T, b Backend) {
+	ctx := context.Background()
+	mustUser(t, b, "alice")
+	mustUser(t, b, "bob")
+	golang := mustForum(t, b, "golang", "alice")
+	mustForum(t, b, "rust", "alice")
+	t1 := mustThread(t, b, golang, "t1", "alice", time.Now())
+	t2 := mustThread(t, b, golang, "t2", "alice", time.Now())
+	t3 := mustThread(t, b, golang, "t3", "alice", time.Now())
+	p := func(parent int) forum.Post { return forum.Post{Author: "bob", Message: "m", Parent: parent} }
+	p1 := mustPosts(t, b, t1, golang.Id, "2020-01-01T00:00:00Z", p(0))[0]
+	p2 := mustPosts(t, b, t1, golang.Id, "2020-01-01T00:00:01Z", p(p1.Id))[0]
+	p3 := mustPosts(t, b, t2, golang.Id, "2020-01-01T00:00:02Z", p(0))[0]
+	p4 := mustPosts(t, b, t2, golang.Id, "2020-01-01T00:00:03Z", p(p3.Id))[0]
+	start := lastSeq(t, b)
+
+	if err := b.Users.UpdatePassword(ctx, "ALICE", "$2a$10$hash"); err != nil {
+		t.Fatal(err)
+	}
+	locked := t1
+	locked.Locked = true
+	if err := b.Threads.UpdateThreadFlags(ctx, locked); err != nil {
+		t.Fatal(err)
+	}
+	steps := []func(ctx context.Context, tx forum.Tx) error{
+		func(ctx context.Context, tx forum.Tx) error { return forum.DeletePost(ctx, tx, p1.Id, "bob") },
+		func(ctx context.Context, tx forum.Tx) error { return forum.MoveThread(ctx, tx, t1.Id, "rust") },
+		func(ctx context.Context, tx forum.Tx) error {
+			_, err := tx.Posts.MoveSubtree(ctx, p2.Id, t2.Id)
+			return err
+		},
+		func(ctx context.Context, tx forum.Tx) error {
+			return forum.MergeThreads(ctx, tx, t2.Id, t3.Id, 0, "bob")
+		},
+		func(ctx context.Context, tx forum.Tx) error { return forum.PurgePost(ctx, tx, p3.Id) },
+		func(ctx context.Context, tx forum.Tx) error { return forum.DeleteThread(ctx, tx, t3.Id, "alice") },
+		func(ctx context.Context, tx forum.Tx) error { return forum.PurgeThread(ctx, tx, t1.Id) },
+	}
+	for i, step := range steps {
+		if err := inTx(b, step); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	changes, err := b.Outbox.SelectChanges(ctx, start, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strconv.Itoa
+	want := []string{
+		forum.ChangeUserPassword + " alice",
+		forum.ChangeThreadFlagged + " " + key(t1.Id),
+		forum.ChangePostDeleted + " " + key(p1.Id),
+		forum.ChangePostMoved + " " + key(p1.Id),
+		forum.ChangePostMoved + " " + key(p2.Id),
+		forum.ChangeThreadMoved + " " + key(t1.Id),
+		forum.ChangePostMoved + " " + key(p2.Id),
+		forum.ChangePostMoved + " " + key(p2.Id),
+		forum.ChangePostMoved + " " + key(p3.Id),
+		forum.ChangePostMoved + " " + key(p4.Id),
+		forum.ChangeThreadDeleted + " " + key(t2.Id),
+		forum.ChangePostPurged + " " + key(p3.Id),
+		forum.ChangePostPurged + " " + key(p4.Id),
+		forum.ChangeThreadDeleted + " " + key(t3.Id),
+		forum.ChangeThreadPurged + " " + key(t1.Id),
+	}
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, c.Kind+" "+c.Key)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+
+	if string(changes[0].Data) != "{}" {
+		t.Errorf("user.password data %s, want no hash", changes[0].Data)
+	}
+	var flags forum.ThreadFlagsChange
+	if err := json.Unmarshal(changes[1].Data, &flags); err != nil || flags != (forum.ThreadFlagsChange{Locked: true}) {
+		t.Errorf("thread.flagged data %s: %v", changes[1].Data, err)
+	}
+	var removal forum.RemovalChange
+	if err := json.Unmarshal(changes[2].Data, &removal); err != nil || removal != (forum.RemovalChange{Forum: "golang", By: "bob"}) {
+		t.Errorf("post.deleted data %s: %v", changes[2].Data, err)
+	}
+	var postMove forum.PostMoveChange
+	if err := json.Unmarshal(changes[4].Data, &postMove); err != nil || postMove != (forum.PostMoveChange{From: "golang", Forum: "rust", Thread: t1.Id, Parent: p1.Id}) {
+		t.Errorf("post.moved data %s: %v", changes[4].Data, err)
+	}
+	var threadMove forum.ThreadMoveChange
+	if err := json.Unmarshal(changes[5].Data, &threadMove); err != nil || threadMove != (forum.ThreadMoveChange{From: "golang", Forum: "rust"}) {
+		t.Errorf("thread.moved data %s: %v", changes[5].Data, err)
+	}
+	postMove = forum.PostMoveChange{}
+	if err := json.Unmarshal(changes[6].Data, &postMove); err != nil || postMove != (forum.PostMoveChange{From: "rust", Forum: "golang", Thread: t2.Id}) {
+		t.Errorf("post.moved data %s: %v", changes[6].Data, err)
+	}
+	postMove = forum.PostMoveChange{}
+	if err := json.Unmarshal(changes[9].Data, &postMove); err != nil || postMove != (forum.PostMoveChange{From: "golang", Forum: "golang", Thread: t3.Id, Parent: p3.Id}) {
+		t.Errorf("post.moved data %s: %v", changes[9].Data, err)
+	}
+	removal = forum.RemovalChange{}
+	if err := json.Unmarshal(changes[14].Data, &removal); err != nil || removal != (forum.RemovalChange{Forum: "rust"}) {
+		t.Errorf("thread.purged data %s: %v", changes[14].Data, err)
+	}
+}
+
+// lastSeq returns the seq of the newest change in the outbox, or 0 if there
+// is none.
+func lastSeq(t *testing.T, b Backend) (seq int64) {
+	t.Helper()
+	for {
+		changes, err := b.Outbox.SelectChanges(context.Background(), seq, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) == 0 {
+			return seq
+		}
+		seq = changes[len(changes)-1].Seq
+	}
+}
+
+type forumtestError string
+
+func (e forumtestError) Error() string { return string(e) }
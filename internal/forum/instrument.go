@@ -0,0 +1,123 @@
+package forum
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// QueryEvent describes one statement run by a service.
+type QueryEvent struct {
+	// Context is the context the statement ran with.
+	Context context.Context
+	// Method is the service method that ran the statement, for example
+	// "ThreadService.SelectPosts".
+	Method   string
+	SQL      string
+	Args     []interface{}
+	Duration time.Duration
+	Err      error
+	// Waited is set when the pool had no idle connection as the statement
+	// started, so it had to wait for one to be released or opened.
+	Waited bool
+}
+
+type QueryObserver func(e QueryEvent)
+
+// Instrument wraps db so that observe is called after every statement. For
+// QueryEx the duration covers sending the statement, not reading the rows,
+// and for QueryRowEx the error is only known to the caller's Scan.
+func Instrument(db Querier, observe QueryObserver) Querier {
+	if observe == nil {
+		return db
+	}
+	return &instrumented{db: db, observe: observe}
+}
+
+type instrumented struct {
+	db      Querier
+	observe QueryObserver
+}
+
+func (q *instrumented) ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (tag pgx.CommandTag, err error) {
+	started, waited := time.Now(), poolExhausted(q.db)
+	tag, err = q.db.ExecEx(ctx, sql, options, arguments...)
+	q.observe(QueryEvent{Context: ctx, Method: caller(), SQL: sql, Args: arguments, Duration: time.Since(started), Err: err, Waited: waited})
+	return
+}
+
+func (q *instrumented) QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (rows *pgx.Rows, err error) {
+	started, waited := time.Now(), poolExhausted(q.db)
+	rows, err = q.db.QueryEx(ctx, sql, options, args...)
+	q.observe(QueryEvent{Context: ctx, Method: caller(), SQL: sql, Args: args, Duration: time.Since(started), Err: err, Waited: waited})
+	return
+}
+
+func (q *instrumented) QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row {
+	started, waited := time.Now(), poolExhausted(q.db)
+	row := q.db.QueryRowEx(ctx, sql, options, args...)
+	q.observe(QueryEvent{Context: ctx, Method: caller(), SQL: sql, Args: args, Duration: time.Since(started), Waited: waited})
+	return row
+}
+
+func poolExhausted(db Querier) bool {
+	pool, ok := db.(*pgx.ConnPool)
+	if !ok {
+		return false
+	}
+	stat := pool.Stat()
+	return stat.AvailableConnections == 0 && stat.CurrentConnections >= stat.MaxConnections
+}
+
+// caller returns "Type.Method" for the function calling the instrumented
+// querier.
+func caller() string {
+	pc := make([]uintptr, 1)
+	if runtime.Callers(3, pc) == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+	name := frame.Function
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	return strings.NewReplacer("(*", "", ")", "").Replace(name)
+}
+
+// copier is implemented by the queriers that can load rows with COPY,
+// *pgx.ConnPool and *pgx.Tx among them.
+type copier interface {
+	CopyFrom(tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int, error)
+}
+
+// canCopy reports whether copyFrom works on db.
+func canCopy(db Querier) bool {
+	if q, ok := db.(*instrumented); ok {
+		db = q.db
+	}
+	_, ok := db.(copier)
+	return ok
+}
+
+// copyFrom loads rows into table with COPY. When db is instrumented the
+// COPY is observed like any other statement.
+func copyFrom(ctx context.Context, db Querier, table pgx.Identifier, columns []string, rows [][]interface{}) (n int, err error) {
+	q, instrumented := db.(*instrumented)
+	if instrumented {
+		db = q.db
+	}
+	c, ok := db.(copier)
+	if !ok {
+		return 0, errors.New("forum: COPY is not supported by the querier")
+	}
+	started, waited := time.Now(), poolExhausted(db)
+	n, err = c.CopyFrom(table, columns, pgx.CopyFromRows(rows))
+	if instrumented {
+		sql := "COPY " + table.Sanitize() + " (" + strings.Join(columns, ", ") + ") FROM STDIN"
+		q.observe(QueryEvent{Context: ctx, Method: caller(), SQL: sql, Duration: time.Since(started), Err: err, Waited: waited})
+	}
+	return n, err
+}
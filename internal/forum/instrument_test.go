@@ -0,0 +1,46 @@
+package forum
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+type failingQuerier struct{}
+
+var errFailing = errors.New("failing querier")
+
+func (failingQuerier) ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error) {
+	return "", errFailing
+}
+
+func (failingQuerier) QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error) {
+	return nil, errFailing
+}
+
+func (failingQuerier) QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row {
+	return nil
+}
+
+func TestInstrumentNamesServiceMethod(t *testing.T) {
+	var events []QueryEvent
+	db := Instrument(failingQuerier{}, func(e QueryEvent) { events = append(events, e) })
+
+	ctx := context.Background()
+	NewUserService(db).InsertUser(ctx, User{})
+	NewForumService(db).UpdatePostCount(ctx, "slug", 1)
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	for i, want := range []string{"UserService.InsertUser", "ForumService.UpdatePostCount"} {
+		if events[i].Method != want {
+			t.Errorf("event %d method = %q, want %q", i, events[i].Method, want)
+		}
+		if events[i].Err != errFailing {
+			t.Errorf("event %d err = %v, want %v", i, events[i].Err, errFailing)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"tech-db/internal/forum"
+)
+
+type ForumService struct {
+	s *Store
+}
+
+func (fs *ForumService) SelectFullForumBySlug(ctx context.Context, slug string) (f forum.Forum, err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	found, ok := fs.s.d.forumBySlug(slug)
+	if !ok {
+		return f, forum.ErrNotFound
+	}
+	f = forum.Forum{Slug: found.Slug, Title: found.Title, User: found.User}
+	for _, t := range fs.s.d.threads {
+		if _, ok := fs.s.d.liveThread(t.Id); ok && fold(t.Forum) == fold(slug) {
+			f.Threads++
+		}
+	}
+	for _, p := range fs.s.d.posts {
+		if fs.s.d.livePost(p.Id) && fold(p.Forum) == fold(slug) {
+			f.Posts++
+		}
+	}
+	return f, nil
+}
+
+func (fs *ForumService) SelectForumBySlug(ctx context.Context, slug string) (f forum.Forum, err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	found, ok := fs.s.d.forumBySlug(slug)
+	if !ok {
+		return f, forum.ErrNotFound
+	}
+	found.UserId = 0
+	return found, nil
+}
+
+func (fs *ForumService) InsertForum(ctx context.Context, f forum.Forum) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	if _, ok := fs.s.d.forumBySlug(f.Slug); ok {
+		return errors.New(`duplicate key value violates unique constraint "forum_slug_uindex"`)
+	}
+	fs.s.d.forumSeq++
+	fs.s.d.forums[fs.s.d.forumSeq] = forum.Forum{Id: fs.s.d.forumSeq, Slug: f.Slug, Title: f.Title, User: f.User}
+	return fs.s.d.appendChange(forum.ChangeForumInserted, f.Slug, f)
+}
+
+func (fs *ForumService) Clean(ctx context.Context) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	changes, seq := fs.s.d.changes, fs.s.d.changeSeq
+	fs.s.d = newData()
+	fs.s.d.changes, fs.s.d.changeSeq = changes, seq
+	return fs.s.d.appendChange(forum.ChangeAllCleared, "", struct{}{})
+}
+
+func (fs *ForumService) SelectStatus(ctx context.Context) (status forum.Status, err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	return forum.Status{
+		Post:   len(fs.s.d.posts),
+		Thread: len(fs.s.d.threads),
+		User:   len(fs.s.d.users),
+		Forum:  len(fs.s.d.forums),
+	}, nil
+}
+
+func (fs *ForumService) UpdateThreadCount(ctx context.Context, forumId int) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	if f, ok := fs.s.d.forums[forumId]; ok {
+		f.Threads++
+		fs.s.d.forums[forumId] = f
+	}
+	return nil
+}
+
+func (fs *ForumService) UpdatePostCount(ctx context.Context, slug string, count int) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	if f, ok := fs.s.d.forumBySlug(slug); ok {
+		f.Posts += count
+		fs.s.d.forums[f.Id] = f
+	}
+	return nil
+}
+
+func (fs *ForumService) UpdateCounts(ctx context.Context, slug string, threads, posts int) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	if f, ok := fs.s.d.forumBySlug(slug); ok {
+		f.Threads += threads
+		f.Posts += posts
+		fs.s.d.forums[f.Id] = f
+	}
+	return nil
+}
+
+func (fs *ForumService) InsertForumUser(ctx context.Context, forumId int, userId int) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	fs.s.d.forumUsers[[2]int{forumId, userId}] = true
+	return nil
+}
+
+func (fs *ForumService) SyncForumUsers(ctx context.Context, slug string, nickNames []string) (err error) {
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+
+	f, ok := fs.s.d.forumBySlug(slug)
+	if !ok {
+		return nil
+	}
+	for _, nickName := range nickNames {
+		u, ok := fs.s.d.userByNickName(nickName)
+		if !ok {
+			continue
+		}
+		if fs.s.d.wroteIn(f.Slug, u.NickName) {
+			fs.s.d.forumUsers[[2]int{f.Id, u.Id}] = true
+		} else {
+			delete(fs.s.d.forumUsers, [2]int{f.Id, u.Id})
+		}
+	}
+	return nil
+}
+
+// wroteIn reports whether the user wrote a thread or post in the forum.
+func (d *data) wroteIn(slug, nickName string) bool {
+	for _, t := range d.threads {
+		if fold(t.Forum) == fold(slug) && fold(t.Author) == fold(nickName) {
+			return true
+		}
+	}
+	for _, p := range d.posts {
+		if fold(p.Forum) == fold(slug) && fold(p.Author) == fold(nickName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *data) forumBySlug(slug string) (forum.Forum, bool) {
+	for _, f := range d.forums {
+		if fold(f.Slug) == fold(slug) {
+			return f, true
+		}
+	}
+	return forum.Forum{}, false
+}
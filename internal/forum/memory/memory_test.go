@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"tech-db/internal/forum/forumtest"
+)
+
+func TestMemory(t *testing.T) {
+	forumtest.Run(t, func(t *testing.T) forumtest.Backend {
+		s := NewStore()
+		return forumtest.Backend{Users: s.Users, Forums: s.Forums, Threads: s.Threads, Posts: s.Posts, Roles: s.Roles, Search: s.Search, Webhooks: s.Webhooks, Outbox: s.Outbox, Tx: s}
+	})
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+type OutboxService struct {
+	s *Store
+}
+
+// appendChange records a change like forum's appendChange. The caller holds
+// the store's lock, and a rolled back InTx drops the change with the rest.
+func (d *data) appendChange(kind, key string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	d.changeSeq++
+	d.changes = append(d.changes, forum.Change{Seq: d.changeSeq, Kind: kind, Key: key, Data: payload, Created: time.Now()})
+	return nil
+}
+
+func (ob *OutboxService) SelectChanges(ctx context.Context, after int64, limit int) (changes []forum.Change, err error) {
+	ob.s.mu.Lock()
+	defer ob.s.mu.Unlock()
+
+	for _, c := range ob.s.d.changes {
+		if len(changes) == limit {
+			break
+		}
+		if c.Seq > after {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
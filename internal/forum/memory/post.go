@@ -0,0 +1,360 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"tech-db/internal/forum"
+	"time"
+)
+
+type PostService struct {
+	s *Store
+}
+
+func (ps *PostService) SelectPostById(ctx context.Context, id int) (post forum.Post, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	p, ok := ps.s.d.posts[id]
+	if !ok || !ps.s.d.livePost(id) {
+		return post, forum.ErrNotFound
+	}
+	p.Path = nil
+	return p, nil
+}
+
+func (ps *PostService) SelectPostsByIds(ctx context.Context, ids []int) (posts []forum.Post, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	seen := map[int]bool{}
+	for _, id := range ids {
+		p, ok := ps.s.d.posts[id]
+		if !ok || seen[id] || !ps.s.d.livePost(id) {
+			continue
+		}
+		seen[id] = true
+		p.Path = nil
+		posts = append(posts, p)
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Id < posts[j].Id })
+	return posts, nil
+}
+
+func (ps *PostService) FindPostById(ctx context.Context, id int, thread int) (err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	if p, ok := ps.s.d.posts[id]; !ok || p.Thread != thread {
+		return forum.ErrNotFound
+	}
+	return nil
+}
+
+func (ps *PostService) InsertPost(ctx context.Context, post forum.Post) (lastId int, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	ps.s.d.postSeq++
+	post.Id = ps.s.d.postSeq
+	post.IsEdited = false
+	post.Path = []int64{0}
+	ps.s.d.posts[post.Id] = post
+	post.Path = nil
+	return post.Id, ps.s.d.appendChange(forum.ChangePostInserted, strconv.Itoa(post.Id), post)
+}
+
+func (ps *PostService) UpdatePostMessage(ctx context.Context, newMessage string, id int, editor string) (countUpdateString int64, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	p, ok := ps.s.d.posts[id]
+	if _, deleted := ps.s.d.deletedPosts[id]; !ok || deleted {
+		return 0, nil
+	}
+	revisions := ps.s.d.revisions[id]
+	if len(revisions) == 0 {
+		created, err := time.Parse(time.RFC3339Nano, p.Created)
+		if err != nil {
+			return 0, err
+		}
+		revisions = append(revisions, forum.PostRevision{Post: id, Message: p.Message, Editor: p.Author, Created: created.Truncate(time.Microsecond)})
+	}
+	revisions = append(revisions, forum.PostRevision{
+		Post:     id,
+		Revision: len(revisions),
+		Message:  newMessage,
+		Editor:   editor,
+		Created:  time.Now().Truncate(time.Microsecond),
+	})
+	ps.s.d.revisions[id] = revisions
+
+	p.Message = newMessage
+	p.IsEdited = true
+	ps.s.d.posts[id] = p
+	p.Path = nil
+	return 1, ps.s.d.appendChange(forum.ChangePostUpdated, strconv.Itoa(id), p)
+}
+
+func (ps *PostService) SelectPostRevisions(ctx context.Context, id int) (revisions []forum.PostRevision, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	return append(revisions, ps.s.d.revisions[id]...), nil
+}
+
+func (ps *PostService) CreatePosts(ctx context.Context, thread forum.Thread, forumId int, created string, posts []forum.Post) (post []forum.Post, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	authors := make([]forum.User, len(posts))
+	for i, p := range posts {
+		author, ok := ps.s.d.userByNickName(p.Author)
+		if !ok {
+			return nil, forum.ErrAuthorNotFound
+		}
+		authors[i] = author
+		if p.Parent != 0 {
+			parent, ok := ps.s.d.posts[p.Parent]
+			if !ok || parent.Thread != thread.Id {
+				return nil, forum.ErrParentConflict
+			}
+		}
+	}
+
+	for i, p := range posts {
+		ps.s.d.forumUsers[[2]int{forumId, authors[i].Id}] = true
+
+		ps.s.d.postSeq++
+		newPost := forum.Post{
+			Id:      ps.s.d.postSeq,
+			Author:  p.Author,
+			Created: created,
+			Forum:   thread.Forum,
+			Message: p.Message,
+			Parent:  p.Parent,
+			Thread:  thread.Id,
+		}
+		if p.Parent == 0 {
+			newPost.Path = []int64{int64(newPost.Id)}
+		} else {
+			parentPath := ps.s.d.posts[p.Parent].Path
+			newPost.Path = append(append(make([]int64, 0, len(parentPath)+1), parentPath...), int64(newPost.Id))
+		}
+		ps.s.d.posts[newPost.Id] = newPost
+
+		newPost.Path = nil
+		post = append(post, newPost)
+		if err := ps.s.d.appendChange(forum.ChangePostInserted, strconv.Itoa(newPost.Id), newPost); err != nil {
+			return nil, err
+		}
+	}
+	return post, nil
+}
+
+func (ps *PostService) DeletePost(ctx context.Context, id int, by string) (removal forum.Removal, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	if !ps.s.d.livePost(id) {
+		return removal, forum.ErrNotFound
+	}
+	ps.s.d.deletedPosts[id] = deletion{at: time.Now(), by: by}
+	removal = forum.Removal{Forum: ps.s.d.posts[id].Forum, Posts: 1}
+	return removal, ps.s.d.appendChange(forum.ChangePostDeleted, strconv.Itoa(id), forum.RemovalChange{Forum: removal.Forum, By: by})
+}
+
+func (ps *PostService) PurgePost(ctx context.Context, id int) (removal forum.Removal, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	target, ok := ps.s.d.posts[id]
+	if !ok {
+		return removal, forum.ErrNotFound
+	}
+	_, threadDeleted := ps.s.d.deletedThreads[target.Thread]
+	removal.Forum = target.Forum
+	var purged []int
+	for _, p := range ps.s.d.posts {
+		if p.Thread != target.Thread || !containsId(p.Path, id) {
+			continue
+		}
+		if _, deleted := ps.s.d.deletedPosts[p.Id]; !deleted && !threadDeleted {
+			removal.Posts++
+		}
+		purged = append(purged, p.Id)
+		delete(ps.s.d.posts, p.Id)
+		delete(ps.s.d.deletedPosts, p.Id)
+		delete(ps.s.d.revisions, p.Id)
+	}
+	sort.Ints(purged)
+	for _, pid := range purged {
+		if err := ps.s.d.appendChange(forum.ChangePostPurged, strconv.Itoa(pid), forum.RemovalChange{Forum: removal.Forum}); err != nil {
+			return removal, err
+		}
+	}
+	return removal, nil
+}
+
+// livePost reports whether a post exists and neither it nor its thread is
+// deleted.
+func (d *data) livePost(id int) bool {
+	p, ok := d.posts[id]
+	if !ok {
+		return false
+	}
+	if _, deleted := d.deletedPosts[id]; deleted {
+		return false
+	}
+	_, ok = d.liveThread(p.Thread)
+	return ok
+}
+
+// containsId reports whether path, which ends with the post itself, passes
+// through id, that is whether the post is id or one of its replies.
+func containsId(path []int64, id int) bool {
+	for _, v := range path {
+		if v == int64(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps *PostService) MovePosts(ctx context.Context, from, into, parent int) (t forum.Transfer, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	var prefix []int64
+	if parent != 0 {
+		p, ok := ps.s.d.posts[parent]
+		if !ok || p.Thread != into {
+			return t, forum.ErrParentConflict
+		}
+		prefix = p.Path
+	}
+	src, ok := ps.s.d.liveThread(from)
+	if !ok {
+		return t, forum.ErrNotFound
+	}
+	dst, ok := ps.s.d.liveThread(into)
+	if !ok {
+		return t, forum.ErrNotFound
+	}
+	t = forum.Transfer{From: src.Forum, To: dst.Forum}
+	authors := map[string]bool{}
+	var moved []int
+	for _, p := range ps.s.d.posts {
+		if p.Thread != from {
+			continue
+		}
+		if _, deleted := ps.s.d.deletedPosts[p.Id]; !deleted {
+			t.Posts++
+		}
+		authors[p.Author] = true
+		p.Thread, p.Forum = dst.Id, dst.Forum
+		p.Path = append(append([]int64{}, prefix...), p.Path...)
+		if p.Parent == 0 {
+			p.Parent = parent
+		}
+		ps.s.d.posts[p.Id] = p
+		moved = append(moved, p.Id)
+	}
+	t.Authors = keys(authors)
+	return t, ps.s.d.appendMoves(t.From, moved)
+}
+
+func (ps *PostService) MoveSubtree(ctx context.Context, id int, into int) (t forum.Transfer, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	root, ok := ps.s.d.posts[id]
+	if !ok || !ps.s.d.livePost(id) {
+		return t, forum.ErrNotFound
+	}
+	dst, ok := ps.s.d.liveThread(into)
+	if !ok {
+		return t, forum.ErrNotFound
+	}
+	t = forum.Transfer{From: root.Forum, To: dst.Forum}
+	authors := map[string]bool{}
+	var moved []int
+	cut := len(root.Path) - 1
+	for _, p := range ps.s.d.posts {
+		if p.Thread != root.Thread || !containsId(p.Path, id) {
+			continue
+		}
+		if _, deleted := ps.s.d.deletedPosts[p.Id]; !deleted {
+			t.Posts++
+		}
+		authors[p.Author] = true
+		p.Thread, p.Forum = dst.Id, dst.Forum
+		p.Path = append([]int64{}, p.Path[cut:]...)
+		if p.Id == id {
+			p.Parent = 0
+		}
+		ps.s.d.posts[p.Id] = p
+		moved = append(moved, p.Id)
+	}
+	t.Authors = keys(authors)
+	return t, ps.s.d.appendMoves(t.From, moved)
+}
+
+// appendMoves records a post.moved change for each of the posts moved out
+// of forum from, in id order.
+func (d *data) appendMoves(from string, moved []int) error {
+	sort.Ints(moved)
+	for _, id := range moved {
+		p := d.posts[id]
+		change := forum.PostMoveChange{From: from, Forum: p.Forum, Thread: p.Thread, Parent: p.Parent}
+		if err := d.appendChange(forum.ChangePostMoved, strconv.Itoa(id), change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PostService) SelectSubtree(ctx context.Context, id int, depth, limit int) (posts []forum.Post, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	if !ps.s.d.livePost(id) {
+		return nil, forum.ErrNotFound
+	}
+	root := ps.s.d.posts[id]
+	for _, p := range ps.s.d.posts {
+		if p.Thread != root.Thread || !containsId(p.Path, id) {
+			continue
+		}
+		if depth != 0 && len(p.Path)-len(root.Path) > depth {
+			continue
+		}
+		posts = append(posts, ps.s.d.visible(p))
+	}
+	sortPosts(posts, func(a, b forum.Post) bool { return comparePaths(a.Path, b.Path) < 0 })
+	return truncate(posts, limit), nil
+}
+
+func (ps *PostService) SelectAncestors(ctx context.Context, id int) (posts []forum.Post, err error) {
+	ps.s.mu.Lock()
+	defer ps.s.mu.Unlock()
+
+	if !ps.s.d.livePost(id) {
+		return nil, forum.ErrNotFound
+	}
+	for _, ancestor := range ps.s.d.posts[id].Path {
+		if p, ok := ps.s.d.posts[int(ancestor)]; ok {
+			posts = append(posts, ps.s.d.visible(p))
+		}
+	}
+	return posts, nil
+}
+
+// visible returns p, or its tombstone if it was deleted.
+func (d *data) visible(p forum.Post) forum.Post {
+	if _, deleted := d.deletedPosts[p.Id]; deleted {
+		return forum.Tombstone(p)
+	}
+	return p
+}
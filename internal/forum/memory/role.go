@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"tech-db/internal/forum"
+)
+
+type RoleService struct {
+	s *Store
+}
+
+func (rs *RoleService) IsAdmin(ctx context.Context, nickName string) (bool, error) {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	u, ok := rs.s.d.userByNickName(nickName)
+	return ok && rs.s.d.admins[u.Id], nil
+}
+
+func (rs *RoleService) SetAdmin(ctx context.Context, nickName string, admin bool) error {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	u, ok := rs.s.d.userByNickName(nickName)
+	if !ok {
+		return forum.ErrNotFound
+	}
+	if admin {
+		rs.s.d.admins[u.Id] = true
+	} else {
+		delete(rs.s.d.admins, u.Id)
+	}
+	return nil
+}
+
+func (rs *RoleService) IsModerator(ctx context.Context, forumSlug string, nickName string) (bool, error) {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	key, ok := rs.s.d.moderatorKey(forumSlug, nickName)
+	return ok && rs.s.d.moderators[key], nil
+}
+
+func (rs *RoleService) SelectModerators(ctx context.Context, forumSlug string) (users []forum.User, err error) {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	f, ok := rs.s.d.forumBySlug(forumSlug)
+	if !ok {
+		return nil, nil
+	}
+	for key := range rs.s.d.moderators {
+		if key[0] != f.Id {
+			continue
+		}
+		if u, ok := rs.s.d.users[key[1]]; ok {
+			u.Id = 0
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].NickName < users[j].NickName })
+	return users, nil
+}
+
+func (rs *RoleService) InsertModerator(ctx context.Context, forumSlug string, nickName string) error {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	key, ok := rs.s.d.moderatorKey(forumSlug, nickName)
+	if !ok {
+		return forum.ErrNotFound
+	}
+	rs.s.d.moderators[key] = true
+	return nil
+}
+
+func (rs *RoleService) DeleteModerator(ctx context.Context, forumSlug string, nickName string) error {
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+
+	key, ok := rs.s.d.moderatorKey(forumSlug, nickName)
+	if !ok || !rs.s.d.moderators[key] {
+		return forum.ErrNotFound
+	}
+	delete(rs.s.d.moderators, key)
+	return nil
+}
+
+// moderatorKey resolves a forum slug and nickname to a moderators key.
+func (d *data) moderatorKey(forumSlug, nickName string) ([2]int, bool) {
+	f, ok := d.forumBySlug(forumSlug)
+	if !ok {
+		return [2]int{}, false
+	}
+	u, ok := d.userByNickName(nickName)
+	if !ok {
+		return [2]int{}, false
+	}
+	return [2]int{f.Id, u.Id}, true
+}
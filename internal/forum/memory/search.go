@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"tech-db/internal/forum"
+)
+
+// SearchService matches whole words case-insensitively. Unlike Postgres it
+// does no stemming and drops no stop words, and its ranks are its own.
+type SearchService struct {
+	s *Store
+}
+
+func (ss *SearchService) Search(ctx context.Context, query forum.SearchQuery) (results []forum.SearchResult, err error) {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+
+	terms := words(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	matches := func(r forum.SearchResult) bool {
+		if query.Forum != "" && fold(r.Forum) != fold(query.Forum) {
+			return false
+		}
+		if query.Author != "" && fold(r.Author) != fold(query.Author) {
+			return false
+		}
+		if !query.Since.IsZero() && r.Created.Before(query.Since) {
+			return false
+		}
+		return query.After == nil || query.After.Precedes(r)
+	}
+
+	var hits []forum.SearchResult
+	for _, p := range ss.s.d.posts {
+		if !ss.s.d.livePost(p.Id) {
+			continue
+		}
+		rank := rankText(terms, p.Message, 1)
+		if rank == 0 {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339Nano, p.Created)
+		if err != nil {
+			return nil, err
+		}
+		r := forum.SearchResult{Kind: "post", Id: p.Id, Thread: p.Thread, Forum: p.Forum, Author: p.Author, Created: created, Snippet: highlight(terms, p.Message), Rank: rank}
+		if matches(r) {
+			hits = append(hits, r)
+		}
+	}
+	for _, t := range ss.s.d.threads {
+		if _, deleted := ss.s.d.deletedThreads[t.Id]; deleted {
+			continue
+		}
+		text := t.Title + "\n" + t.Message
+		if rankText(terms, text, 1) == 0 {
+			continue
+		}
+		rank := rankText(terms, t.Title, 1) + rankText(terms, t.Message, 0.4)
+		r := forum.SearchResult{Kind: "thread", Id: t.Id, Thread: t.Id, Forum: t.Forum, Author: t.Author, Created: t.Created, Title: t.Title, Snippet: highlight(terms, text), Rank: rank}
+		if matches(r) {
+			hits = append(hits, r)
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Cursor().Precedes(hits[j]) })
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+// rankText is weight times the share of text's words that are terms, or 0
+// unless text has all of the terms.
+func rankText(terms []string, text string, weight float32) float32 {
+	found := map[string]int{}
+	all := words(text)
+	for _, w := range all {
+		found[w]++
+	}
+	hits := 0
+	for _, term := range terms {
+		if found[term] == 0 {
+			return 0
+		}
+		hits += found[term]
+	}
+	return weight * float32(hits) / float32(len(all))
+}
+
+// highlight escapes text for HTML and wraps the terms in it in <b></b>.
+func highlight(terms []string, text string) string {
+	isTerm := map[string]bool{}
+	for _, term := range terms {
+		isTerm[term] = true
+	}
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := text[start:end]
+		if isTerm[strings.ToLower(word)] {
+			word = "<b>" + word + "</b>"
+		}
+		b.WriteString(word)
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	flush(len(text))
+	return b.String()
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
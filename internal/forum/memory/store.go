@@ -0,0 +1,202 @@
+// Package memory is an in-memory implementation of the forum repositories.
+// It mirrors the behaviour of the Postgres services closely enough for
+// handler tests and local runs that have no database.
+package memory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+type data struct {
+	users      map[int]forum.User
+	forums     map[int]forum.Forum
+	threads    map[int]forum.Thread
+	posts      map[int]forum.Post
+	votes      []forum.Vote
+	forumUsers map[[2]int]bool
+
+	deletedThreads map[int]deletion
+	deletedPosts   map[int]deletion
+	revisions      map[int][]forum.PostRevision
+	passwords      map[int]string
+	admins         map[int]bool
+	moderators     map[[2]int]bool
+	webhooks       map[int]forum.Webhook
+	deliveries     map[int64]forum.WebhookDelivery
+	changes        []forum.Change
+
+	userSeq     int
+	forumSeq    int
+	threadSeq   int
+	postSeq     int
+	webhookSeq  int
+	deliverySeq int64
+	changeSeq   int64
+}
+
+// deletion records a soft delete, like the deleted_at and deleted_by
+// columns.
+type deletion struct {
+	at time.Time
+	by string
+}
+
+func newData() *data {
+	return &data{
+		users:          map[int]forum.User{},
+		forums:         map[int]forum.Forum{},
+		threads:        map[int]forum.Thread{},
+		posts:          map[int]forum.Post{},
+		forumUsers:     map[[2]int]bool{},
+		deletedThreads: map[int]deletion{},
+		deletedPosts:   map[int]deletion{},
+		revisions:      map[int][]forum.PostRevision{},
+		passwords:      map[int]string{},
+		admins:         map[int]bool{},
+		moderators:     map[[2]int]bool{},
+		webhooks:       map[int]forum.Webhook{},
+		deliveries:     map[int64]forum.WebhookDelivery{},
+	}
+}
+
+func (d *data) clone() *data {
+	c := *d
+	c.users = make(map[int]forum.User, len(d.users))
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	c.forums = make(map[int]forum.Forum, len(d.forums))
+	for k, v := range d.forums {
+		c.forums[k] = v
+	}
+	c.threads = make(map[int]forum.Thread, len(d.threads))
+	for k, v := range d.threads {
+		c.threads[k] = v
+	}
+	c.posts = make(map[int]forum.Post, len(d.posts))
+	for k, v := range d.posts {
+		v.Path = append([]int64(nil), v.Path...)
+		c.posts[k] = v
+	}
+	c.votes = append([]forum.Vote(nil), d.votes...)
+	c.forumUsers = make(map[[2]int]bool, len(d.forumUsers))
+	for k, v := range d.forumUsers {
+		c.forumUsers[k] = v
+	}
+	c.deletedThreads = make(map[int]deletion, len(d.deletedThreads))
+	for k, v := range d.deletedThreads {
+		c.deletedThreads[k] = v
+	}
+	c.deletedPosts = make(map[int]deletion, len(d.deletedPosts))
+	for k, v := range d.deletedPosts {
+		c.deletedPosts[k] = v
+	}
+	c.revisions = make(map[int][]forum.PostRevision, len(d.revisions))
+	for k, v := range d.revisions {
+		c.revisions[k] = append([]forum.PostRevision(nil), v...)
+	}
+	c.passwords = make(map[int]string, len(d.passwords))
+	for k, v := range d.passwords {
+		c.passwords[k] = v
+	}
+	c.admins = make(map[int]bool, len(d.admins))
+	for k, v := range d.admins {
+		c.admins[k] = v
+	}
+	c.moderators = make(map[[2]int]bool, len(d.moderators))
+	for k, v := range d.moderators {
+		c.moderators[k] = v
+	}
+	c.webhooks = make(map[int]forum.Webhook, len(d.webhooks))
+	for k, v := range d.webhooks {
+		c.webhooks[k] = v
+	}
+	c.deliveries = make(map[int64]forum.WebhookDelivery, len(d.deliveries))
+	for k, v := range d.deliveries {
+		c.deliveries[k] = v
+	}
+	c.changes = append([]forum.Change(nil), d.changes...)
+	return &c
+}
+
+// Store owns the data shared by the in-memory repositories.
+type Store struct {
+	Users    *UserService
+	Forums   *ForumService
+	Threads  *ThreadService
+	Posts    *PostService
+	Roles    *RoleService
+	Search   *SearchService
+	Webhooks *WebhookService
+	Outbox   *OutboxService
+
+	mu   sync.Mutex
+	txMu sync.Mutex
+	d    *data
+}
+
+func NewStore() *Store {
+	s := &Store{d: newData()}
+	s.Users = &UserService{s: s}
+	s.Forums = &ForumService{s: s}
+	s.Threads = &ThreadService{s: s}
+	s.Posts = &PostService{s: s}
+	s.Roles = &RoleService{s: s}
+	s.Search = &SearchService{s: s}
+	s.Webhooks = &WebhookService{s: s}
+	s.Outbox = &OutboxService{s: s}
+	return s
+}
+
+// InTx runs fn against the store's repositories and restores the previous
+// state if it fails. Transactions are serialized with each other, but
+// writes made outside InTx while one is running are lost on rollback.
+func (s *Store) InTx(ctx context.Context, fn func(tx forum.Tx) error) (err error) {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	snapshot := s.d.clone()
+	s.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.restore(snapshot)
+			panic(p)
+		}
+	}()
+
+	err = fn(forum.Tx{Users: s.Users, Forums: s.Forums, Threads: s.Threads, Posts: s.Posts, Webhooks: s.Webhooks})
+	if err != nil {
+		s.restore(snapshot)
+	}
+	return err
+}
+
+func (s *Store) restore(snapshot *data) {
+	s.mu.Lock()
+	s.d = snapshot
+	s.mu.Unlock()
+}
+
+// fold compares the way citext columns do.
+func fold(s string) string {
+	return strings.ToLower(s)
+}
+
+var (
+	_ forum.UserRepository    = (*UserService)(nil)
+	_ forum.ForumRepository   = (*ForumService)(nil)
+	_ forum.ThreadRepository  = (*ThreadService)(nil)
+	_ forum.PostRepository    = (*PostService)(nil)
+	_ forum.RoleRepository    = (*RoleService)(nil)
+	_ forum.SearchRepository  = (*SearchService)(nil)
+	_ forum.WebhookRepository = (*WebhookService)(nil)
+	_ forum.OutboxRepository  = (*OutboxService)(nil)
+	_ forum.Transactor        = (*Store)(nil)
+)
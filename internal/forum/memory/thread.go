@@ -0,0 +1,505 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+type ThreadService struct {
+	s *Store
+}
+
+func (ts *ThreadService) SelectThreadBySlug(ctx context.Context, threadSlug string) (thread forum.Thread, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	t, ok := ts.s.d.threadBySlug(threadSlug)
+	if !ok {
+		return thread, forum.ErrNotFound
+	}
+	t.ForumId = 0
+	return t, nil
+}
+
+func (ts *ThreadService) SelectThreadById(ctx context.Context, id int) (thread forum.Thread, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	t, ok := ts.s.d.liveThread(id)
+	if !ok {
+		return thread, forum.ErrNotFound
+	}
+	t.ForumId = 0
+	return t, nil
+}
+
+func (ts *ThreadService) InsertThread(ctx context.Context, thread forum.Thread) (id int, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	ts.s.d.threadSeq++
+	thread.Id = ts.s.d.threadSeq
+	thread.ForumId = 0
+	thread.Votes = 0
+	thread.Locked, thread.Pinned, thread.Archived = false, false, false
+	// timestamptz keeps microseconds
+	thread.Created = thread.Created.Truncate(time.Microsecond)
+	ts.s.d.threads[thread.Id] = thread
+	return thread.Id, ts.s.d.appendChange(forum.ChangeThreadInserted, strconv.Itoa(thread.Id), thread)
+}
+
+func (ts *ThreadService) SelectThreadByForum(ctx context.Context, forumSlug string, page forum.Page) (threads []forum.Thread, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	if err := page.Check(); err != nil {
+		return nil, err
+	}
+	since := page.After == nil && page.Since != ""
+	var sinceTime time.Time
+	if since {
+		if sinceTime, err = page.SinceTime(); err != nil {
+			return nil, err
+		}
+	}
+	desc := page.Desc
+	less := func(a, b forum.Thread) bool {
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created) != desc
+		}
+		return ordered(a.Id-b.Id, desc)
+	}
+	var pivot forum.Thread
+	if page.After != nil {
+		created, err := page.After.Time()
+		if err != nil {
+			return nil, forum.ErrBadCursor
+		}
+		pivot = forum.Thread{Pinned: page.After.Pinned, Created: created, Id: page.After.Id}
+	}
+
+	var matched []forum.Thread
+	for _, t := range ts.s.d.threads {
+		if fold(t.Forum) != fold(forumSlug) {
+			continue
+		}
+		if _, deleted := ts.s.d.deletedThreads[t.Id]; deleted {
+			continue
+		}
+		if since && !desc && !t.Pinned && t.Created.Before(sinceTime) {
+			continue
+		}
+		if since && desc && !t.Pinned && t.Created.After(sinceTime) {
+			continue
+		}
+		if page.After != nil && !onPage(page.After, less(pivot, t), less(t, pivot)) {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	sort.Slice(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+	from, to := window(len(matched), page.Limit, page.After)
+	return matched[from:to], nil
+}
+
+func (ts *ThreadService) FindThreadBySlug(ctx context.Context, slug string) (thread forum.Thread, err error) {
+	thread, err = ts.SelectThreadBySlug(ctx, slug)
+	thread.Votes = 0
+	return
+}
+
+func (ts *ThreadService) FindThreadById(ctx context.Context, id int) (thread forum.Thread, err error) {
+	thread, err = ts.SelectThreadById(ctx, id)
+	thread.Votes = 0
+	return
+}
+
+func (ts *ThreadService) InsertVote(ctx context.Context, vote forum.Vote) (err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	ts.s.d.votes = append(ts.s.d.votes, forum.Vote{UserId: vote.UserId, Voice: vote.Voice, ThreadId: vote.ThreadId})
+	return ts.s.d.appendChange(forum.ChangeVoteInserted, forum.VoteKey(vote), forum.VoteChange{Thread: vote.ThreadId, User: vote.UserId, Voice: vote.Voice})
+}
+
+func (ts *ThreadService) SelectVote(ctx context.Context, vote forum.Vote) (findVote forum.Vote, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	for _, v := range ts.s.d.votes {
+		if v.UserId == vote.UserId && v.ThreadId == vote.ThreadId {
+			return v, nil
+		}
+	}
+	return findVote, forum.ErrNotFound
+}
+
+func (ts *ThreadService) UpdateVote(ctx context.Context, vote forum.Vote) (countUpdatedRows int64, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	for i, v := range ts.s.d.votes {
+		if v.UserId == vote.UserId && v.ThreadId == vote.ThreadId {
+			ts.s.d.votes[i].Voice = vote.Voice
+			countUpdatedRows++
+		}
+	}
+	if countUpdatedRows == 0 {
+		return 0, nil
+	}
+	return countUpdatedRows, ts.s.d.appendChange(forum.ChangeVoteUpdated, forum.VoteKey(vote), forum.VoteChange{Thread: vote.ThreadId, User: vote.UserId, Voice: vote.Voice})
+}
+
+func (ts *ThreadService) UpdateThread(ctx context.Context, thread forum.Thread) (err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	if t, ok := ts.s.d.threads[thread.Id]; ok {
+		t.Message = thread.Message
+		t.Title = thread.Title
+		ts.s.d.threads[thread.Id] = t
+		return ts.s.d.appendChange(forum.ChangeThreadUpdated, strconv.Itoa(t.Id), t)
+	}
+	return nil
+}
+
+func (ts *ThreadService) UpdateThreadFlags(ctx context.Context, thread forum.Thread) error {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	t, ok := ts.s.d.liveThread(thread.Id)
+	if !ok {
+		return forum.ErrNotFound
+	}
+	t.Locked, t.Pinned, t.Archived = thread.Locked, thread.Pinned, thread.Archived
+	ts.s.d.threads[thread.Id] = t
+	flags := forum.ThreadFlagsChange{Locked: t.Locked, Pinned: t.Pinned, Archived: t.Archived}
+	return ts.s.d.appendChange(forum.ChangeThreadFlagged, strconv.Itoa(t.Id), flags)
+}
+
+func (ts *ThreadService) SelectPosts(ctx context.Context, threadID int, sort string, page forum.Page) (posts []forum.Post, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	if err := page.Check(); err != nil {
+		return nil, err
+	}
+	since := page.After == nil && page.Since != ""
+	var sinceId int
+	if since {
+		if sinceId, err = page.SinceID(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := ts.s.d.liveThread(threadID); !ok {
+		return nil, nil
+	}
+	sincePost, sinceFound := ts.s.d.posts[sinceId]
+	if since && sort != "flat" && !sinceFound {
+		return nil, nil
+	}
+	descending := page.Desc
+
+	var pivot forum.Post
+	if c := page.After; c != nil {
+		pivot = forum.Post{Created: c.Key, Id: c.Id, Path: c.Path}
+		if sort == "parent_tree" {
+			pivot.Path = []int64{int64(c.Id)}
+		}
+	}
+	// keep reports whether p belongs on the page, which starts after since
+	// or on either side of the cursor.
+	keep := func(p forum.Post, less func(a, b forum.Post) bool) bool {
+		if page.After == nil {
+			return true
+		}
+		return onPage(page.After, less(pivot, p), less(p, pivot))
+	}
+
+	var threadPosts []forum.Post
+	for _, p := range ts.s.d.posts {
+		if p.Thread != threadID {
+			continue
+		}
+		if _, deleted := ts.s.d.deletedPosts[p.Id]; deleted {
+			if sort == "flat" {
+				continue
+			}
+			p = forum.Tombstone(p)
+		}
+		threadPosts = append(threadPosts, p)
+	}
+
+	switch sort {
+	case "flat":
+		less := func(a, b forum.Post) bool {
+			if a.Created != b.Created {
+				return ordered(strings.Compare(a.Created, b.Created), descending)
+			}
+			return ordered(a.Id-b.Id, descending)
+		}
+		sortPosts(threadPosts, less)
+		for _, p := range threadPosts {
+			if since && ((!descending && p.Id <= sinceId) || (descending && p.Id >= sinceId)) {
+				continue
+			}
+			if keep(p, less) {
+				posts = append(posts, p)
+			}
+		}
+		from, to := window(len(posts), page.Limit, page.After)
+		return posts[from:to], nil
+
+	case "tree":
+		less := func(a, b forum.Post) bool {
+			return ordered(comparePaths(a.Path, b.Path), descending)
+		}
+		sortPosts(threadPosts, less)
+		for _, p := range threadPosts {
+			if since && !less(sincePost, p) {
+				continue
+			}
+			if keep(p, less) {
+				posts = append(posts, p)
+			}
+		}
+		from, to := window(len(posts), page.Limit, page.After)
+		return posts[from:to], nil
+
+	case "parent_tree":
+		less := func(a, b forum.Post) bool {
+			return ordered(int(a.Path[0]-b.Path[0]), descending)
+		}
+		var roots []forum.Post
+		for _, p := range threadPosts {
+			if p.Parent != 0 {
+				continue
+			}
+			if since && !less(forum.Post{Path: sincePost.Path[:1]}, p) {
+				continue
+			}
+			if keep(p, less) {
+				roots = append(roots, p)
+			}
+		}
+		sortPosts(roots, less)
+		from, to := window(len(roots), page.Limit, page.After)
+		roots = roots[from:to]
+
+		rootIds := map[int64]bool{}
+		for _, r := range roots {
+			rootIds[int64(r.Id)] = true
+		}
+		for _, p := range threadPosts {
+			for _, id := range p.Path {
+				if rootIds[id] {
+					posts = append(posts, p)
+					break
+				}
+			}
+		}
+		sortPosts(posts, func(a, b forum.Post) bool {
+			if a.Path[0] != b.Path[0] {
+				return less(a, b)
+			}
+			return comparePaths(a.Path, b.Path) < 0
+		})
+		return posts, nil
+	}
+	return nil, forum.ErrBadSort
+}
+
+func (ts *ThreadService) UpdateVoteCount(ctx context.Context, vote forum.Vote) (err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	if t, ok := ts.s.d.threads[vote.ThreadId]; ok {
+		t.Votes += vote.Voice
+		ts.s.d.threads[vote.ThreadId] = t
+	}
+	return nil
+}
+
+func (ts *ThreadService) DeleteThread(ctx context.Context, id int, by string) (removal forum.Removal, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	t, ok := ts.s.d.liveThread(id)
+	if !ok {
+		return removal, forum.ErrNotFound
+	}
+	ts.s.d.deletedThreads[id] = deletion{at: time.Now(), by: by}
+	removal = forum.Removal{Forum: t.Forum, Threads: 1}
+	for _, p := range ts.s.d.posts {
+		if _, deleted := ts.s.d.deletedPosts[p.Id]; p.Thread == id && !deleted {
+			removal.Posts++
+		}
+	}
+	return removal, ts.s.d.appendChange(forum.ChangeThreadDeleted, strconv.Itoa(id), forum.RemovalChange{Forum: t.Forum, By: by})
+}
+
+func (ts *ThreadService) PurgeThread(ctx context.Context, id int) (removal forum.Removal, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	t, ok := ts.s.d.threads[id]
+	if !ok {
+		return removal, forum.ErrNotFound
+	}
+	_, threadDeleted := ts.s.d.deletedThreads[id]
+	removal.Forum = t.Forum
+	if !threadDeleted {
+		removal.Threads = 1
+	}
+	for _, p := range ts.s.d.posts {
+		if p.Thread != id {
+			continue
+		}
+		if _, deleted := ts.s.d.deletedPosts[p.Id]; !deleted && !threadDeleted {
+			removal.Posts++
+		}
+		delete(ts.s.d.posts, p.Id)
+		delete(ts.s.d.deletedPosts, p.Id)
+		delete(ts.s.d.revisions, p.Id)
+	}
+	votes := ts.s.d.votes[:0]
+	for _, v := range ts.s.d.votes {
+		if v.ThreadId != id {
+			votes = append(votes, v)
+		}
+	}
+	ts.s.d.votes = votes
+	delete(ts.s.d.threads, id)
+	delete(ts.s.d.deletedThreads, id)
+	return removal, ts.s.d.appendChange(forum.ChangeThreadPurged, strconv.Itoa(id), forum.RemovalChange{Forum: t.Forum})
+}
+
+func (ts *ThreadService) MoveThread(ctx context.Context, id int, forumSlug string) (t forum.Transfer, err error) {
+	ts.s.mu.Lock()
+	defer ts.s.mu.Unlock()
+
+	thread, ok := ts.s.d.liveThread(id)
+	if !ok {
+		return t, forum.ErrNotFound
+	}
+	f, ok := ts.s.d.forumBySlug(forumSlug)
+	if !ok {
+		return t, forum.ErrNotFound
+	}
+	t = forum.Transfer{From: thread.Forum, To: f.Slug, Threads: 1}
+	authors := map[string]bool{thread.Author: true}
+	var moved []int
+	thread.Forum = f.Slug
+	ts.s.d.threads[id] = thread
+	for _, p := range ts.s.d.posts {
+		if p.Thread != id {
+			continue
+		}
+		if _, deleted := ts.s.d.deletedPosts[p.Id]; !deleted {
+			t.Posts++
+		}
+		authors[p.Author] = true
+		p.Forum = f.Slug
+		ts.s.d.posts[p.Id] = p
+		moved = append(moved, p.Id)
+	}
+	t.Authors = keys(authors)
+	if err := ts.s.d.appendMoves(t.From, moved); err != nil {
+		return t, err
+	}
+	return t, ts.s.d.appendChange(forum.ChangeThreadMoved, strconv.Itoa(id), forum.ThreadMoveChange{From: t.From, Forum: t.To})
+}
+
+// liveThread looks a thread up by id, skipping deleted ones.
+func (d *data) liveThread(id int) (forum.Thread, bool) {
+	t, ok := d.threads[id]
+	if _, deleted := d.deletedThreads[id]; !ok || deleted {
+		return forum.Thread{}, false
+	}
+	return t, true
+}
+
+func (d *data) threadBySlug(slug string) (forum.Thread, bool) {
+	var found forum.Thread
+	for _, t := range d.threads {
+		if _, deleted := d.deletedThreads[t.Id]; deleted {
+			continue
+		}
+		if fold(t.Slug) == fold(slug) && (found.Id == 0 || t.Id < found.Id) {
+			found = t
+		}
+	}
+	return found, found.Id != 0
+}
+
+func sortPosts(posts []forum.Post, less func(a, b forum.Post) bool) {
+	sort.Slice(posts, func(i, j int) bool { return less(posts[i], posts[j]) })
+}
+
+// ordered reports whether two items whose keys compare as cmp are in
+// order, ascending or descending.
+func ordered(cmp int, desc bool) bool {
+	if desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// onPage reports whether an item belongs on the page of cursor c, given
+// whether it sorts after and before the cursor's item.
+func onPage(c *forum.Cursor, after, before bool) bool {
+	if c.Back {
+		return before
+	}
+	return after
+}
+
+// window returns the bounds of a page of at most limit of n items in
+// order: the first ones, or the last ones when the page runs back from a
+// cursor.
+func window(n, limit int, c *forum.Cursor) (from, to int) {
+	if limit < 0 || limit > n {
+		limit = n
+	}
+	if c != nil && c.Back {
+		return n - limit, n
+	}
+	return 0, limit
+}
+
+func truncate(posts []forum.Post, limit int) []forum.Post {
+	if limit >= 0 && len(posts) > limit {
+		return posts[:limit]
+	}
+	return posts
+}
+
+// comparePaths orders materialized paths the way Postgres orders arrays.
+func comparePaths(a, b []int64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+func keys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,176 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tech-db/internal/forum"
+)
+
+type UserService struct {
+	s *Store
+}
+
+func (us *UserService) SelectUserByNickNameOrEmail(ctx context.Context, nickName, email string) (users []forum.User, err error) {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	for _, u := range us.s.d.sortedUsers() {
+		if fold(u.NickName) == fold(nickName) || fold(u.Email) == fold(email) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (us *UserService) SelectUserByNickName(ctx context.Context, nickName string) (user forum.User, err error) {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	u, ok := us.s.d.userByNickName(nickName)
+	if !ok {
+		return user, forum.ErrNotFound
+	}
+	u.Id = 0
+	return u, nil
+}
+
+func (us *UserService) SelectUsersByForum(ctx context.Context, forumId int, page forum.Page) (users []forum.User, err error) {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	if err := page.Check(); err != nil {
+		return nil, err
+	}
+	less := func(a, b forum.User) bool {
+		return ordered(strings.Compare(fold(a.NickName), fold(b.NickName)), page.Desc)
+	}
+	var pivot *forum.User
+	if page.After != nil {
+		pivot = &forum.User{NickName: page.After.Key}
+	} else if page.Since != "" {
+		pivot = &forum.User{NickName: page.Since}
+	}
+
+	var members []forum.User
+	for key := range us.s.d.forumUsers {
+		if key[0] != forumId {
+			continue
+		}
+		u, ok := us.s.d.users[key[1]]
+		if !ok {
+			continue
+		}
+		if page.After != nil && !onPage(page.After, less(*pivot, u), less(u, *pivot)) {
+			continue
+		}
+		if page.After == nil && pivot != nil && !less(*pivot, u) {
+			continue
+		}
+		u.Id = 0
+		members = append(members, u)
+	}
+	sort.Slice(members, func(i, j int) bool { return less(members[i], members[j]) })
+	from, to := window(len(members), page.Limit, page.After)
+	return members[from:to], nil
+}
+
+func (us *UserService) InsertUser(ctx context.Context, user forum.User) error {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	if err := us.s.d.checkUserUnique(user, 0); err != nil {
+		return err
+	}
+	us.s.d.userSeq++
+	user.Id = us.s.d.userSeq
+	us.s.d.users[user.Id] = user
+	return us.s.d.appendChange(forum.ChangeUserInserted, user.NickName, user)
+}
+
+func (us *UserService) UpdateUser(ctx context.Context, user forum.User) error {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	old, ok := us.s.d.users[user.Id]
+	if !ok {
+		return nil
+	}
+	if err := us.s.d.checkUserUnique(forum.User{Email: user.Email}, user.Id); err != nil {
+		return err
+	}
+	old.Email = user.Email
+	old.FullName = user.FullName
+	old.About = user.About
+	us.s.d.users[user.Id] = old
+	return us.s.d.appendChange(forum.ChangeUserUpdated, old.NickName, old)
+}
+
+func (us *UserService) FindUserByNickName(ctx context.Context, nickName string) (user forum.User, err error) {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	u, ok := us.s.d.userByNickName(nickName)
+	if !ok {
+		return user, forum.ErrNotFound
+	}
+	return forum.User{Id: u.Id, NickName: u.NickName}, nil
+}
+
+func (us *UserService) UpdatePassword(ctx context.Context, nickName string, hash string) error {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	user, ok := us.s.d.userByNickName(nickName)
+	if !ok {
+		return forum.ErrNotFound
+	}
+	us.s.d.passwords[user.Id] = hash
+	return us.s.d.appendChange(forum.ChangeUserPassword, user.NickName, struct{}{})
+}
+
+func (us *UserService) SelectPasswordHash(ctx context.Context, nickName string) (hash string, err error) {
+	us.s.mu.Lock()
+	defer us.s.mu.Unlock()
+
+	user, ok := us.s.d.userByNickName(nickName)
+	if !ok {
+		return "", forum.ErrNotFound
+	}
+	return us.s.d.passwords[user.Id], nil
+}
+
+func (d *data) sortedUsers() []forum.User {
+	users := make([]forum.User, 0, len(d.users))
+	for _, u := range d.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users
+}
+
+func (d *data) userByNickName(nickName string) (forum.User, bool) {
+	for _, u := range d.users {
+		if fold(u.NickName) == fold(nickName) {
+			return u, true
+		}
+	}
+	return forum.User{}, false
+}
+
+func (d *data) checkUserUnique(user forum.User, exceptId int) error {
+	for _, u := range d.users {
+		if u.Id == exceptId {
+			continue
+		}
+		if user.NickName != "" && fold(u.NickName) == fold(user.NickName) {
+			return errors.New(`duplicate key value violates unique constraint "user_nick_name_uindex"`)
+		}
+		if fold(u.Email) == fold(user.Email) {
+			return errors.New(`duplicate key value violates unique constraint "user_email_uindex"`)
+		}
+	}
+	return nil
+}
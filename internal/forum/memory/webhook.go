@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+type WebhookService struct {
+	s *Store
+}
+
+func (ws *WebhookService) InsertWebhook(ctx context.Context, hook forum.Webhook) (forum.Webhook, error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	f, ok := ws.s.d.forumBySlug(hook.Forum)
+	if !ok {
+		return forum.Webhook{}, forum.ErrNotFound
+	}
+	ws.s.d.webhookSeq++
+	hook.Id = ws.s.d.webhookSeq
+	hook.Forum = f.Slug
+	hook.Events = append([]string(nil), hook.Events...)
+	hook.Created = time.Now()
+	ws.s.d.webhooks[hook.Id] = hook
+	return hook, nil
+}
+
+func (ws *WebhookService) SelectWebhooks(ctx context.Context, forumSlug string) (hooks []forum.Webhook, err error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	for _, hook := range ws.s.d.webhooks {
+		if fold(hook.Forum) == fold(forumSlug) {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].Id < hooks[j].Id })
+	return hooks, nil
+}
+
+func (ws *WebhookService) SelectWebhook(ctx context.Context, id int) (forum.Webhook, error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	hook, ok := ws.s.d.webhooks[id]
+	if !ok {
+		return forum.Webhook{}, forum.ErrNotFound
+	}
+	return hook, nil
+}
+
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, id int) error {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	if _, ok := ws.s.d.webhooks[id]; !ok {
+		return forum.ErrNotFound
+	}
+	delete(ws.s.d.webhooks, id)
+	for k, d := range ws.s.d.deliveries {
+		if d.Webhook == id {
+			delete(ws.s.d.deliveries, k)
+		}
+	}
+	return nil
+}
+
+func (ws *WebhookService) EnqueueDelivery(ctx context.Context, forumSlug, event string, payload []byte) (int, error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	now := time.Now()
+	queued := 0
+	for _, id := range webhookIds(ws.s.d.webhooks) {
+		hook := ws.s.d.webhooks[id]
+		if (forumSlug != "" && fold(hook.Forum) != fold(forumSlug)) || !hook.Subscribes(event) {
+			continue
+		}
+		ws.s.d.deliverySeq++
+		ws.s.d.deliveries[ws.s.d.deliverySeq] = forum.WebhookDelivery{
+			Id:          ws.s.d.deliverySeq,
+			Webhook:     hook.Id,
+			Event:       event,
+			Payload:     append([]byte(nil), payload...),
+			Status:      forum.DeliveryPending,
+			NextAttempt: now,
+			Created:     now,
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+func (ws *WebhookService) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]forum.WebhookDelivery, error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	var due []forum.WebhookDelivery
+	for _, d := range ws.s.d.deliveries {
+		if d.Status == forum.DeliveryPending && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttempt.Equal(due[j].NextAttempt) {
+			return due[i].NextAttempt.Before(due[j].NextAttempt)
+		}
+		return due[i].Id < due[j].Id
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].NextAttempt = now.Add(lease)
+		ws.s.d.deliveries[due[i].Id] = due[i]
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Id < due[j].Id })
+	return due, nil
+}
+
+func (ws *WebhookService) UpdateDelivery(ctx context.Context, d forum.WebhookDelivery) error {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	old, ok := ws.s.d.deliveries[d.Id]
+	if !ok {
+		return forum.ErrNotFound
+	}
+	old.Status = d.Status
+	old.Attempts = d.Attempts
+	old.NextAttempt = d.NextAttempt
+	old.ResponseCode = d.ResponseCode
+	old.Error = d.Error
+	old.Delivered = d.Delivered
+	ws.s.d.deliveries[d.Id] = old
+	return nil
+}
+
+func (ws *WebhookService) SelectDeliveries(ctx context.Context, webhook int, limit int) (deliveries []forum.WebhookDelivery, err error) {
+	ws.s.mu.Lock()
+	defer ws.s.mu.Unlock()
+
+	for _, d := range ws.s.d.deliveries {
+		if d.Webhook == webhook {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Id > deliveries[j].Id })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func webhookIds(hooks map[int]forum.Webhook) []int {
+	ids := make([]int, 0, len(hooks))
+	for id := range hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -32,19 +32,82 @@ type Thread struct {
 	Slug    string    `json:"slug"`
 	Title   string    `json:"title"`
 	Votes   int       `json:"votes"`
+
+	Locked   bool `json:"locked"`
+	Pinned   bool `json:"pinned"`
+	Archived bool `json:"archived"`
+}
+
+// CanPost reports why no posts may be added to the thread, if they may not.
+func (t Thread) CanPost() error {
+	if t.Archived {
+		return ErrThreadArchived
+	}
+	if t.Locked {
+		return ErrThreadLocked
+	}
+	return nil
+}
+
+// CanChange reports why the thread, its posts and its votes may not be
+// changed, if they may not.
+func (t Thread) CanChange() error {
+	if t.Archived {
+		return ErrThreadArchived
+	}
+	return nil
 }
 
 type Post struct {
-	Author        string  `json:"author"`
-	Created       string  `json:"created"`
-	Forum         string  `json:"forum"`
-	Id            int     `json:"id"`
-	IsEdited      bool    `json:"isEdited"`
-	Message       string  `json:"message"`
-	Parent        int     `json:"parent"`
-	Thread        int     `json:"thread"`
-	Path          []int64 `json:"-"`
-	ParentPointer *Post   `json:"-"`
+	Author   string  `json:"author"`
+	Created  string  `json:"created"`
+	Forum    string  `json:"forum"`
+	Id       int     `json:"id"`
+	IsEdited bool    `json:"isEdited"`
+	Message  string  `json:"message"`
+	Parent   int     `json:"parent"`
+	Thread   int     `json:"thread"`
+	Deleted  bool    `json:"deleted,omitempty"`
+	Path     []int64 `json:"-"`
+}
+
+// PostRevision is one version of a post's message. Revision 0 is the text
+// the post was created with.
+type PostRevision struct {
+	Post     int       `json:"post"`
+	Revision int       `json:"revision"`
+	Message  string    `json:"message"`
+	Editor   string    `json:"editor"`
+	Created  time.Time `json:"created"`
+}
+
+// Tombstone blanks a deleted post so that it keeps its place in a tree
+// without exposing what was removed.
+func Tombstone(p Post) Post {
+	p.Author = ""
+	p.Message = ""
+	p.IsEdited = false
+	p.Deleted = true
+	return p
+}
+
+// Removal is what a delete took away from a forum's counters: threads and
+// posts that were still visible before it ran.
+type Removal struct {
+	Forum   string
+	Threads int
+	Posts   int
+}
+
+// Transfer is what a move took from one forum's counters and gave to
+// another's: visible threads and posts, and the authors of everything moved,
+// whose forum membership may have changed.
+type Transfer struct {
+	From    string
+	To      string
+	Threads int
+	Posts   int
+	Authors []string
 }
 
 type Vote struct {
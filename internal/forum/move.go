@@ -0,0 +1,64 @@
+package forum
+
+import "context"
+
+// MoveThread moves a thread with its posts to another forum, moving their
+// counts and their authors' membership along. It should run inside a
+// transaction.
+func MoveThread(ctx context.Context, tx Tx, id int, forum string) error {
+	t, err := tx.Threads.MoveThread(ctx, id, forum)
+	if err != nil {
+		return err
+	}
+	return transfer(ctx, tx, t)
+}
+
+// MergeThreads moves every post of thread from into thread into and then
+// soft-deletes from on behalf of by. The old roots become replies to
+// parent, a post of into, or stay roots when parent is 0. It should run
+// inside a transaction.
+func MergeThreads(ctx context.Context, tx Tx, from, into, parent int, by string) error {
+	if from == into {
+		return ErrSameThread
+	}
+	t, err := tx.Posts.MovePosts(ctx, from, into, parent)
+	if err != nil {
+		return err
+	}
+	if err = transfer(ctx, tx, t); err != nil {
+		return err
+	}
+	return DeleteThread(ctx, tx, from, by)
+}
+
+// SplitThread creates thread and moves post id with all its replies into
+// it, the post becoming a root. thread needs its ForumId set. It returns the
+// new thread's id and should run inside a transaction.
+func SplitThread(ctx context.Context, tx Tx, id int, thread Thread) (int, error) {
+	threadId, err := tx.Threads.InsertThread(ctx, thread)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Forums.UpdateThreadCount(ctx, thread.ForumId); err != nil {
+		return 0, err
+	}
+	t, err := tx.Posts.MoveSubtree(ctx, id, threadId)
+	if err != nil {
+		return 0, err
+	}
+	t.Authors = append(t.Authors, thread.Author)
+	return threadId, transfer(ctx, tx, t)
+}
+
+func transfer(ctx context.Context, tx Tx, t Transfer) error {
+	if err := tx.Forums.UpdateCounts(ctx, t.From, -t.Threads, -t.Posts); err != nil {
+		return err
+	}
+	if err := tx.Forums.UpdateCounts(ctx, t.To, t.Threads, t.Posts); err != nil {
+		return err
+	}
+	if err := tx.Forums.SyncForumUsers(ctx, t.From, t.Authors); err != nil {
+		return err
+	}
+	return tx.Forums.SyncForumUsers(ctx, t.To, t.Authors)
+}
@@ -0,0 +1,165 @@
+package forum
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Change kinds, named after the table and what happened to the row. The
+// posts of a deleted or purged thread go with it and get no changes of
+// their own; those of a moved thread each get a post.moved.
+const (
+	ChangeUserInserted   = "user.inserted"
+	ChangeUserUpdated    = "user.updated"
+	ChangeUserPassword   = "user.password"
+	ChangeForumInserted  = "forum.inserted"
+	ChangeThreadInserted = "thread.inserted"
+	ChangeThreadUpdated  = "thread.updated"
+	ChangeThreadFlagged  = "thread.flagged"
+	ChangeThreadDeleted  = "thread.deleted"
+	ChangeThreadPurged   = "thread.purged"
+	ChangeThreadMoved    = "thread.moved"
+	ChangePostInserted   = "post.inserted"
+	ChangePostUpdated    = "post.updated"
+	ChangePostDeleted    = "post.deleted"
+	ChangePostPurged     = "post.purged"
+	ChangePostMoved      = "post.moved"
+	ChangeVoteInserted   = "vote.inserted"
+	ChangeVoteUpdated    = "vote.updated"
+
+	// ChangeAllCleared is recorded, with an empty key, when every table but
+	// the outbox is emptied.
+	ChangeAllCleared = "all.cleared"
+)
+
+// Change is a write recorded in the outbox. Seq numbers changes once they
+// are committed, so a reader that has seen one never gets a lower seq
+// afterwards. Key identifies the row: a nickname, a forum slug, a thread or
+// post id, or "thread:user" ids for votes. Data is the row as written.
+type Change struct {
+	Seq     int64           `json:"seq"`
+	Kind    string          `json:"kind"`
+	Key     string          `json:"key"`
+	Data    json.RawMessage `json:"data"`
+	Created time.Time       `json:"created"`
+}
+
+// ChangeFeed is a page of the change feed: the changes after a seq and the
+// seq to ask for the next page after.
+type ChangeFeed struct {
+	Events []Change `json:"events"`
+	Last   int64    `json:"last"`
+}
+
+// VoteChange is the data of the vote changes.
+type VoteChange struct {
+	Thread int `json:"thread"`
+	User   int `json:"user"`
+	Voice  int `json:"voice"`
+}
+
+// ThreadFlagsChange is the data of the thread.flagged changes.
+type ThreadFlagsChange struct {
+	Locked   bool `json:"locked"`
+	Pinned   bool `json:"pinned"`
+	Archived bool `json:"archived"`
+}
+
+// RemovalChange is the data of the delete and purge changes: the forum the
+// thread or post was in and, for deletes, who deleted it.
+type RemovalChange struct {
+	Forum string `json:"forum"`
+	By    string `json:"by,omitempty"`
+}
+
+// ThreadMoveChange is the data of the thread.moved changes.
+type ThreadMoveChange struct {
+	From  string `json:"from"`
+	Forum string `json:"forum"`
+}
+
+// PostMoveChange is the data of the post.moved changes: the forum the post
+// was in and where it is now.
+type PostMoveChange struct {
+	From   string `json:"from"`
+	Forum  string `json:"forum"`
+	Thread int    `json:"thread"`
+	Parent int    `json:"parent"`
+}
+
+// VoteKey is the key of the changes to a vote.
+func VoteKey(vote Vote) string {
+	return strconv.Itoa(vote.ThreadId) + ":" + strconv.Itoa(vote.UserId)
+}
+
+// appendChange records a change in the outbox. Writers call it in the
+// transaction making the change, so that the change is recorded if and
+// only if it is committed.
+func appendChange(ctx context.Context, db Querier, kind, key string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sqlQuery := `INSERT INTO outbox (kind, key, data) VALUES ($1, $2, $3::jsonb)`
+	_, err = db.ExecEx(ctx, sqlQuery, nil, kind, key, string(payload))
+	return err
+}
+
+// appendPostChanges records a change of kind for each of posts, keyed by
+// post id, in one statement.
+func appendPostChanges(ctx context.Context, db Querier, kind string, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(posts)
+	if err != nil {
+		return err
+	}
+	sqlQuery := `
+	INSERT INTO outbox (kind, key, data)
+	SELECT $1, e.value->>'id', e.value
+	FROM jsonb_array_elements($2::jsonb) WITH ORDINALITY AS e (value, n)
+	ORDER BY e.n`
+	_, err = db.ExecEx(ctx, sqlQuery, nil, kind, string(payload))
+	return err
+}
+
+type OutboxService struct {
+	db Querier
+}
+
+func NewOutboxService(db Querier) *OutboxService {
+	return &OutboxService{db: db}
+}
+
+// SelectChanges lists up to limit changes with a seq above after, in seq
+// order. It first numbers the changes that have become final; those of
+// transactions running alongside older ones wait for them to end.
+func (ob *OutboxService) SelectChanges(ctx context.Context, after int64, limit int) (changes []Change, err error) {
+	if _, err = ob.db.ExecEx(ctx, `SELECT outbox_sequence()`, nil); err != nil {
+		return nil, err
+	}
+	sqlQuery := `
+	SELECT seq, kind, key, data::text, created FROM outbox
+	WHERE seq > $1
+	ORDER BY seq
+	LIMIT $2`
+	rows, err := ob.db.QueryEx(ctx, sqlQuery, nil, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Change{}
+		var data string
+		if err = rows.Scan(&c.Seq, &c.Kind, &c.Key, &data, &c.Created); err != nil {
+			return nil, err
+		}
+		c.Data = json.RawMessage(data)
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,153 @@
+package forum
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBadPage is returned for a page with a negative limit, or with a since
+// that is not what its listing starts from.
+var ErrBadPage = errors.New("Malformed since or limit")
+
+// ErrBadSort is returned for a post listing in an order other than flat,
+// tree and parent_tree.
+var ErrBadSort = errors.New("Unknown sort")
+
+// Page selects part of a listing: at most Limit items in the listing's
+// order, descending if Desc is set, from its start or from just past After.
+// Since is the older way to start partway, and what it holds depends on the
+// listing; it is ignored when After is set.
+type Page struct {
+	Limit int
+	Desc  bool
+	Since string
+	After *Cursor
+}
+
+// Check returns ErrBadPage when the limit is negative.
+func (p Page) Check() error {
+	if p.Limit < 0 {
+		return ErrBadPage
+	}
+	return nil
+}
+
+// SinceID parses Since as the id of the post a listing starts after.
+func (p Page) SinceID() (int, error) {
+	id, err := strconv.Atoi(p.Since)
+	if err != nil {
+		return 0, ErrBadPage
+	}
+	return id, nil
+}
+
+// SinceTime parses Since as the creation time a listing starts at.
+func (p Page) SinceTime() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, p.Since)
+	if err != nil {
+		return t, ErrBadPage
+	}
+	return t, nil
+}
+
+// Cursor is a position in a listing, handed to clients as an opaque string.
+// It names the listing and its order, so a client only has to pass it
+// back, and holds the sort key of the item the page starts from with the
+// item's id to break ties between equal keys. A Back cursor selects the
+// items before that one instead of after it, still in the listing's order.
+type Cursor struct {
+	Kind   string  `json:"k"`
+	Desc   bool    `json:"d,omitempty"`
+	Back   bool    `json:"b,omitempty"`
+	Pinned bool    `json:"p,omitempty"`
+	Key    string  `json:"v,omitempty"`
+	Path   []int64 `json:"a,omitempty"`
+	Id     int     `json:"i,omitempty"`
+}
+
+// Cursor kinds. Posts use the names of their sorts.
+const (
+	ThreadCursor     = "thread"
+	UserCursor       = "user"
+	FlatCursor       = "flat"
+	TreeCursor       = "tree"
+	ParentTreeCursor = "parent_tree"
+)
+
+// Cursor returns the position of t in its forum's threads, which are
+// listed pinned first and then by creation time and id.
+func (t Thread) Cursor(desc bool) Cursor {
+	return Cursor{Kind: ThreadCursor, Desc: desc, Pinned: t.Pinned, Key: t.Created.Format(time.RFC3339Nano), Id: t.Id}
+}
+
+// Cursor returns the position of u in a forum's users, which are listed by
+// nickname. Nicknames are unique, so they need no tie-breaker.
+func (u User) Cursor(desc bool) Cursor {
+	return Cursor{Kind: UserCursor, Desc: desc, Key: u.NickName}
+}
+
+// Cursor returns the position of p in its thread's posts sorted by sort:
+// creation time and id for flat, the path for tree, and the id of the root
+// for parent_tree, whose pages are made of whole root trees.
+func (p Post) Cursor(sort string, desc bool) Cursor {
+	c := Cursor{Kind: sort, Desc: desc}
+	switch sort {
+	case FlatCursor:
+		c.Key, c.Id = p.Created, p.Id
+	case TreeCursor:
+		c.Path, c.Id = p.Path, p.Id
+	case ParentTreeCursor:
+		if len(p.Path) > 0 {
+			c.Id = int(p.Path[0])
+		}
+	}
+	return c
+}
+
+// Time returns the creation time held by a thread cursor.
+func (c Cursor) Time() (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, c.Key)
+}
+
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ErrBadCursor is returned for a cursor that does not decode, or does not
+// hold what its kind needs.
+var ErrBadCursor = errors.New("Malformed cursor")
+
+// ParseCursor decodes a cursor and checks that it holds what its kind
+// needs.
+func ParseCursor(s string) (c Cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, ErrBadCursor
+	}
+	var ok bool
+	switch c.Kind {
+	case ThreadCursor:
+		_, err = c.Time()
+		ok = err == nil
+	case UserCursor:
+		ok = c.Key != ""
+	case FlatCursor:
+		ok = c.Key != "" && c.Id > 0
+	case TreeCursor:
+		ok = len(c.Path) > 0
+	case ParentTreeCursor:
+		ok = c.Id > 0
+	}
+	if !ok {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
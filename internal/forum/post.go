@@ -1,97 +1,211 @@
 package forum
 
 import (
+	"context"
 	"github.com/jackc/pgx"
-	"github.com/pkg/errors"
+	"github.com/lib/pq"
+	"sort"
 	"strconv"
 	"strings"
+	"tech-db/internal/sqlb"
 )
 
 type PostService struct {
-	db *pgx.ConnPool
+	db Querier
 }
 
-func NewPostService(db *pgx.ConnPool) *PostService {
+func NewPostService(db Querier) *PostService {
 	return &PostService{db: db}
 }
 
-func (ps *PostService) SelectPostById(id int) (post Post, err error) {
+func (ps *PostService) SelectPostById(ctx context.Context, id int) (post Post, err error) {
 	sqlQuery := `SELECT p.author, p.created, p.forum, p.id, p.is_edited, p.message, p.parent, p.thread FROM post as p
-	where p.id=$1`
-	err = ps.db.QueryRow(sqlQuery, id).Scan(&post.Author, &post.Created, &post.Forum, &post.Id, &post.IsEdited, &post.Message, &post.Parent, &post.Thread)
+	JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+	where p.id=$1 AND p.deleted_at IS NULL`
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&post.Author, &post.Created, &post.Forum, &post.Id, &post.IsEdited, &post.Message, &post.Parent, &post.Thread)
 	return
 }
 
-func (ps *PostService) FindPostById(id int, thread int) (err error) {
+// SelectPostsByIds reads the visible posts among ids, in id order. Ids of
+// no visible post are skipped.
+func (ps *PostService) SelectPostsByIds(ctx context.Context, ids []int) (posts []Post, err error) {
+	sqlQuery := `SELECT p.author, p.created, p.forum, p.id, p.is_edited, p.message, p.parent, p.thread FROM post as p
+	JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+	where p.id = ANY ($1::int[]) AND p.deleted_at IS NULL
+	ORDER BY p.id`
+	rows, err := ps.db.QueryEx(ctx, sqlQuery, nil, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post := Post{}
+		if err = rows.Scan(&post.Author, &post.Created, &post.Forum, &post.Id, &post.IsEdited, &post.Message, &post.Parent, &post.Thread); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (ps *PostService) FindPostById(ctx context.Context, id int, thread int) (err error) {
 	sqlQuery := `SELECT p.id FROM post as p where p.id=$1 AND p.thread=$2`
 	var postId int64
-	err = ps.db.QueryRow(sqlQuery, id, thread).Scan(&postId)
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id, thread).Scan(&postId)
 	return
 }
 
-func (ps *PostService) InsertPost(post Post) (lastId int, err error) {
+func (ps *PostService) InsertPost(ctx context.Context, post Post) (lastId int, err error) {
 	sqlQuery := `INSERT INTO post (author, created, forum, message, parent, thread)
 	VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`
-	err = ps.db.QueryRow(sqlQuery, post.Author, post.Created, post.Forum, post.Message, post.Parent, post.Thread).Scan(&lastId)
-	return
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, post.Author, post.Created, post.Forum, post.Message, post.Parent, post.Thread).Scan(&lastId)
+	if err != nil {
+		return
+	}
+	post.Id = lastId
+	return lastId, appendChange(ctx, ps.db, ChangePostInserted, strconv.Itoa(lastId), post)
 }
 
-func (ps *PostService) UpdatePostMessage(newMessage string, id int) (countUpdateString int64, err error) {
-	sqlQuery := `UPDATE post SET message=$1, is_edited=true where post.id=$2`
-	result, err := ps.db.Exec(sqlQuery, newMessage, id)
+// UpdatePostMessage replaces a visible post's message and records the new
+// text as a revision by editor, saving the original text as revision 0 on
+// the first edit. It should run inside a transaction.
+func (ps *PostService) UpdatePostMessage(ctx context.Context, newMessage string, id int, editor string) (countUpdateString int64, err error) {
+	sqlQuery := `
+	SELECT p.message, p.author, p.created, p.forum, p.parent, p.thread FROM post as p
+	WHERE p.id=$1 AND p.deleted_at IS NULL
+	FOR UPDATE`
+	var old Post
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&old.Message, &old.Author, &old.Created, &old.Forum, &old.Parent, &old.Thread)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+
+	sqlQuery = `
+	INSERT INTO post_revision (post_id, revision, message, editor, created)
+	VALUES ($1, 0, $2, $3, $4::text::timestamptz) ON CONFLICT DO NOTHING`
+	if _, err = ps.db.ExecEx(ctx, sqlQuery, nil, id, old.Message, old.Author, old.Created); err != nil {
+		return
+	}
+
+	sqlQuery = `UPDATE post SET message=$1, is_edited=true where post.id=$2`
+	result, err := ps.db.ExecEx(ctx, sqlQuery, nil, newMessage, id)
 	if err != nil {
 		return
 	}
 	countUpdateString = result.RowsAffected()
+
+	sqlQuery = `
+	INSERT INTO post_revision (post_id, revision, message, editor)
+	SELECT $1, max(r.revision)+1, $2, $3 FROM post_revision as r WHERE r.post_id=$1`
+	if _, err = ps.db.ExecEx(ctx, sqlQuery, nil, id, newMessage, editor); err != nil {
+		return
+	}
+	edited := old
+	edited.Id, edited.Message, edited.IsEdited = id, newMessage, true
+	err = appendChange(ctx, ps.db, ChangePostUpdated, strconv.Itoa(id), edited)
 	return
 }
 
-func (ps *PostService) CreatePosts(thread Thread, forumId int, created string, posts []Post) (post []Post, err error) {
-	sqlStr := "INSERT INTO post(id, parent, thread, forum, author, created, message, path) VALUES "
-	vals := []interface{}{}
-	for _, post := range posts {
+func (ps *PostService) SelectPostRevisions(ctx context.Context, id int) (revisions []PostRevision, err error) {
+	sqlQuery := `
+	SELECT r.post_id, r.revision, r.message, r.editor, r.created
+	FROM post_revision as r
+	WHERE r.post_id=$1
+	ORDER BY r.revision`
+	rows, err := ps.db.QueryEx(ctx, sqlQuery, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := PostRevision{}
+		if err := rows.Scan(&r.Post, &r.Revision, &r.Message, &r.Editor, &r.Created); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// copyPostsMin is the batch size from which CreatePosts loads posts with
+// COPY. Smaller batches take fewer round trips as one INSERT.
+const copyPostsMin = 100
+
+// CreatePosts adds posts to thread, with ids in the order of posts.
+func (ps *PostService) CreatePosts(ctx context.Context, thread Thread, forumId int, created string, posts []Post) (post []Post, err error) {
+	if len(posts) >= copyPostsMin && canCopy(ps.db) {
+		post, err = ps.copyPosts(ctx, thread, forumId, created, posts)
+	} else {
+		post, err = ps.insertPosts(ctx, thread, forumId, created, posts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := appendPostChanges(ctx, ps.db, ChangePostInserted, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// insertPosts looks up the author and the parent of each post in turn and
+// adds the posts with one multi-row INSERT.
+func (ps *PostService) insertPosts(ctx context.Context, thread Thread, forumId int, created string, posts []Post) (post []Post, err error) {
+	q := sqlb.New("INSERT INTO post(id, parent, thread, forum, author, created, message, path) VALUES ")
+	for i, post := range posts {
 		var authorId int
-		err = ps.db.QueryRow(`SELECT "user".id FROM "user" WHERE "user".nick_name=$1`,
+		err = ps.db.QueryRowEx(ctx, `SELECT "user".id FROM "user" WHERE "user".nick_name=$1`, nil,
 			post.Author,
 		).Scan(&authorId)
 		if err != nil {
-			return nil, errors.New("404")
+			if err == pgx.ErrNoRows {
+				return nil, ErrAuthorNotFound
+			}
+			return nil, err
 		}
 		sqlQuery := `
-		INSERT INTO forum_user (forum_id, user_id) VALUES ($1,$2)`
-		_, _ = ps.db.Exec(sqlQuery, forumId, authorId)
+		INSERT INTO forum_user (forum_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`
+		_, err = ps.db.ExecEx(ctx, sqlQuery, nil, forumId, authorId)
+		if err != nil {
+			return nil, err
+		}
 
+		if i > 0 {
+			q.SQL(", ")
+		}
 		if post.Parent == 0 {
-			sqlStr += "(nextval('post_id_seq'::regclass), ?, ?, ?, ?, ?, ?, " +
-				"ARRAY[currval(pg_get_serial_sequence('post', 'id'))::bigint]),"
-			vals = append(vals, post.Parent, thread.Id, thread.Forum, post.Author, created, post.Message)
+			q.SQL("(nextval('post_id_seq'::regclass), ").
+				Args(post.Parent, thread.Id, thread.Forum, post.Author, created, post.Message).
+				SQL(", ARRAY[currval(pg_get_serial_sequence('post', 'id'))::bigint])")
 		} else {
 			var parentThreadId int32
-			err = ps.db.QueryRow("SELECT post.thread FROM post WHERE post.id=$1",
+			err = ps.db.QueryRowEx(ctx, "SELECT post.thread FROM post WHERE post.id=$1", nil,
 				post.Parent,
 			).Scan(&parentThreadId)
 			if err != nil {
+				if err == pgx.ErrNoRows {
+					return nil, ErrParentConflict
+				}
 				return nil, err
 			}
 			if parentThreadId != int32(thread.Id) {
-				return nil, errors.New("Parent post was created in another thread")
+				return nil, ErrParentConflict
 			}
 
-			sqlStr += " (nextval('post_id_seq'::regclass), ?, ?, ?, ?, ?, ?, " +
-				"(SELECT post.path FROM post WHERE post.id = ? AND post.thread = ?) || " +
-				"currval(pg_get_serial_sequence('post', 'id'))::bigint),"
-
-			vals = append(vals, post.Parent, thread.Id, thread.Forum, post.Author, created, post.Message, post.Parent, thread.Id)
+			q.SQL("(nextval('post_id_seq'::regclass), ").
+				Args(post.Parent, thread.Id, thread.Forum, post.Author, created, post.Message).
+				SQL(", (SELECT post.path FROM post WHERE post.id = ").Arg(post.Parent).
+				SQL(" AND post.thread = ").Arg(thread.Id).
+				SQL(") || currval(pg_get_serial_sequence('post', 'id'))::bigint)")
 		}
-
 	}
-	sqlStr = strings.TrimSuffix(sqlStr, ",")
-
-	sqlStr += " RETURNING id, parent, thread, forum, author, created, message, is_edited"
+	q.SQL(" RETURNING id, parent, thread, forum, author, created, message, is_edited")
 
-	sqlStr = ReplaceSQL(sqlStr, "?")
+	sqlStr, vals := q.Build()
 	if len(posts) > 0 {
-		rows, err := ps.db.Query(sqlStr, vals...)
+		rows, err := ps.db.QueryEx(ctx, sqlStr, nil, vals...)
 		if err != nil {
 			return nil, err
 		}
@@ -114,14 +228,310 @@ func (ps *PostService) CreatePosts(thread Thread, forumId int, created string, p
 			post = append(post, scanPost)
 		}
 		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
 	return post, nil
 }
 
-func ReplaceSQL(old, searchPattern string) string {
-	tmpCount := strings.Count(old, searchPattern)
-	for m := 1; m <= tmpCount; m++ {
-		old = strings.Replace(old, searchPattern, "$"+strconv.Itoa(m), 1)
+var postColumns = []string{"id", "parent", "thread", "forum", "author", "created", "message", "path"}
+
+// copyPosts resolves the authors and the parents of all posts with one
+// query each, takes their ids from post_id_seq at once, builds the paths
+// and loads the rows with COPY.
+func (ps *PostService) copyPosts(ctx context.Context, thread Thread, forumId int, created string, posts []Post) ([]Post, error) {
+	var nickNames []string
+	var parentIds []int32
+	for _, p := range posts {
+		nickNames = append(nickNames, p.Author)
+		if p.Parent != 0 {
+			parentIds = append(parentIds, int32(p.Parent))
+		}
+	}
+
+	authors := map[string]int32{}
+	sqlQuery := `SELECT u.nick_name::text, u.id FROM "user" AS u WHERE u.nick_name = ANY ($1::text[]::citext[])`
+	rows, err := ps.db.QueryEx(ctx, sqlQuery, nil, nickNames)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var nickName string
+		var id int32
+		if err := rows.Scan(&nickName, &id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		authors[strings.ToLower(nickName)] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	parentPaths := map[int][]int64{}
+	if len(parentIds) > 0 {
+		sqlQuery = `SELECT p.id, p.path FROM post AS p WHERE p.id = ANY ($1::int[]) AND p.thread = $2`
+		rows, err := ps.db.QueryEx(ctx, sqlQuery, nil, parentIds, thread.Id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var path []int64
+			if err := rows.Scan(&id, &path); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			parentPaths[id] = path
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	userIds := []int32{}
+	seen := map[int32]bool{}
+	for _, p := range posts {
+		id, ok := authors[strings.ToLower(p.Author)]
+		if !ok {
+			return nil, ErrAuthorNotFound
+		}
+		if _, ok := parentPaths[p.Parent]; p.Parent != 0 && !ok {
+			return nil, ErrParentConflict
+		}
+		if !seen[id] {
+			seen[id] = true
+			userIds = append(userIds, id)
+		}
+	}
+	// a fixed order keeps concurrent batches from deadlocking on the index
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	sqlQuery = `
+	INSERT INTO forum_user (forum_id, user_id)
+	SELECT $1, u.id FROM unnest($2::int[]) AS u (id)
+	ON CONFLICT DO NOTHING`
+	if _, err := ps.db.ExecEx(ctx, sqlQuery, nil, forumId, userIds); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(posts))
+	sqlQuery = `SELECT nextval('post_id_seq') FROM generate_series(1, $1)`
+	rows, err = ps.db.QueryEx(ctx, sqlQuery, nil, len(posts))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	added := make([]Post, len(posts))
+	copyRows := make([][]interface{}, len(posts))
+	for i, p := range posts {
+		parentPath := parentPaths[p.Parent]
+		path := append(append(make([]int64, 0, len(parentPath)+1), parentPath...), ids[i])
+		added[i] = Post{Id: int(ids[i]), Parent: p.Parent, Thread: thread.Id, Forum: thread.Forum, Author: p.Author, Created: created, Message: p.Message}
+		copyRows[i] = []interface{}{int32(ids[i]), int32(p.Parent), int32(thread.Id), thread.Forum, p.Author, created, p.Message, path}
+	}
+	if _, err := copyFrom(ctx, ps.db, pgx.Identifier{"post"}, postColumns, copyRows); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// DeletePost marks a visible post in a visible thread deleted. Replies to it
+// are kept.
+func (ps *PostService) DeletePost(ctx context.Context, id int, by string) (removal Removal, err error) {
+	sqlQuery := `
+	UPDATE post SET deleted_at=now(), deleted_by=NULLIF($2, '')
+	WHERE post.id=$1 AND post.deleted_at IS NULL
+		AND EXISTS (SELECT 1 FROM thread as t WHERE t.id = post.thread AND t.deleted_at IS NULL)
+	RETURNING post.forum`
+	if err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id, by).Scan(&removal.Forum); err != nil {
+		return
+	}
+	removal.Posts = 1
+	err = appendChange(ctx, ps.db, ChangePostDeleted, strconv.Itoa(id), RemovalChange{Forum: removal.Forum, By: by})
+	return
+}
+
+// PurgePost deletes a post and all of its replies, whether or not they were
+// soft-deleted before, and records a post.purged change for each.
+func (ps *PostService) PurgePost(ctx context.Context, id int) (removal Removal, err error) {
+	sqlQuery := `
+	WITH target AS (
+		SELECT p.id, p.thread, p.forum, t.deleted_at IS NULL AS live_thread
+		FROM post as p JOIN thread as t ON t.id = p.thread
+		WHERE p.id=$1
+	), posts AS (
+		DELETE FROM post USING target
+		WHERE post.thread = target.thread AND post.path @> ARRAY[target.id::bigint]
+		RETURNING post.id, post.deleted_at
+	), changes AS (
+		INSERT INTO outbox (kind, key, data)
+		SELECT $2::text, posts.id::text, jsonb_build_object('forum', target.forum)
+		FROM posts, target
+		ORDER BY posts.id
+	)
+	SELECT target.forum,
+		   CASE WHEN target.live_thread THEN (SELECT count(*) FROM posts WHERE posts.deleted_at IS NULL) ELSE 0 END
+	FROM target`
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id, ChangePostPurged).Scan(&removal.Forum, &removal.Posts)
+	return
+}
+
+// MovePosts moves every post of visible thread from into visible thread
+// into. Root posts become replies to parent, which must be a post of into,
+// unless it is 0; paths are re-rooted to match. Ids are kept, so replies
+// keep their places under their parents. Each moved post gets a post.moved
+// change.
+func (ps *PostService) MovePosts(ctx context.Context, from, into, parent int) (t Transfer, err error) {
+	if parent != 0 {
+		var parentThread int
+		err = ps.db.QueryRowEx(ctx, `SELECT post.thread FROM post WHERE post.id=$1`, nil, parent).Scan(&parentThread)
+		if err == ErrNotFound || (err == nil && parentThread != into) {
+			return t, ErrParentConflict
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+	sqlQuery := `
+	WITH src AS (
+		SELECT t.id, t.forum FROM thread as t WHERE t.id=$1 AND t.deleted_at IS NULL FOR UPDATE
+	), dst AS (
+		SELECT t.id, t.forum FROM thread as t WHERE t.id=$2 AND t.deleted_at IS NULL FOR UPDATE
+	), prefix AS (
+		SELECT COALESCE((SELECT p.path FROM post as p WHERE p.id=$3), '{}'::bigint[]) AS path
+	), moved AS (
+		UPDATE post SET thread=dst.id, forum=dst.forum, path=prefix.path || post.path,
+			parent=CASE WHEN post.parent=0 THEN $3 ELSE post.parent END
+		FROM src, dst, prefix
+		WHERE post.thread=src.id
+		RETURNING post.id, post.parent, post.author, post.deleted_at
+	), changes AS (
+		INSERT INTO outbox (kind, key, data)
+		SELECT $4::text, moved.id::text,
+			jsonb_build_object('from', src.forum, 'forum', dst.forum, 'thread', dst.id, 'parent', moved.parent)
+		FROM moved, src, dst
+		ORDER BY moved.id
+	)
+	SELECT src.forum, dst.forum,
+		(SELECT count(*) FROM moved WHERE moved.deleted_at IS NULL),
+		ARRAY (SELECT DISTINCT moved.author::text FROM moved)
+	FROM src, dst`
+	var posts int64
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, from, into, parent, ChangePostMoved).Scan(&t.From, &t.To, &posts, &t.Authors)
+	t.Posts = int(posts)
+	return
+}
+
+// MoveSubtree moves a visible post and every reply under it to thread into.
+// The post becomes a root: it loses its parent and its ancestors are cut
+// from the front of every moved path. Each moved post gets a post.moved
+// change.
+func (ps *PostService) MoveSubtree(ctx context.Context, id int, into int) (t Transfer, err error) {
+	sqlQuery := `
+	WITH root AS (
+		SELECT p.id, p.path, p.thread, p.forum FROM post as p
+		JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+		WHERE p.id=$1 AND p.deleted_at IS NULL
+		FOR UPDATE OF p
+	), dst AS (
+		SELECT t.id, t.forum FROM thread as t WHERE t.id=$2 AND t.deleted_at IS NULL
+	), moved AS (
+		UPDATE post SET thread=dst.id, forum=dst.forum,
+			path=post.path[array_length(root.path, 1):],
+			parent=CASE WHEN post.id=root.id THEN 0 ELSE post.parent END
+		FROM root, dst
+		WHERE post.thread=root.thread AND post.path @> ARRAY[root.id::bigint]
+		RETURNING post.id, post.parent, post.author, post.deleted_at
+	), changes AS (
+		INSERT INTO outbox (kind, key, data)
+		SELECT $3::text, moved.id::text,
+			jsonb_build_object('from', root.forum, 'forum', dst.forum, 'thread', dst.id, 'parent', moved.parent)
+		FROM moved, root, dst
+		ORDER BY moved.id
+	)
+	SELECT root.forum, dst.forum,
+		(SELECT count(*) FROM moved WHERE moved.deleted_at IS NULL),
+		ARRAY (SELECT DISTINCT moved.author::text FROM moved)
+	FROM root, dst`
+	var posts int64
+	err = ps.db.QueryRowEx(ctx, sqlQuery, nil, id, into, ChangePostMoved).Scan(&t.From, &t.To, &posts, &t.Authors)
+	t.Posts = int(posts)
+	return
+}
+
+// SelectSubtree returns a visible post and the replies under it in tree
+// order, at most limit posts, and only depth levels down unless depth is 0.
+// Deleted replies come as tombstones.
+func (ps *PostService) SelectSubtree(ctx context.Context, id int, depth, limit int) (posts []Post, err error) {
+	sqlQuery := `
+	WITH root AS (
+		SELECT p.id, p.thread, p.path FROM post as p
+		JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+		WHERE p.id=$1 AND p.deleted_at IS NULL
+	)
+	SELECT p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path, p.deleted_at IS NOT NULL
+	FROM post as p, root
+	WHERE p.thread = root.thread AND p.path @> ARRAY[root.id::bigint]
+		AND ($2 = 0 OR array_length(p.path, 1) - array_length(root.path, 1) <= $2)
+	ORDER BY p.path
+	LIMIT $3`
+	return ps.selectPosts(ctx, sqlQuery, id, depth, limit)
+}
+
+// SelectAncestors returns the chain from a visible post's root down to the
+// post itself. Deleted ancestors come as tombstones.
+func (ps *PostService) SelectAncestors(ctx context.Context, id int) (posts []Post, err error) {
+	sqlQuery := `
+	WITH target AS (
+		SELECT p.thread, p.path FROM post as p
+		JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+		WHERE p.id=$1 AND p.deleted_at IS NULL
+	)
+	SELECT p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path, p.deleted_at IS NOT NULL
+	FROM post as p, target
+	WHERE p.thread = target.thread AND p.id = ANY (target.path)
+	ORDER BY array_length(p.path, 1)`
+	return ps.selectPosts(ctx, sqlQuery, id)
+}
+
+func (ps *PostService) selectPosts(ctx context.Context, sqlQuery string, args ...interface{}) (posts []Post, err error) {
+	rows, err := ps.db.QueryEx(ctx, sqlQuery, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Post{}
+		err := rows.Scan(&p.Id, &p.Parent, &p.Thread, &p.Forum, &p.Author, &p.Created, &p.Message, &p.IsEdited, pq.Array(&p.Path), &p.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		if p.Deleted {
+			p = Tombstone(p)
+		}
+		posts = append(posts, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNotFound
 	}
-	return old
+	return posts, nil
 }
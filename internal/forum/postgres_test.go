@@ -0,0 +1,58 @@
+package forum_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"tech-db/internal/forum"
+	"tech-db/internal/forum/forumtest"
+)
+
+var (
+	db         *pgx.ConnPool
+	skipReason string
+)
+
+func TestMain(m *testing.M) {
+	var stop func()
+	var err error
+	db, stop, err = forumtest.StartPostgres()
+	if err == forumtest.ErrNoPostgres {
+		skipReason = err.Error()
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+func TestPostgres(t *testing.T) {
+	if db == nil {
+		t.Skip(skipReason)
+	}
+	forumtest.Run(t, func(t *testing.T) forumtest.Backend {
+		b := forumtest.Backend{
+			Users:    forum.NewUserService(db),
+			Forums:   forum.NewForumService(db),
+			Threads:  forum.NewThreadService(db),
+			Posts:    forum.NewPostService(db),
+			Roles:    forum.NewRoleService(db),
+			Search:   forum.NewSearchService(db),
+			Webhooks: forum.NewWebhookService(db),
+			Outbox:   forum.NewOutboxService(db),
+			Tx:       forum.NewTxManager(db),
+		}
+		if err := b.Forums.Clean(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
@@ -0,0 +1,131 @@
+package forum
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned by lookups that match nothing. It is the pgx
+	// error so that callers can keep comparing against pgx.ErrNoRows.
+	ErrNotFound = pgx.ErrNoRows
+
+	ErrAuthorNotFound = errors.New("Can't find post author by nickname")
+	ErrParentConflict = errors.New("Parent post was created in another thread")
+	ErrThreadLocked   = errors.New("Thread is locked")
+	ErrThreadArchived = errors.New("Thread is archived")
+	ErrSameThread     = errors.New("Can't merge a thread into itself")
+)
+
+type UserRepository interface {
+	SelectUserByNickNameOrEmail(ctx context.Context, nickName, email string) ([]User, error)
+	SelectUserByNickName(ctx context.Context, nickName string) (User, error)
+	SelectUsersByForum(ctx context.Context, forumId int, page Page) ([]User, error)
+	InsertUser(ctx context.Context, user User) error
+	UpdateUser(ctx context.Context, user User) error
+	FindUserByNickName(ctx context.Context, nickName string) (User, error)
+	UpdatePassword(ctx context.Context, nickName string, hash string) error
+	SelectPasswordHash(ctx context.Context, nickName string) (string, error)
+}
+
+type ForumRepository interface {
+	SelectFullForumBySlug(ctx context.Context, slug string) (Forum, error)
+	SelectForumBySlug(ctx context.Context, slug string) (Forum, error)
+	InsertForum(ctx context.Context, forum Forum) error
+	Clean(ctx context.Context) error
+	SelectStatus(ctx context.Context) (Status, error)
+	UpdateThreadCount(ctx context.Context, forumId int) error
+	UpdatePostCount(ctx context.Context, forum string, count int) error
+	UpdateCounts(ctx context.Context, forum string, threads, posts int) error
+	InsertForumUser(ctx context.Context, forumId int, userId int) error
+	SyncForumUsers(ctx context.Context, forum string, nickNames []string) error
+}
+
+type ThreadRepository interface {
+	SelectThreadBySlug(ctx context.Context, threadSlug string) (Thread, error)
+	SelectThreadById(ctx context.Context, id int) (Thread, error)
+	InsertThread(ctx context.Context, thread Thread) (int, error)
+	SelectThreadByForum(ctx context.Context, forum string, page Page) ([]Thread, error)
+	FindThreadBySlug(ctx context.Context, slug string) (Thread, error)
+	FindThreadById(ctx context.Context, id int) (Thread, error)
+	InsertVote(ctx context.Context, vote Vote) error
+	SelectVote(ctx context.Context, vote Vote) (Vote, error)
+	UpdateVote(ctx context.Context, vote Vote) (int64, error)
+	UpdateThread(ctx context.Context, thread Thread) error
+	UpdateThreadFlags(ctx context.Context, thread Thread) error
+	SelectPosts(ctx context.Context, threadID int, sort string, page Page) ([]Post, error)
+	UpdateVoteCount(ctx context.Context, vote Vote) error
+	DeleteThread(ctx context.Context, id int, by string) (Removal, error)
+	PurgeThread(ctx context.Context, id int) (Removal, error)
+	MoveThread(ctx context.Context, id int, forum string) (Transfer, error)
+}
+
+type PostRepository interface {
+	SelectPostById(ctx context.Context, id int) (Post, error)
+	SelectPostsByIds(ctx context.Context, ids []int) ([]Post, error)
+	FindPostById(ctx context.Context, id int, thread int) error
+	InsertPost(ctx context.Context, post Post) (int, error)
+	UpdatePostMessage(ctx context.Context, newMessage string, id int, editor string) (int64, error)
+	SelectPostRevisions(ctx context.Context, id int) ([]PostRevision, error)
+	CreatePosts(ctx context.Context, thread Thread, forumId int, created string, posts []Post) ([]Post, error)
+	DeletePost(ctx context.Context, id int, by string) (Removal, error)
+	PurgePost(ctx context.Context, id int) (Removal, error)
+	MovePosts(ctx context.Context, from, into, parent int) (Transfer, error)
+	MoveSubtree(ctx context.Context, id int, into int) (Transfer, error)
+	SelectSubtree(ctx context.Context, id int, depth, limit int) ([]Post, error)
+	SelectAncestors(ctx context.Context, id int) ([]Post, error)
+}
+
+// RoleRepository keeps the privileges granted on top of authorship: the
+// global admin flag and per-forum moderators.
+type RoleRepository interface {
+	IsAdmin(ctx context.Context, nickName string) (bool, error)
+	SetAdmin(ctx context.Context, nickName string, admin bool) error
+	IsModerator(ctx context.Context, forum string, nickName string) (bool, error)
+	SelectModerators(ctx context.Context, forum string) ([]User, error)
+	InsertModerator(ctx context.Context, forum string, nickName string) error
+	DeleteModerator(ctx context.Context, forum string, nickName string) error
+}
+
+type SearchRepository interface {
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+}
+
+// WebhookRepository keeps webhook registrations and the queue of their
+// deliveries.
+type WebhookRepository interface {
+	InsertWebhook(ctx context.Context, hook Webhook) (Webhook, error)
+	SelectWebhooks(ctx context.Context, forum string) ([]Webhook, error)
+	SelectWebhook(ctx context.Context, id int) (Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	EnqueueDelivery(ctx context.Context, forum, event string, payload []byte) (int, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, d WebhookDelivery) error
+	SelectDeliveries(ctx context.Context, webhook int, limit int) ([]WebhookDelivery, error)
+}
+
+// OutboxRepository reads the changes recorded by the write paths.
+type OutboxRepository interface {
+	SelectChanges(ctx context.Context, after int64, limit int) ([]Change, error)
+}
+
+// Transactor runs a unit of work against repositories sharing one
+// transaction.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(tx Tx) error) error
+}
+
+var (
+	_ UserRepository    = (*UserService)(nil)
+	_ ForumRepository   = (*ForumService)(nil)
+	_ ThreadRepository  = (*ThreadService)(nil)
+	_ PostRepository    = (*PostService)(nil)
+	_ RoleRepository    = (*RoleService)(nil)
+	_ SearchRepository  = (*SearchService)(nil)
+	_ WebhookRepository = (*WebhookService)(nil)
+	_ OutboxRepository  = (*OutboxService)(nil)
+	_ Transactor        = (*TxManager)(nil)
+)
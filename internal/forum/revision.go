@@ -0,0 +1,20 @@
+package forum
+
+import (
+	"context"
+	"time"
+)
+
+// PostHistory lists the revisions of post, oldest first. A post that was
+// never edited has just its original text as revision 0.
+func PostHistory(ctx context.Context, posts PostRepository, post Post) ([]PostRevision, error) {
+	revisions, err := posts.SelectPostRevisions(ctx, post.Id)
+	if err != nil || len(revisions) > 0 {
+		return revisions, err
+	}
+	created, err := time.Parse(time.RFC3339Nano, post.Created)
+	if err != nil {
+		return nil, err
+	}
+	return []PostRevision{{Post: post.Id, Revision: 0, Message: post.Message, Editor: post.Author, Created: created}}, nil
+}
@@ -0,0 +1,107 @@
+package forum
+
+import (
+	"context"
+)
+
+type RoleService struct {
+	db Querier
+}
+
+func NewRoleService(db Querier) *RoleService {
+	return &RoleService{db: db}
+}
+
+// IsAdmin reports whether the user holds the global admin role. Unknown
+// users are not admins.
+func (rs *RoleService) IsAdmin(ctx context.Context, nickName string) (admin bool, err error) {
+	sqlQuery := `SELECT EXISTS (SELECT 1 FROM "user" WHERE nick_name=$1 AND is_admin)`
+	err = rs.db.QueryRowEx(ctx, sqlQuery, nil, nickName).Scan(&admin)
+	return
+}
+
+func (rs *RoleService) SetAdmin(ctx context.Context, nickName string, admin bool) error {
+	sqlQuery := `UPDATE "user" SET is_admin=$1 WHERE nick_name=$2`
+	result, err := rs.db.ExecEx(ctx, sqlQuery, nil, admin, nickName)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (rs *RoleService) IsModerator(ctx context.Context, forum string, nickName string) (moderator bool, err error) {
+	sqlQuery := `
+	SELECT EXISTS (
+		SELECT 1 FROM forum_moderator AS fm
+		JOIN forum AS f ON f.id=fm.forum_id
+		JOIN "user" AS u ON u.id=fm.user_id
+		WHERE f.slug=$1 AND u.nick_name=$2
+	)`
+	err = rs.db.QueryRowEx(ctx, sqlQuery, nil, forum, nickName).Scan(&moderator)
+	return
+}
+
+func (rs *RoleService) SelectModerators(ctx context.Context, forum string) (users []User, err error) {
+	sqlQuery := `
+	SELECT u.nick_name, u.email, u.full_name, u.about
+	FROM "user" AS u
+	JOIN forum_moderator AS fm ON fm.user_id=u.id
+	JOIN forum AS f ON f.id=fm.forum_id
+	WHERE f.slug=$1
+	ORDER BY u.nick_name COLLATE "C"`
+	rows, err := rs.db.QueryEx(ctx, sqlQuery, nil, forum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := User{}
+		if err = rows.Scan(&user.NickName, &user.Email, &user.FullName, &user.About); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// InsertModerator makes the user a moderator of the forum. Doing it twice
+// is not an error; an unknown forum or user is ErrNotFound.
+func (rs *RoleService) InsertModerator(ctx context.Context, forum string, nickName string) error {
+	sqlQuery := `
+	WITH ids AS (
+		SELECT f.id AS forum_id, u.id AS user_id FROM forum AS f, "user" AS u
+		WHERE f.slug=$1 AND u.nick_name=$2
+	), inserted AS (
+		INSERT INTO forum_moderator (forum_id, user_id)
+		SELECT forum_id, user_id FROM ids
+		ON CONFLICT DO NOTHING
+	)
+	SELECT count(*) FROM ids`
+	var found int
+	if err := rs.db.QueryRowEx(ctx, sqlQuery, nil, forum, nickName).Scan(&found); err != nil {
+		return err
+	}
+	if found == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (rs *RoleService) DeleteModerator(ctx context.Context, forum string, nickName string) error {
+	sqlQuery := `
+	DELETE FROM forum_moderator AS fm
+	USING forum AS f, "user" AS u
+	WHERE fm.forum_id=f.id AND fm.user_id=u.id AND f.slug=$1 AND u.nick_name=$2`
+	result, err := rs.db.ExecEx(ctx, sqlQuery, nil, forum, nickName)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package forum
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// SearchQuery selects the posts and threads matching Text. Zero values of
+// the other fields do not filter.
+type SearchQuery struct {
+	Text   string
+	Forum  string
+	Author string
+	Since  time.Time
+	Limit  int
+	After  *SearchCursor
+}
+
+// SearchResult is a post or a thread matching a search, with a snippet of
+// its text, escaped for HTML, where the matched words are wrapped in
+// <b></b>.
+type SearchResult struct {
+	Kind    string    `json:"type"`
+	Id      int       `json:"id"`
+	Thread  int       `json:"thread"`
+	Forum   string    `json:"forum"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+	Title   string    `json:"title,omitempty"`
+	Snippet string    `json:"snippet"`
+	Rank    float32   `json:"rank"`
+}
+
+// SearchCursor is the position of a result in the order searches return
+// them: by rank, best first, then by kind and id.
+type SearchCursor struct {
+	Rank float32 `json:"r"`
+	Kind string  `json:"k"`
+	Id   int     `json:"i"`
+}
+
+func (r SearchResult) Cursor() SearchCursor {
+	return SearchCursor{Rank: r.Rank, Kind: r.Kind, Id: r.Id}
+}
+
+func (c SearchCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseSearchCursor(s string) (c SearchCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil || (c.Kind != "post" && c.Kind != "thread") {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
+
+// Precedes reports whether c sorts before r, that is whether r belongs on
+// the page after c.
+func (c SearchCursor) Precedes(r SearchResult) bool {
+	if r.Rank != c.Rank {
+		return r.Rank < c.Rank
+	}
+	if r.Kind != c.Kind {
+		return r.Kind > c.Kind
+	}
+	return r.Id > c.Id
+}
+
+// escapeHTML wraps the SQL text expression expr so that it escapes the
+// characters html.EscapeString does, the same way. ts_headline reads the
+// entities as words of their own and adds the <b></b> after, so snippets
+// carry no other markup.
+func escapeHTML(expr string) string {
+	return `replace(replace(replace(replace(replace(` + expr + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+type SearchService struct {
+	db Querier
+}
+
+func NewSearchService(db Querier) *SearchService {
+	return &SearchService{db: db}
+}
+
+// Search runs query against the english full-text index of visible posts
+// and threads.
+func (ss *SearchService) Search(ctx context.Context, query SearchQuery) (results []SearchResult, err error) {
+	sqlQuery := `
+	WITH q AS (
+		SELECT plainto_tsquery('english', $1) AS query
+	), hits AS (
+		SELECT 'post'::text AS kind, p.id, p.thread, p.forum, p.author, p.created::timestamptz AS created,
+			   ''::text AS title, p.message AS body, ts_rank(p.search, q.query) AS rank
+		FROM post as p JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL, q
+		WHERE p.search @@ q.query AND p.deleted_at IS NULL
+			AND ($2 = '' OR p.forum = $2::citext)
+			AND ($3 = '' OR p.author = $3::citext)
+			AND ($4::timestamptz IS NULL OR p.created::timestamptz >= $4::timestamptz)
+		UNION ALL
+		SELECT 'thread'::text, t.id, t.id, t.forum, t.author, t.created,
+			   t.title, t.message, ts_rank(t.search, q.query)
+		FROM thread as t, q
+		WHERE t.search @@ q.query AND t.deleted_at IS NULL
+			AND ($2 = '' OR t.forum = $2::citext)
+			AND ($3 = '' OR t.author = $3::citext)
+			AND ($4::timestamptz IS NULL OR t.created >= $4::timestamptz)
+	), page AS (
+		SELECT * FROM hits
+		WHERE $5::real IS NULL OR hits.rank < $5::real
+			OR (hits.rank = $5::real AND (hits.kind, hits.id) > ($6::text, $7::integer))
+		ORDER BY hits.rank DESC, hits.kind, hits.id
+		LIMIT $8
+	)
+	SELECT page.kind, page.id, page.thread, page.forum, page.author, page.created, page.title,
+		   ts_headline('english', ` + escapeHTML(`CASE WHEN page.kind = 'thread' THEN page.title || E'\n' || page.body ELSE page.body END`) + `, q.query),
+		   page.rank
+	FROM page, q
+	ORDER BY page.rank DESC, page.kind, page.id`
+
+	var since, afterRank interface{}
+	var afterKind string
+	var afterId int
+	if !query.Since.IsZero() {
+		since = query.Since
+	}
+	if query.After != nil {
+		afterRank, afterKind, afterId = query.After.Rank, query.After.Kind, query.After.Id
+	}
+
+	rows, err := ss.db.QueryEx(ctx, sqlQuery, nil, query.Text, query.Forum, query.Author, since, afterRank, afterKind, afterId, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := SearchResult{}
+		err := rows.Scan(&r.Kind, &r.Id, &r.Thread, &r.Forum, &r.Author, &r.Created, &r.Title, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
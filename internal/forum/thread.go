@@ -1,25 +1,27 @@
 package forum
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"github.com/jackc/pgx"
 	"github.com/lib/pq"
+	"strconv"
+	"tech-db/internal/sqlb"
 )
 
 type ThreadService struct {
-	db *pgx.ConnPool
+	db Querier
 }
 
-func NewThreadService(db *pgx.ConnPool) *ThreadService {
+func NewThreadService(db Querier) *ThreadService {
 	return &ThreadService{db: db}
 }
 
-func (ts *ThreadService) SelectThreadBySlug(threadSlug string) (thread Thread, err error) {
-	sqlQuery := `SELECT t.id, t.author, t.created, t.forum, t.message, t.slug, t.title, t.votes
-	FROM thread as t where t.slug=$1`
+func (ts *ThreadService) SelectThreadBySlug(ctx context.Context, threadSlug string) (thread Thread, err error) {
+	sqlQuery := `SELECT t.id, t.author, t.created, t.forum, t.message, t.slug, t.title, t.votes, t.locked, t.pinned, t.archived
+	FROM thread as t where t.slug=$1 AND t.deleted_at IS NULL`
 	var slug sql.NullString
-	err = ts.db.QueryRow(sqlQuery, threadSlug).Scan(&thread.Id, &thread.Author, &thread.Created, &thread.Forum, &thread.Message, &slug, &thread.Title, &thread.Votes)
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, threadSlug).Scan(&thread.Id, &thread.Author, &thread.Created, &thread.Forum, &thread.Message, &slug, &thread.Title, &thread.Votes, &thread.Locked, &thread.Pinned, &thread.Archived)
 	if err != nil {
 		return
 	}
@@ -29,64 +31,113 @@ func (ts *ThreadService) SelectThreadBySlug(threadSlug string) (thread Thread, e
 	return
 }
 
-func (ts *ThreadService) SelectThreadById(id int) (thread Thread, err error) {
-	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title, t.votes
-	FROM thread as t where t.id=$1`
-	err = ts.db.QueryRow(sqlQuery, id).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title, &thread.Votes)
+func (ts *ThreadService) SelectThreadById(ctx context.Context, id int) (thread Thread, err error) {
+	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title, t.votes, t.locked, t.pinned, t.archived
+	FROM thread as t where t.id=$1 AND t.deleted_at IS NULL`
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title, &thread.Votes, &thread.Locked, &thread.Pinned, &thread.Archived)
 	if err != nil {
 		return
 	}
 	return
 }
 
-func (ts *ThreadService) InsertThread(thread Thread) (id int, err error) {
+func (ts *ThreadService) InsertThread(ctx context.Context, thread Thread) (id int, err error) {
 	sqlQuery := `INSERT INTO thread (author, created, message, title, forum, slug) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`
-	err = ts.db.QueryRow(sqlQuery, thread.Author, thread.Created, thread.Message, thread.Title, thread.Forum, thread.Slug).Scan(&id)
-	return
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, thread.Author, thread.Created, thread.Message, thread.Title, thread.Forum, thread.Slug).Scan(&id)
+	if err != nil {
+		return
+	}
+	thread.Id = id
+	return id, appendChange(ctx, ts.db, ChangeThreadInserted, strconv.Itoa(id), thread)
 }
 
-func (ts *ThreadService) SelectThreadByForum(forum string, limit int, since string, desc bool) (threads []Thread, err error) {
-	var rows *pgx.Rows
-	if since == "" && !desc {
-		sqlQuery := `
-		SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes
-		FROM thread as t 
-		WHERE t.forum = $1
-		ORDER BY t.created 
-		LIMIT $2`
-		rows, err = ts.db.Query(sqlQuery, forum, limit)
-	} else if since != "" && !desc {
-		sqlQuery := `
-		SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes
-		FROM thread as t 
-		WHERE t.forum = $1 AND t.created >= $3
-		ORDER BY t.created 
-		LIMIT $2`
-		rows, err = ts.db.Query(sqlQuery, forum, limit, since)
-	} else if since == "" && desc {
-		sqlQuery := `
-		SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes
-		FROM thread as t 
-		WHERE t.forum = $1
-		ORDER BY t.created DESC 
-		LIMIT $2`
-		rows, err = ts.db.Query(sqlQuery, forum, limit)
-	} else {
-		sqlQuery := `
-		SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes
-		FROM thread as t 
-		WHERE t.forum = $1 AND t.created <= $3
-		ORDER BY t.created DESC 
-		LIMIT $2`
-		rows, err = ts.db.Query(sqlQuery, forum, limit, since)
+// SelectThreadByForum lists a forum's threads by creation time and id.
+// Pinned threads come first whatever the order and since, so each query
+// takes them and the rest of the page separately and merges the two. Since
+// is a creation time; the threads created at it are included.
+func (ts *ThreadService) SelectThreadByForum(ctx context.Context, forum string, page Page) (threads []Thread, err error) {
+	if err := page.Check(); err != nil {
+		return nil, err
 	}
+	if page.After != nil {
+		return ts.selectThreadsAfter(ctx, forum, page)
+	}
+	desc := page.Desc
+	q := sqlb.New(`
+	SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes, t.locked, t.pinned, t.archived
+	FROM (
+		(SELECT * FROM thread as t
+		WHERE t.forum = `)
+	forumArg, limit := q.Bind(forum), q.Bind(page.Limit)
+	q.Param(forumArg).SQL(` AND t.deleted_at IS NULL AND t.pinned
+		ORDER BY t.created `).Order(desc).SQL(`, t.id `).Order(desc).SQL(`
+		LIMIT `).Param(limit).SQL(`)
+		UNION ALL
+		(SELECT * FROM thread as t
+		WHERE t.forum = `).Param(forumArg).SQL(` AND t.deleted_at IS NULL AND NOT t.pinned`)
+	if page.Since != "" {
+		since, err := page.SinceTime()
+		if err != nil {
+			return nil, err
+		}
+		// >= or <=: the threads created at since are included
+		q.SQL(` AND t.created `).After(desc).SQL(`= `).Arg(since)
+	}
+	q.SQL(`
+		ORDER BY t.created `).Order(desc).SQL(`, t.id `).Order(desc).SQL(`
+		LIMIT `).Param(limit).SQL(`)
+	) as t
+	ORDER BY t.pinned DESC, t.created `).Order(desc).SQL(`, t.id `).Order(desc).SQL(`
+	LIMIT `).Param(limit)
+
+	sqlQuery, args := q.Build()
+	rows, err := ts.db.QueryEx(ctx, sqlQuery, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanThreads(rows)
+}
 
+// selectThreadsAfter lists the threads on one side of page.After. Pinned
+// threads sort before the others whatever the order, so the scan compares
+// NOT pinned separately from the creation time and id. A Back page is
+// scanned in reverse and put back in order by the outer query.
+func (ts *ThreadService) selectThreadsAfter(ctx context.Context, forum string, page Page) ([]Thread, error) {
+	created, err := page.After.Time()
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	back := page.After.Back
+	scanDesc := page.Desc != back
+	q := sqlb.New(`
+	SELECT t.author, t.created, t.forum, t.id, t.message, t.slug, t.title, t.votes, t.locked, t.pinned, t.archived
+	FROM (
+		SELECT * FROM thread as t
+		WHERE t.forum = `).Arg(forum).SQL(` AND t.deleted_at IS NULL
+			AND ((NOT t.pinned) `).After(back).SQL(` `)
+	notPinned := q.Bind(!page.After.Pinned)
+	q.Param(notPinned).SQL(` OR ((NOT t.pinned) = `).Param(notPinned).
+		SQL(` AND (t.created, t.id) `).After(scanDesc).SQL(` (`).Arg(created).SQL(`::timestamptz, `).Arg(page.After.Id).SQL(`::integer)))
+		ORDER BY (NOT t.pinned) `).Order(back).SQL(`, t.created `).Order(scanDesc).SQL(`, t.id `).Order(scanDesc).SQL(`
+		LIMIT `).Arg(page.Limit).SQL(`
+	) as t
+	ORDER BY (NOT t.pinned), t.created `).Order(page.Desc).SQL(`, t.id `).Order(page.Desc)
+
+	sqlQuery, args := q.Build()
+	rows, err := ts.db.QueryEx(ctx, sqlQuery, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanThreads(rows)
+}
+
+func scanThreads(rows *pgx.Rows) (threads []Thread, err error) {
 	defer rows.Close()
 
 	for rows.Next() {
 		threadScan := Thread{}
 		slug := sql.NullString{}
-		err := rows.Scan(&threadScan.Author, &threadScan.Created, &threadScan.Forum ,&threadScan.Id, &threadScan.Message, &slug, &threadScan.Title, &threadScan.Votes)
+		err := rows.Scan(&threadScan.Author, &threadScan.Created, &threadScan.Forum, &threadScan.Id, &threadScan.Message, &slug, &threadScan.Title, &threadScan.Votes, &threadScan.Locked, &threadScan.Pinned, &threadScan.Archived)
 		if err != nil {
 			return threads, err
 		}
@@ -96,113 +147,266 @@ func (ts *ThreadService) SelectThreadByForum(forum string, limit int, since stri
 
 		threads = append(threads, threadScan)
 	}
-	return
+	return threads, rows.Err()
 }
 
-func (ts *ThreadService) FindThreadBySlug(slug string) (thread Thread, err error) {
-	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title FROM thread as t where t.slug=$1`
-	err = ts.db.QueryRow(sqlQuery, slug).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title)
+func (ts *ThreadService) FindThreadBySlug(ctx context.Context, slug string) (thread Thread, err error) {
+	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title, t.locked, t.pinned, t.archived FROM thread as t where t.slug=$1 AND t.deleted_at IS NULL`
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, slug).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title, &thread.Locked, &thread.Pinned, &thread.Archived)
 	return
 }
 
-func (ts *ThreadService) FindThreadById(id int) (thread Thread, err error) {
-	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title FROM thread as t where t.id=$1`
-	err = ts.db.QueryRow(sqlQuery, id).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title)
+func (ts *ThreadService) FindThreadById(ctx context.Context, id int) (thread Thread, err error) {
+	sqlQuery := `SELECT t.author, t.created, t.id, t.forum, t.message, t.slug, t.title, t.locked, t.pinned, t.archived FROM thread as t where t.id=$1 AND t.deleted_at IS NULL`
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&thread.Author, &thread.Created, &thread.Id, &thread.Forum, &thread.Message, &thread.Slug, &thread.Title, &thread.Locked, &thread.Pinned, &thread.Archived)
 	return
 }
 
-func (ts *ThreadService) InsertVote(vote Vote) (err error) {
+func (ts *ThreadService) InsertVote(ctx context.Context, vote Vote) (err error) {
 	sqlQuery := `INSERT INTO vote (user_id, voice, thread_id) VALUES ($1,$2,$3)`
-	_, err = ts.db.Exec(sqlQuery, vote.UserId, vote.Voice, vote.ThreadId)
-	return
+	if _, err = ts.db.ExecEx(ctx, sqlQuery, nil, vote.UserId, vote.Voice, vote.ThreadId); err != nil {
+		return
+	}
+	return appendChange(ctx, ts.db, ChangeVoteInserted, VoteKey(vote), VoteChange{Thread: vote.ThreadId, User: vote.UserId, Voice: vote.Voice})
 }
 
-func (ts *ThreadService) SelectVote(vote Vote) (findVote Vote, err error) {
+func (ts *ThreadService) SelectVote(ctx context.Context, vote Vote) (findVote Vote, err error) {
 	sqlQuery := `
 	SELECT v.user_id, v.voice, v.thread_id 
 	FROM vote as v
-	where v.user_id=$2 AND v.thread_id=$1`
-	err = ts.db.QueryRow(sqlQuery, vote.ThreadId, vote.UserId).Scan(&findVote.UserId, &findVote.Voice, &findVote.ThreadId)
+	where v.user_id=$2 AND v.thread_id=$1
+	FOR UPDATE`
+	err = ts.db.QueryRowEx(ctx, sqlQuery, nil, vote.ThreadId, vote.UserId).Scan(&findVote.UserId, &findVote.Voice, &findVote.ThreadId)
 	return
 }
 
-func (ts *ThreadService) UpdateVote(vote Vote) (countUpdatedRows int64, err error) {
+func (ts *ThreadService) UpdateVote(ctx context.Context, vote Vote) (countUpdatedRows int64, err error) {
 	sqlQuery := `
 	UPDATE vote SET voice = $1
 	where vote.user_id=$2 AND vote.thread_id=$3`
-	result, err := ts.db.Exec(sqlQuery, vote.Voice, vote.UserId, vote.ThreadId)
+	result, err := ts.db.ExecEx(ctx, sqlQuery, nil, vote.Voice, vote.UserId, vote.ThreadId)
 	if err != nil {
 		return
 	}
 	countUpdatedRows = result.RowsAffected()
+	if countUpdatedRows == 0 {
+		return
+	}
+	err = appendChange(ctx, ts.db, ChangeVoteUpdated, VoteKey(vote), VoteChange{Thread: vote.ThreadId, User: vote.UserId, Voice: vote.Voice})
 	return
 }
 
-func (ts *ThreadService) UpdateThread(thread Thread) (err error) {
+func (ts *ThreadService) UpdateThread(ctx context.Context, thread Thread) (err error) {
 	sqlQuery := `
 	UPDATE thread SET message=$1, title=$2 where thread.id=$3`
-	_, err = ts.db.Exec(sqlQuery, thread.Message, thread.Title, thread.Id)
-	return
+	result, err := ts.db.ExecEx(ctx, sqlQuery, nil, thread.Message, thread.Title, thread.Id)
+	if err != nil || result.RowsAffected() == 0 {
+		return
+	}
+	return appendChange(ctx, ts.db, ChangeThreadUpdated, strconv.Itoa(thread.Id), thread)
 }
 
-func (ts *ThreadService) SelectPosts(threadID int, limit, since, sort, desc string) (Posts []Post, Err error) {
-	var sqlQuery string
+// UpdateThreadFlags stores the thread's locked, pinned and archived flags.
+func (ts *ThreadService) UpdateThreadFlags(ctx context.Context, thread Thread) error {
+	sqlQuery := `
+	UPDATE thread SET locked=$1, pinned=$2, archived=$3 where thread.id=$4 AND thread.deleted_at IS NULL`
+	result, err := ts.db.ExecEx(ctx, sqlQuery, nil, thread.Locked, thread.Pinned, thread.Archived, thread.Id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	flags := ThreadFlagsChange{Locked: thread.Locked, Pinned: thread.Pinned, Archived: thread.Archived}
+	return appendChange(ctx, ts.db, ChangeThreadFlagged, strconv.Itoa(thread.Id), flags)
+}
 
-	conditionSign := ">"
-	if desc == "desc" {
-		conditionSign = "<"
+// SelectPosts lists a thread's posts sorted flat, by creation time and id,
+// as a tree, by path, or as parent_tree, a page of root posts each followed
+// by its whole tree. Since is the id of a post the page starts after; for
+// parent_tree it stands for its root. Deleted posts are left out of flat
+// listings and come as tombstones in the others. A Back page is scanned in
+// reverse and put back in order by the outer query.
+func (ts *ThreadService) SelectPosts(ctx context.Context, threadID int, sort string, page Page) (Posts []Post, Err error) {
+	if err := page.Check(); err != nil {
+		return nil, err
+	}
+	var since, afterKey, afterPath, afterId interface{}
+	back := false
+	if page.After != nil {
+		afterId, back = page.After.Id, page.After.Back
+		if page.After.Key != "" {
+			afterKey = page.After.Key
+		}
+		if page.After.Path != nil {
+			afterPath = page.After.Path
+		}
+	} else if page.Since != "" {
+		id, err := page.SinceID()
+		if err != nil {
+			return nil, err
+		}
+		since = id
 	}
+	scanDesc := page.Desc != back
 
-	if sort == "flat" {
-		sqlQuery = "SELECT p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path FROM post as p WHERE thread=$1 "
-		if since != "" {
-			sqlQuery += fmt.Sprintf(" AND id %s %s ", conditionSign, since)
+	const columns = "p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path, p.deleted_at IS NOT NULL"
+	var q *sqlb.Query
+	switch sort {
+	case "flat":
+		q = sqlb.New(`
+		SELECT ` + columns + ` FROM (
+			SELECT p.* FROM post as p JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+			WHERE p.thread = `).Arg(threadID).SQL(` AND p.deleted_at IS NULL`)
+		if since != nil {
+			q.SQL(` AND p.id `).After(scanDesc).SQL(` `).Arg(since)
+		}
+		if afterKey != nil {
+			q.SQL(` AND (p.created, p.id) `).After(scanDesc).SQL(` (`).Arg(afterKey).SQL(`::text, `).Arg(afterId).SQL(`::integer)`)
 		}
-		sqlQuery += fmt.Sprintf(" ORDER BY p.created %s, p.id %s LIMIT %s", desc, desc, limit)
-	} else if sort == "tree" {
-		orderString := fmt.Sprintf(" ORDER BY p.path[1] %s, p.path %s ", desc, desc)
-		sqlQuery = "SELECT p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path " +
-			"FROM post as p " +
-			"WHERE p.thread=$1 "
-		if since != "" {
-			sqlQuery += fmt.Sprintf(" AND p.path %s (SELECT p.path FROM post as p WHERE p.id = %s) ", conditionSign, since)
+		q.SQL(`
+			ORDER BY p.created `).Order(scanDesc).SQL(`, p.id `).Order(scanDesc).SQL(`
+			LIMIT `).Arg(page.Limit).SQL(`
+		) as p
+		ORDER BY p.created `).Order(page.Desc).SQL(`, p.id `).Order(page.Desc)
+	case "tree":
+		q = sqlb.New(`
+		SELECT ` + columns + ` FROM (
+			SELECT p.* FROM post as p JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+			WHERE p.thread = `).Arg(threadID)
+		if since != nil {
+			q.SQL(` AND p.path `).After(scanDesc).SQL(` (SELECT s.path FROM post as s WHERE s.id = `).Arg(since).SQL(`)`)
 		}
-		sqlQuery += orderString
-		sqlQuery += fmt.Sprintf("LIMIT %s", limit)
-
-	} else if sort == "parent_tree" {
-		sqlQuery = "SELECT p.id, p.parent, p.thread, p.forum, p.author, p.created, p.message, p.is_edited, p.path " +
-			"FROM post as p " +
-			"WHERE p.thread=$1 AND p.path::integer[] && (SELECT ARRAY (select p.id from post as p WHERE p.thread=$1 AND p.parent=0 "
-		if since != "" {
-			sqlQuery += fmt.Sprintf(" AND p.path %s (SELECT p.path[1:1] FROM post as p WHERE p.id = %s) ", conditionSign, since)
+		if afterPath != nil {
+			q.SQL(` AND p.path `).After(scanDesc).SQL(` `).Arg(afterPath).SQL(`::bigint[]`)
 		}
-		sqlQuery += fmt.Sprintf("ORDER BY p.path[1] %s, p.path LIMIT %s)) ", desc, limit)
-		sqlQuery += fmt.Sprintf("ORDER BY p.path[1] %s, p.path ", desc)
+		q.SQL(`
+			ORDER BY p.path `).Order(scanDesc).SQL(`
+			LIMIT `).Arg(page.Limit).SQL(`
+		) as p
+		ORDER BY p.path `).Order(page.Desc)
+	case "parent_tree":
+		q = sqlb.New(`
+		SELECT ` + columns + `
+		FROM post as p JOIN thread as t ON t.id = p.thread AND t.deleted_at IS NULL
+		WHERE p.thread = `)
+		thread := q.Bind(threadID)
+		q.Param(thread).SQL(` AND p.path && ARRAY (
+			SELECT r.id::bigint FROM post as r
+			WHERE r.thread = `).Param(thread).SQL(` AND r.parent = 0`)
+		if since != nil {
+			q.SQL(` AND r.id `).After(scanDesc).SQL(` (SELECT s.path[1] FROM post as s WHERE s.id = `).Arg(since).SQL(`)`)
+		}
+		if afterId != nil {
+			q.SQL(` AND r.id `).After(scanDesc).SQL(` `).Arg(afterId).SQL(`::integer`)
+		}
+		q.SQL(`
+			ORDER BY r.id `).Order(scanDesc).SQL(`
+			LIMIT `).Arg(page.Limit).SQL(`)
+		ORDER BY p.path[1] `).Order(page.Desc).SQL(`, p.path`)
+	default:
+		return nil, ErrBadSort
 	}
 
-	rows, err := ts.db.Query(sqlQuery, threadID)
+	sqlQuery, args := q.Build()
+	rows, err := ts.db.QueryEx(ctx, sqlQuery, nil, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		p := Post{}
-		err := rows.Scan(&p.Id, &p.Parent, &p.Thread, &p.Forum, &p.Author, &p.Created, &p.Message, &p.IsEdited, pq.Array(&p.Path))
+		err := rows.Scan(&p.Id, &p.Parent, &p.Thread, &p.Forum, &p.Author, &p.Created, &p.Message, &p.IsEdited, pq.Array(&p.Path), &p.Deleted)
 		if err != nil {
 			return nil, err
 		}
+		if p.Deleted {
+			p = Tombstone(p)
+		}
 
 		Posts = append(Posts, p)
 	}
 
+	return Posts, rows.Err()
+}
+
+// DeleteThread marks a visible thread deleted. Its posts are left as they
+// are but are no longer reachable through it.
+func (ts *ThreadService) DeleteThread(ctx context.Context, id int, by string) (removal Removal, err error) {
+	sqlQuery := `
+	UPDATE thread SET deleted_at=now(), deleted_by=NULLIF($2, '')
+	WHERE thread.id=$1 AND thread.deleted_at IS NULL
+	RETURNING thread.forum, (SELECT count(*) FROM post as p WHERE p.thread=$1 AND p.deleted_at IS NULL)`
+	if err = ts.db.QueryRowEx(ctx, sqlQuery, nil, id, by).Scan(&removal.Forum, &removal.Posts); err != nil {
+		return
+	}
+	removal.Threads = 1
+	err = appendChange(ctx, ts.db, ChangeThreadDeleted, strconv.Itoa(id), RemovalChange{Forum: removal.Forum, By: by})
+	return
+}
+
+// PurgeThread deletes a thread, its posts and its votes, whether or not it
+// was soft-deleted before.
+func (ts *ThreadService) PurgeThread(ctx context.Context, id int) (removal Removal, err error) {
+	sqlQuery := `
+	WITH target AS (
+		SELECT t.id, t.forum, t.deleted_at IS NULL AS live FROM thread as t WHERE t.id=$1 FOR UPDATE
+	), votes AS (
+		DELETE FROM vote USING target WHERE vote.thread_id = target.id
+	), posts AS (
+		DELETE FROM post USING target WHERE post.thread = target.id RETURNING post.deleted_at
+	), threads AS (
+		DELETE FROM thread USING target WHERE thread.id = target.id
+	)
+	SELECT target.forum,
+		   CASE WHEN target.live THEN 1 ELSE 0 END,
+		   CASE WHEN target.live THEN (SELECT count(*) FROM posts WHERE posts.deleted_at IS NULL) ELSE 0 END
+	FROM target`
+	if err = ts.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&removal.Forum, &removal.Threads, &removal.Posts); err != nil {
+		return
+	}
+	err = appendChange(ctx, ts.db, ChangeThreadPurged, strconv.Itoa(id), RemovalChange{Forum: removal.Forum})
 	return
 }
 
-func (ts *ThreadService) UpdateVoteCount(vote Vote) (err error) {
+func (ts *ThreadService) UpdateVoteCount(ctx context.Context, vote Vote) (err error) {
 	sqlQuery := `
 	UPDATE thread SET votes=votes+$1
 	where thread.id=$2`
-	_, err = ts.db.Exec(sqlQuery, vote.Voice, vote.ThreadId)
+	_, err = ts.db.ExecEx(ctx, sqlQuery, nil, vote.Voice, vote.ThreadId)
+	return
+}
+
+// MoveThread moves a visible thread and all its posts to forum. Both the
+// thread and the forum must exist, or it returns ErrNotFound. Each post
+// gets a post.moved change and then the thread a thread.moved one.
+func (ts *ThreadService) MoveThread(ctx context.Context, id int, forum string) (t Transfer, err error) {
+	sqlQuery := `
+	WITH target AS (
+		SELECT f.slug FROM forum as f WHERE f.slug=$2
+	), old AS (
+		SELECT t.id, t.forum, t.author FROM thread as t WHERE t.id=$1 AND t.deleted_at IS NULL FOR UPDATE
+	), moved AS (
+		UPDATE thread SET forum=target.slug FROM target, old WHERE thread.id=old.id RETURNING thread.id
+	), posts AS (
+		UPDATE post SET forum=target.slug FROM target, moved WHERE post.thread=moved.id
+		RETURNING post.id, post.thread, post.parent, post.author, post.deleted_at
+	), changes AS (
+		INSERT INTO outbox (kind, key, data)
+		SELECT $3::text, posts.id::text,
+			jsonb_build_object('from', old.forum, 'forum', target.slug, 'thread', posts.thread, 'parent', posts.parent)
+		FROM posts, old, target
+		ORDER BY posts.id
+	)
+	SELECT old.forum, target.slug,
+		(SELECT count(*) FROM posts WHERE posts.deleted_at IS NULL),
+		ARRAY (SELECT posts.author::text FROM posts UNION SELECT old.author::text)
+	FROM old, target`
+	var posts int64
+	if err = ts.db.QueryRowEx(ctx, sqlQuery, nil, id, forum, ChangePostMoved).Scan(&t.From, &t.To, &posts, &t.Authors); err != nil {
+		return
+	}
+	t.Threads, t.Posts = 1, int(posts)
+	err = appendChange(ctx, ts.db, ChangeThreadMoved, strconv.Itoa(id), ThreadMoveChange{From: t.From, Forum: t.To})
 	return
 }
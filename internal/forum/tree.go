@@ -0,0 +1,28 @@
+package forum
+
+// PostNode is a post with its replies nested under it.
+type PostNode struct {
+	Post
+	Replies []*PostNode `json:"replies"`
+}
+
+// Nest arranges posts into trees. They may come in any order, such as the
+// descending ones of SelectPosts, and replies keep the order they had
+// among themselves, as do the roots. Posts whose parent is not among them
+// become roots.
+func Nest(posts []Post) []*PostNode {
+	nodes := make(map[int]*PostNode, len(posts))
+	for _, p := range posts {
+		nodes[p.Id] = &PostNode{Post: p, Replies: []*PostNode{}}
+	}
+	roots := []*PostNode{}
+	for _, p := range posts {
+		node := nodes[p.Id]
+		if parent, ok := nodes[p.Parent]; ok && p.Parent != 0 {
+			parent.Replies = append(parent.Replies, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
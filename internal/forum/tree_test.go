@@ -0,0 +1,53 @@
+package forum
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNest(t *testing.T) {
+	posts := []Post{
+		{Id: 1, Path: []int64{1}},
+		{Id: 2, Parent: 1, Path: []int64{1, 2}},
+		{Id: 4, Parent: 2, Path: []int64{1, 2, 4}},
+		{Id: 3, Parent: 1, Path: []int64{1, 3}},
+		{Id: 6, Parent: 5, Path: []int64{5, 6}},
+	}
+	roots := Nest(posts)
+	if len(roots) != 2 || roots[0].Id != 1 || roots[1].Id != 6 {
+		t.Fatalf("roots = %+v", roots)
+	}
+	b, err := json.Marshal(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shape struct {
+		Id      int
+		Replies []struct {
+			Id      int
+			Replies []struct{ Id int }
+		}
+	}
+	if err := json.Unmarshal(b, &shape); err != nil {
+		t.Fatal(err)
+	}
+	if len(shape.Replies) != 2 || shape.Replies[0].Id != 2 || shape.Replies[1].Id != 3 ||
+		len(shape.Replies[0].Replies) != 1 || shape.Replies[0].Replies[0].Id != 4 {
+		t.Errorf("nested = %s", b)
+	}
+
+	reversed := make([]Post, len(posts))
+	for i, p := range posts {
+		reversed[len(posts)-1-i] = p
+	}
+	roots = Nest(reversed)
+	if len(roots) != 2 || roots[0].Id != 6 || roots[1].Id != 1 {
+		t.Fatalf("roots of descending posts = %+v", roots)
+	}
+	if r := roots[1].Replies; len(r) != 2 || r[0].Id != 3 || r[1].Id != 2 || len(r[1].Replies) != 1 {
+		t.Errorf("replies of descending posts = %+v", r)
+	}
+	if len(Nest(nil)) != 0 {
+		t.Error("Nest(nil) is not empty")
+	}
+}
@@ -0,0 +1,71 @@
+package forum
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Querier is the part of the pgx API the services use. Both *pgx.ConnPool
+// and *pgx.Tx implement it, so a service can run on the pool or inside a
+// transaction.
+type Querier interface {
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
+}
+
+// Tx holds repositories bound to a single transaction.
+type Tx struct {
+	Users    UserRepository
+	Forums   ForumRepository
+	Threads  ThreadRepository
+	Posts    PostRepository
+	Webhooks WebhookRepository
+}
+
+func newTx(db Querier) Tx {
+	return Tx{
+		Users:    NewUserService(db),
+		Forums:   NewForumService(db),
+		Threads:  NewThreadService(db),
+		Posts:    NewPostService(db),
+		Webhooks: NewWebhookService(db),
+	}
+}
+
+type TxManager struct {
+	db *pgx.ConnPool
+	// Observe, when set, is called for every statement run in a transaction.
+	Observe QueryObserver
+}
+
+func NewTxManager(db *pgx.ConnPool) *TxManager {
+	return &TxManager{db: db}
+}
+
+// InTx runs fn in a transaction which is committed when fn returns nil and
+// rolled back otherwise.
+func (tm *TxManager) InTx(ctx context.Context, fn func(tx Tx) error) (err error) {
+	started, waited := time.Now(), poolExhausted(tm.db)
+	tx, err := tm.db.BeginEx(ctx, nil)
+	if tm.Observe != nil {
+		tm.Observe(QueryEvent{Context: ctx, Method: "TxManager.Begin", SQL: "BEGIN", Duration: time.Since(started), Err: err, Waited: waited})
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(newTx(Instrument(tx, tm.Observe))); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.CommitEx(ctx)
+}
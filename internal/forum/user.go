@@ -1,20 +1,21 @@
 package forum
 
 import (
-	"github.com/jackc/pgx"
+	"context"
+	"tech-db/internal/sqlb"
 )
 
 type UserService struct {
-	db *pgx.ConnPool
+	db Querier
 }
 
-func NewUserService(db *pgx.ConnPool) *UserService {
+func NewUserService(db Querier) *UserService {
 	return &UserService{db: db}
 }
 
-func (us *UserService) SelectUserByNickNameOrEmail(nickName, email string) (users []User, err error) {
+func (us *UserService) SelectUserByNickNameOrEmail(ctx context.Context, nickName, email string) (users []User, err error) {
 	sqlQuery := `SELECT id, nick_name, email, full_name, about FROM "user" where nick_name=$1 or email=$2`
-	rows, err := us.db.Query(sqlQuery, nickName, email)
+	rows, err := us.db.QueryEx(ctx, sqlQuery, nil, nickName, email)
 	if err != nil {
 		return users, err
 	}
@@ -32,66 +33,45 @@ func (us *UserService) SelectUserByNickNameOrEmail(nickName, email string) (user
 	return users, nil
 }
 
-func (us *UserService) SelectUserByNickName(nickName string) (user User, err error) {
+func (us *UserService) SelectUserByNickName(ctx context.Context, nickName string) (user User, err error) {
 	sqlQuery := `SELECT u.nick_name, u.email, u.full_name, u.about FROM "user" as u where u.nick_name=$1`
-	err = us.db.QueryRow(sqlQuery, nickName).Scan(&user.NickName, &user.Email, &user.FullName, &user.About)
+	err = us.db.QueryRowEx(ctx, sqlQuery, nil, nickName).Scan(&user.NickName, &user.Email, &user.FullName, &user.About)
 	return
 }
 
-func (us *UserService) SelectUsersByForum(forumId int, limit int, since string, desc string) (users []User, err error) {
-	var rows *pgx.Rows
-	if since == "" {
-		if desc == "false" {
-			sqlQuery := `
-		SELECT u.nick_name, u.email, u.full_name, u.about
-		FROM "user" as u
-		JOIN forum_user as fu ON fu.user_id=u.id
-		WHERE fu.forum_id=$1
-		ORDER BY nick_name COLLATE "C" ASC
-		LIMIT $2`
-			rows, err = us.db.Query(sqlQuery, forumId, limit)
-			if err != nil {
-				return
-			}
-		} else {
-			sqlQuery := `
-		SELECT u.nick_name, u.email, u.full_name, u.about
-		FROM "user" as u
-		JOIN forum_user as fu ON fu.user_id=u.id
-		WHERE fu.forum_id=$1
-		ORDER BY nick_name COLLATE "C" DESC
-		LIMIT $2`
-			rows, err = us.db.Query(sqlQuery, forumId, limit)
-			if err != nil {
-				return
-			}
-		}
-	} else {
-		if desc == "false" {
-			sqlQuery := `
-		SELECT u.nick_name, u.email, u.full_name, u.about
-		FROM "user" as u
-		JOIN forum_user as fu ON fu.user_id=u.id
-		WHERE fu.forum_id=$1 AND nick_name>$3
-		ORDER BY nick_name COLLATE "C" ASC
-		LIMIT $2`
-			rows, err = us.db.Query(sqlQuery, forumId, limit, since)
-			if err != nil {
-				return
-			}
-		} else {
-			sqlQuery := `
-		SELECT u.nick_name, u.email, u.full_name, u.about
-		FROM "user" as u
+// SelectUsersByForum lists the users who posted in a forum by nickname,
+// compared as the "C" collation does. Since is a nickname the page starts
+// after.
+func (us *UserService) SelectUsersByForum(ctx context.Context, forumId int, page Page) (users []User, err error) {
+	if err := page.Check(); err != nil {
+		return nil, err
+	}
+	var key interface{}
+	back := false
+	if page.After != nil {
+		key, back = page.After.Key, page.After.Back
+	} else if page.Since != "" {
+		key = page.Since
+	}
+	scanDesc := page.Desc != back
+	q := sqlb.New(`
+	SELECT u.nick_name, u.email, u.full_name, u.about
+	FROM (
+		SELECT u.* FROM "user" as u
 		JOIN forum_user as fu ON fu.user_id=u.id
-		WHERE fu.forum_id=$1 AND nick_name<$3
-		ORDER BY nick_name COLLATE "C" DESC
-		LIMIT $2`
-			rows, err = us.db.Query(sqlQuery, forumId, limit, since)
-			if err != nil {
-				return
-			}
-		}
+		WHERE fu.forum_id = `).Arg(forumId)
+	if key != nil {
+		q.SQL(` AND u.nick_name COLLATE "C" `).After(scanDesc).SQL(` `).Arg(key).SQL(`::citext COLLATE "C"`)
+	}
+	q.SQL(`
+		ORDER BY u.nick_name COLLATE "C" `).Order(scanDesc).SQL(`
+		LIMIT `).Arg(page.Limit).SQL(`
+	) as u
+	ORDER BY u.nick_name COLLATE "C" `).Order(page.Desc)
+	sqlQuery, args := q.Build()
+	rows, err := us.db.QueryEx(ctx, sqlQuery, nil, args...)
+	if err != nil {
+		return
 	}
 
 	defer rows.Close()
@@ -104,29 +84,47 @@ func (us *UserService) SelectUsersByForum(forumId int, limit int, since string,
 		}
 		users = append(users, user)
 	}
-	return users, nil
+	return users, rows.Err()
 }
 
-func (us *UserService) InsertUser(user User) error {
+func (us *UserService) InsertUser(ctx context.Context, user User) error {
 	sqlQuery := `INSERT INTO "user" (nick_name, email, full_name, about) VALUES ($1, $2, $3, $4)`
-	_, err := us.db.Exec(sqlQuery, user.NickName, user.Email, user.FullName, user.About)
+	_, err := us.db.ExecEx(ctx, sqlQuery, nil, user.NickName, user.Email, user.FullName, user.About)
 	if err != nil {
 		return err
 	}
-	return nil
+	return appendChange(ctx, us.db, ChangeUserInserted, user.NickName, user)
 }
 
-func (us *UserService) UpdateUser(user User) error {
+func (us *UserService) UpdateUser(ctx context.Context, user User) error {
 	sqlQuery := `UPDATE "user" SET email=$1, full_name=$2, about=$3 WHERE id=$4`
-	_, err := us.db.Exec(sqlQuery, user.Email, user.FullName, user.About, user.Id)
-	if err != nil {
+	result, err := us.db.ExecEx(ctx, sqlQuery, nil, user.Email, user.FullName, user.About, user.Id)
+	if err != nil || result.RowsAffected() == 0 {
 		return err
 	}
-	return nil
+	return appendChange(ctx, us.db, ChangeUserUpdated, user.NickName, user)
 }
 
-func (us *UserService) FindUserByNickName(nickName string) (user User, err error) {
+func (us *UserService) FindUserByNickName(ctx context.Context, nickName string) (user User, err error) {
 	sqlQuery := `SELECT u.id, u.nick_name FROM "user" as u where u.nick_name=$1`
-	err = us.db.QueryRow(sqlQuery, nickName).Scan(&user.Id, &user.NickName)
+	err = us.db.QueryRowEx(ctx, sqlQuery, nil, nickName).Scan(&user.Id, &user.NickName)
+	return
+}
+
+// UpdatePassword sets the user's password hash. Its user.password change
+// carries no data, so the hash stays out of the change feed.
+func (us *UserService) UpdatePassword(ctx context.Context, nickName string, hash string) error {
+	sqlQuery := `UPDATE "user" SET password_hash=$1 WHERE nick_name=$2 RETURNING nick_name`
+	if err := us.db.QueryRowEx(ctx, sqlQuery, nil, hash, nickName).Scan(&nickName); err != nil {
+		return err
+	}
+	return appendChange(ctx, us.db, ChangeUserPassword, nickName, struct{}{})
+}
+
+// SelectPasswordHash returns the user's password hash, which is empty if
+// they never set a password.
+func (us *UserService) SelectPasswordHash(ctx context.Context, nickName string) (hash string, err error) {
+	sqlQuery := `SELECT COALESCE(u.password_hash, '') FROM "user" as u where u.nick_name=$1`
+	err = us.db.QueryRowEx(ctx, sqlQuery, nil, nickName).Scan(&hash)
 	return
 }
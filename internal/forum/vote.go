@@ -0,0 +1,26 @@
+package forum
+
+import "context"
+
+// CastVote records vote.Voice as vote.UserId's vote on vote.ThreadId and
+// adjusts the thread's vote count, flipping a previous opposite vote. It
+// should run inside a transaction.
+func CastVote(ctx context.Context, threads ThreadRepository, vote Vote) error {
+	previous, err := threads.SelectVote(ctx, vote)
+	if err != nil {
+		if err != ErrNotFound {
+			return err
+		}
+		if err = threads.InsertVote(ctx, vote); err != nil {
+			return err
+		}
+		return threads.UpdateVoteCount(ctx, vote)
+	}
+
+	if _, err = threads.UpdateVote(ctx, vote); err != nil {
+		return err
+	}
+	delta := vote
+	delta.Voice = vote.Voice - previous.Voice
+	return threads.UpdateVoteCount(ctx, delta)
+}
@@ -0,0 +1,261 @@
+package forum
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Webhook events.
+const (
+	EventThreadCreated = "thread.created"
+	EventPostCreated   = "post.created"
+	EventPostEdited    = "post.edited"
+	EventVoteCast      = "vote.cast"
+	EventUserCreated   = "user.created"
+)
+
+// WebhookEvents lists every event a webhook may subscribe to.
+var WebhookEvents = []string{EventThreadCreated, EventPostCreated, EventPostEdited, EventVoteCast, EventUserCreated}
+
+// IsWebhookEvent reports whether event is one of WebhookEvents.
+func IsWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that is sent the events of a forum it subscribes to.
+// Users belong to no forum, so user.created goes to every webhook that
+// subscribes to it. The secret is only shown when the webhook is created.
+type Webhook struct {
+	Id      int       `json:"id"`
+	Forum   string    `json:"forum"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Subscribes reports whether the webhook is sent event.
+func (w Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event queued for a webhook, and how sending it has
+// gone so far.
+type WebhookDelivery struct {
+	Id           int64           `json:"id"`
+	Webhook      int             `json:"webhook"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Created      time.Time       `json:"created"`
+	Delivered    *time.Time      `json:"delivered,omitempty"`
+}
+
+// WebhookPayload is the body sent to a webhook.
+type WebhookPayload struct {
+	Event   string      `json:"event"`
+	Forum   string      `json:"forum,omitempty"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+// VoteCast is the data of a vote.cast event: the vote and the thread's
+// new vote count.
+type VoteCast struct {
+	Thread   int    `json:"thread"`
+	NickName string `json:"nickname"`
+	Voice    int    `json:"voice"`
+	Votes    int    `json:"votes"`
+}
+
+// EnqueueEvent queues event, with data as its payload, for the webhooks of
+// forum that subscribe to it. Run it in the transaction making the change
+// so that the event is sent if and only if the change is committed.
+func EnqueueEvent(ctx context.Context, hooks WebhookRepository, forum, event string, data interface{}) error {
+	payload, err := json.Marshal(WebhookPayload{Event: event, Forum: forum, Created: time.Now(), Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = hooks.EnqueueDelivery(ctx, forum, event, payload)
+	return err
+}
+
+type WebhookService struct {
+	db Querier
+}
+
+func NewWebhookService(db Querier) *WebhookService {
+	return &WebhookService{db: db}
+}
+
+// InsertWebhook registers hook and returns it with its id and creation
+// time. An unknown forum is ErrNotFound.
+func (ws *WebhookService) InsertWebhook(ctx context.Context, hook Webhook) (Webhook, error) {
+	sqlQuery := `
+	WITH f AS (
+		SELECT id, slug FROM forum WHERE slug=$1
+	), inserted AS (
+		INSERT INTO webhook (forum_id, url, secret, events)
+		SELECT f.id, $2, $3, $4::text[] FROM f
+		RETURNING id, created
+	)
+	SELECT inserted.id, inserted.created, f.slug FROM inserted, f`
+	err := ws.db.QueryRowEx(ctx, sqlQuery, nil, hook.Forum, hook.URL, hook.Secret, hook.Events).Scan(&hook.Id, &hook.Created, &hook.Forum)
+	return hook, err
+}
+
+func (ws *WebhookService) SelectWebhooks(ctx context.Context, forum string) (hooks []Webhook, err error) {
+	sqlQuery := `
+	SELECT w.id, f.slug, w.url, w.secret, w.events, w.created
+	FROM webhook AS w
+	JOIN forum AS f ON f.id=w.forum_id
+	WHERE f.slug=$1
+	ORDER BY w.id`
+	rows, err := ws.db.QueryEx(ctx, sqlQuery, nil, forum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook := Webhook{}
+		if err = rows.Scan(&hook.Id, &hook.Forum, &hook.URL, &hook.Secret, &hook.Events, &hook.Created); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (ws *WebhookService) SelectWebhook(ctx context.Context, id int) (hook Webhook, err error) {
+	sqlQuery := `
+	SELECT w.id, f.slug, w.url, w.secret, w.events, w.created
+	FROM webhook AS w
+	JOIN forum AS f ON f.id=w.forum_id
+	WHERE w.id=$1`
+	err = ws.db.QueryRowEx(ctx, sqlQuery, nil, id).Scan(&hook.Id, &hook.Forum, &hook.URL, &hook.Secret, &hook.Events, &hook.Created)
+	return
+}
+
+// DeleteWebhook removes the webhook and its deliveries.
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, id int) error {
+	result, err := ws.db.ExecEx(ctx, `DELETE FROM webhook WHERE id=$1`, nil, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnqueueDelivery queues payload for every webhook of forum subscribing to
+// event, or of all forums when forum is empty, and returns how many
+// deliveries were queued.
+func (ws *WebhookService) EnqueueDelivery(ctx context.Context, forum, event string, payload []byte) (int, error) {
+	sqlQuery := `
+	INSERT INTO webhook_delivery (webhook_id, event, payload)
+	SELECT w.id, $2, $3::jsonb
+	FROM webhook AS w
+	JOIN forum AS f ON f.id=w.forum_id
+	WHERE ($1::citext = '' OR f.slug=$1::citext) AND $2 = ANY (w.events)`
+	result, err := ws.db.ExecEx(ctx, sqlQuery, nil, forum, event, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
+
+const deliveryColumns = `id, webhook_id, event, payload::text, status, attempts, next_attempt, response_code, error, created, delivered`
+
+func scanDeliveries(rows *pgx.Rows) (deliveries []WebhookDelivery, err error) {
+	for rows.Next() {
+		d := WebhookDelivery{}
+		var payload string
+		err = rows.Scan(&d.Id, &d.Webhook, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttempt, &d.ResponseCode, &d.Error, &d.Created, &d.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimDeliveries takes up to limit pending deliveries due by now, oldest
+// first, and moves their next attempt lease past now so that no other
+// worker takes them meanwhile. A worker that dies holding them leaves them
+// to be claimed again once the lease is over.
+func (ws *WebhookService) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	sqlQuery := `
+	UPDATE webhook_delivery SET next_attempt=$2
+	WHERE id IN (
+		SELECT id FROM webhook_delivery
+		WHERE status='pending' AND next_attempt <= $1
+		ORDER BY next_attempt, id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + deliveryColumns
+	rows, err := ws.db.QueryEx(ctx, sqlQuery, nil, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries, err := scanDeliveries(rows)
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Id < deliveries[j].Id })
+	return deliveries, err
+}
+
+// UpdateDelivery records the outcome of an attempt at d.
+func (ws *WebhookService) UpdateDelivery(ctx context.Context, d WebhookDelivery) error {
+	sqlQuery := `
+	UPDATE webhook_delivery
+	SET status=$2, attempts=$3, next_attempt=$4, response_code=$5, error=$6, delivered=$7
+	WHERE id=$1`
+	result, err := ws.db.ExecEx(ctx, sqlQuery, nil, d.Id, d.Status, d.Attempts, d.NextAttempt, d.ResponseCode, d.Error, d.Delivered)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SelectDeliveries lists up to limit deliveries of a webhook, newest first.
+func (ws *WebhookService) SelectDeliveries(ctx context.Context, webhook int, limit int) ([]WebhookDelivery, error) {
+	sqlQuery := `SELECT ` + deliveryColumns + ` FROM webhook_delivery WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2`
+	rows, err := ws.db.QueryEx(ctx, sqlQuery, nil, webhook, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
@@ -0,0 +1,226 @@
+// Package live pushes changes to threads to the clients watching them: new
+// posts, edits and votes. A Hub fans events out to the subscribers of this
+// process, and a Relay can carry them to the hubs of other instances.
+package live
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"tech-db/internal/forum"
+)
+
+// Event types.
+const (
+	PostCreated  = "post"
+	PostEdited   = "edit"
+	VotesChanged = "vote"
+)
+
+// Event is a change to a thread. Post events carry the post, or Posts for
+// a batch of new posts, and vote events the thread's new vote count.
+type Event struct {
+	Type   string       `json:"type"`
+	Forum  string       `json:"forum"`
+	Thread int          `json:"thread"`
+	Post   *forum.Post  `json:"post,omitempty"`
+	Posts  []forum.Post `json:"posts,omitempty"`
+	Votes  *int         `json:"votes,omitempty"`
+}
+
+// Split returns the events e stands for: one per post of a batch, or e
+// itself. A batch goes through hubs and relays as one event, and feeds
+// split it so that clients see a post event for each post.
+func (e Event) Split() []Event {
+	if e.Posts == nil {
+		return []Event{e}
+	}
+	events := make([]Event, len(e.Posts))
+	for i := range e.Posts {
+		events[i] = Event{Type: e.Type, Forum: e.Forum, Thread: e.Thread, Post: &e.Posts[i]}
+	}
+	return events
+}
+
+// Filter selects the events of one thread or, when Thread is 0, of every
+// thread in a forum.
+type Filter struct {
+	Forum  string
+	Thread int
+}
+
+func (f Filter) match(e Event) bool {
+	if f.Thread != 0 {
+		return e.Thread == f.Thread
+	}
+	return strings.EqualFold(e.Forum, f.Forum)
+}
+
+// Relay carries events published on one instance to the others, which
+// deliver them to their own subscribers.
+type Relay interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// bufferSize is how many events a subscriber may fall behind by before it
+// is dropped.
+const bufferSize = 64
+
+// relayQueueSize is how many events may wait to be relayed before Publish
+// drops them.
+const relayQueueSize = 1024
+
+// ErrRelayBehind is reported when an event is not relayed because too many
+// are waiting already.
+var ErrRelayBehind = errors.New("live: relay queue full, event not relayed")
+
+type Hub struct {
+	// Relay, when set, is sent every event published.
+	Relay Relay
+	// OnError is called with the errors of the relay.
+	OnError func(err error)
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+
+	relayOnce sync.Once
+	closeOnce sync.Once
+	queue     chan Event
+	done      chan struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs:  map[*Subscription]struct{}{},
+		queue: make(chan Event, relayQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// Subscription receives the events matching its filter until it is closed.
+// A subscriber that falls too far behind is closed by the hub, so its
+// channel closes and the client has to reconnect.
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan Event
+}
+
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{hub: h, filter: f, events: make(chan Event, bufferSize)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// remove closes s if it is still subscribed. The caller holds h.mu.
+func (h *Hub) remove(s *Subscription) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// Close ends every subscription, so that feeds finish before shutdown, and
+// stops relaying. Events still waiting to be relayed are dropped.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		h.remove(s)
+	}
+}
+
+// Publish delivers e to the subscribers of this instance and queues it to
+// be sent through the relay to the others, so it never waits on the relay.
+// It is a no-op on a nil hub, so handlers work without one.
+func (h *Hub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	h.Deliver(e)
+	if h.Relay == nil {
+		return
+	}
+	h.relayOnce.Do(func() { go h.relay() })
+	select {
+	case h.queue <- e:
+	default:
+		h.report(ErrRelayBehind)
+	}
+}
+
+// relay sends the queued events through the relay, in order, until the
+// hub is closed. The requests that published them may be over, so the
+// sends are not tied to them.
+func (h *Hub) relay() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case e := <-h.queue:
+			if err := h.Relay.Send(context.Background(), e); err != nil {
+				h.report(err)
+			}
+		}
+	}
+}
+
+func (h *Hub) report(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+// Deliver hands e to the subscribers of this instance without relaying it.
+// Relays call it with the events of other instances.
+func (h *Hub) Deliver(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			h.remove(s)
+		}
+	}
+}
+
+// Posted is the event of a new post.
+func Posted(p forum.Post) Event {
+	return Event{Type: PostCreated, Forum: p.Forum, Thread: p.Thread, Post: &p}
+}
+
+// PostedBatch is the event of posts, at least one, created together in one
+// thread.
+func PostedBatch(posts []forum.Post) Event {
+	return Event{Type: PostCreated, Forum: posts[0].Forum, Thread: posts[0].Thread, Posts: posts}
+}
+
+// Edited is the event of an edit to a post.
+func Edited(p forum.Post) Event {
+	return Event{Type: PostEdited, Forum: p.Forum, Thread: p.Thread, Post: &p}
+}
+
+// Voted is the event of a change to a thread's votes.
+func Voted(t forum.Thread) Event {
+	votes := t.Votes
+	return Event{Type: VotesChanged, Forum: t.Forum, Thread: t.Id, Votes: &votes}
+}
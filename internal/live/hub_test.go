@@ -0,0 +1,147 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+type relayFunc func(ctx context.Context, e Event) error
+
+func (f relayFunc) Send(ctx context.Context, e Event) error { return f(ctx, e) }
+
+func TestHubFilters(t *testing.T) {
+	h := NewHub()
+	thread := h.Subscribe(Filter{Thread: 1})
+	defer thread.Close()
+	golang := h.Subscribe(Filter{Forum: "GoLang"})
+	defer golang.Close()
+
+	h.Publish(Posted(forum.Post{Id: 10, Forum: "golang", Thread: 1}))
+	h.Publish(Voted(forum.Thread{Id: 2, Forum: "golang", Votes: 3}))
+	h.Publish(Edited(forum.Post{Id: 11, Forum: "rust", Thread: 3}))
+
+	if e := <-thread.Events(); e.Type != PostCreated || e.Post.Id != 10 {
+		t.Errorf("thread subscriber got %+v", e)
+	}
+	if e := <-golang.Events(); e.Type != PostCreated || e.Thread != 1 {
+		t.Errorf("forum subscriber got %+v first", e)
+	}
+	if e := <-golang.Events(); e.Type != VotesChanged || *e.Votes != 3 {
+		t.Errorf("forum subscriber got %+v second", e)
+	}
+	if len(thread.Events()) != 0 || len(golang.Events()) != 0 {
+		t.Error("events of other threads and forums were delivered")
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	h := NewHub()
+	slow := h.Subscribe(Filter{Thread: 1})
+	for i := 0; i <= bufferSize; i++ {
+		h.Deliver(Event{Thread: 1})
+	}
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, bufferSize)
+	}
+	slow.Close()
+
+	again := h.Subscribe(Filter{Thread: 1})
+	again.Close()
+	again.Close()
+	if _, ok := <-again.Events(); ok {
+		t.Error("closed subscription still receives")
+	}
+
+	open := h.Subscribe(Filter{Forum: "golang"})
+	h.Close()
+	if _, ok := <-open.Events(); ok {
+		t.Error("subscription still open after the hub closed")
+	}
+	open.Close()
+}
+
+func TestHubRelay(t *testing.T) {
+	sent := make(chan Event, 1)
+	reported := make(chan error, 1)
+	release := make(chan struct{})
+	h := NewHub()
+	h.Relay = relayFunc(func(ctx context.Context, e Event) error {
+		<-release
+		sent <- e
+		return errors.New("relay down")
+	})
+	h.OnError = func(err error) { reported <- err }
+	defer h.Close()
+
+	// Publish returns while the relay is still busy
+	h.Publish(Posted(forum.Post{Id: 1, Thread: 1}))
+	h.Deliver(Posted(forum.Post{Id: 2, Thread: 1}))
+	close(release)
+	select {
+	case e := <-sent:
+		if e.Post.Id != 1 {
+			t.Errorf("relayed %+v, want the published event", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("published event not relayed")
+	}
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Error("relay error not reported")
+	}
+	if len(sent) != 0 {
+		t.Errorf("relayed a delivered event: %+v", <-sent)
+	}
+
+	var nilHub *Hub
+	nilHub.Publish(Event{})
+}
+
+func TestHubBatch(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe(Filter{Thread: 1})
+	defer sub.Close()
+	posts := make([]forum.Post, 10*bufferSize)
+	for i := range posts {
+		posts[i] = forum.Post{Id: i + 1, Forum: "golang", Thread: 1}
+	}
+	h.Publish(PostedBatch(posts))
+
+	e, ok := <-sub.Events()
+	if !ok {
+		t.Fatal("subscriber dropped by a batch")
+	}
+	split := e.Split()
+	if len(split) != len(posts) {
+		t.Fatalf("batch split into %d events, want %d", len(split), len(posts))
+	}
+	for i, e := range split {
+		if e.Type != PostCreated || e.Thread != 1 || e.Post.Id != i+1 || e.Posts != nil {
+			t.Fatalf("event %d of the batch = %+v", i, e)
+		}
+	}
+	if single := Voted(forum.Thread{Id: 1}).Split(); len(single) != 1 || single[0].Type != VotesChanged {
+		t.Errorf("split of a single event = %+v", single)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	var posts []forum.Post
+	for _, id := range []int{7, 3, 4, 5, 9, 10, 12, 4} {
+		posts = append(posts, forum.Post{Id: id})
+	}
+	want := [][2]int{{3, 5}, {7, 7}, {9, 10}, {12, 12}}
+	if got := runs(posts); !reflect.DeepEqual(got, want) {
+		t.Errorf("runs = %v, want %v", got, want)
+	}
+}
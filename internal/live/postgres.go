@@ -0,0 +1,181 @@
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx"
+	"tech-db/internal/forum"
+)
+
+// notifyChannel is the Postgres channel instances exchange events on.
+const notifyChannel = "live_events"
+
+// retryDelay is how long Listen waits before listening again after its
+// connection failed.
+const retryDelay = time.Second
+
+// PGRelay fans events out across instances with LISTEN/NOTIFY. A
+// notification names the post rather than carrying it, since posts may
+// not fit in a notification's 8000 bytes, and receivers read it back. A
+// batch of posts is named by runs of consecutive ids, which a batch mostly
+// is, and split across notifications when there are too many runs.
+type PGRelay struct {
+	pool   *pgx.ConnPool
+	db     forum.Querier
+	posts  forum.PostRepository
+	origin string
+}
+
+// NewPGRelay sends notifications through db and listens on a connection of
+// pool.
+func NewPGRelay(pool *pgx.ConnPool, db forum.Querier, posts forum.PostRepository) *PGRelay {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	return &PGRelay{pool: pool, db: db, posts: posts, origin: hex.EncodeToString(origin)}
+}
+
+// maxRuns is how many runs of post ids a notification carries at most,
+// which keeps it well under 8000 bytes.
+const maxRuns = 256
+
+type notification struct {
+	Origin string   `json:"o"`
+	Type   string   `json:"t"`
+	Forum  string   `json:"f"`
+	Thread int      `json:"th"`
+	Post   int      `json:"p,omitempty"`
+	Runs   [][2]int `json:"r,omitempty"`
+	Votes  *int     `json:"v,omitempty"`
+}
+
+func (r *PGRelay) Send(ctx context.Context, e Event) error {
+	n := notification{Origin: r.origin, Type: e.Type, Forum: e.Forum, Thread: e.Thread, Votes: e.Votes}
+	if e.Post != nil {
+		n.Post = e.Post.Id
+	}
+	if e.Posts == nil {
+		return r.notify(ctx, n)
+	}
+	all := runs(e.Posts)
+	for len(all) > 0 {
+		end := maxRuns
+		if end > len(all) {
+			end = len(all)
+		}
+		n.Runs, all = all[:end], all[end:]
+		if err := r.notify(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PGRelay) notify(ctx context.Context, n notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecEx(ctx, `SELECT pg_notify($1, $2)`, nil, notifyChannel, string(payload))
+	return err
+}
+
+// runs returns the ids of posts as runs of consecutive ids, each from its
+// first to its last id.
+func runs(posts []forum.Post) [][2]int {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.Id
+	}
+	sort.Ints(ids)
+	var all [][2]int
+	for _, id := range ids {
+		if last := len(all) - 1; last >= 0 && all[last][1]+1 >= id {
+			all[last][1] = id
+			continue
+		}
+		all = append(all, [2]int{id, id})
+	}
+	return all
+}
+
+// Listen delivers the events other instances send to hub until ctx is
+// done. When its connection fails it reports the error to hub.OnError and
+// listens again.
+func (r *PGRelay) Listen(ctx context.Context, hub *Hub) {
+	for {
+		err := r.listen(ctx, hub)
+		if ctx.Err() != nil {
+			return
+		}
+		if hub.OnError != nil {
+			hub.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+func (r *PGRelay) listen(ctx context.Context, hub *Hub) error {
+	conn, err := r.pool.AcquireEx(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.pool.Release(conn)
+	if err := conn.Listen(notifyChannel); err != nil {
+		return err
+	}
+	defer conn.Unlisten(notifyChannel)
+
+	for {
+		received, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var n notification
+		if err := json.Unmarshal([]byte(received.Payload), &n); err != nil {
+			if hub.OnError != nil {
+				hub.OnError(err)
+			}
+			continue
+		}
+		if n.Origin == r.origin {
+			continue
+		}
+		e := Event{Type: n.Type, Forum: n.Forum, Thread: n.Thread, Votes: n.Votes}
+		if n.Runs != nil {
+			var ids []int
+			for _, run := range n.Runs {
+				for id := run[0]; id <= run[1]; id++ {
+					ids = append(ids, id)
+				}
+			}
+			posts, err := r.posts.SelectPostsByIds(ctx, ids)
+			if err != nil {
+				return err
+			}
+			if len(posts) == 0 {
+				continue
+			}
+			e.Posts = posts
+		}
+		if n.Post != 0 {
+			post, err := r.posts.SelectPostById(ctx, n.Post)
+			if err == forum.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			e.Post = &post
+		}
+		hub.Deliver(e)
+	}
+}
@@ -0,0 +1,139 @@
+// Package logging provides request IDs and structured JSON logging for the
+// handlers and the queries they run.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"tech-db/internal/forum"
+)
+
+const (
+	maxLoggedArgs   = 20
+	maxLoggedArgLen = 100
+)
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware gives every request an ID, reusing a valid incoming
+// X-Request-ID, stores it in the request context and the response headers,
+// and writes an access log line once the request is done.
+func Middleware(logger echo.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" || len(id) > 64 {
+				id = newRequestID()
+			}
+			ctx.SetRequest(req.WithContext(WithRequestID(req.Context(), id)))
+			ctx.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			started := time.Now()
+			err := next(ctx)
+			if err != nil {
+				if he, ok := err.(*echo.HTTPError); !ok || he.Code >= 500 {
+					Error(ctx, err)
+				}
+				ctx.Error(err)
+			}
+
+			logger.Infoj(log.JSON{
+				"request_id": id,
+				"method":     req.Method,
+				"uri":        req.RequestURI,
+				"route":      ctx.Path(),
+				"status":     ctx.Response().Status,
+				"latency_ms": float64(time.Since(started)) / float64(time.Millisecond),
+				"remote_ip":  ctx.RealIP(),
+			})
+			return nil
+		}
+	}
+}
+
+// Error logs err as the cause of a failed request.
+func Error(ctx echo.Context, err error) {
+	ctx.Logger().Errorj(log.JSON{
+		"request_id": RequestID(ctx.Request().Context()),
+		"method":     ctx.Request().Method,
+		"route":      ctx.Path(),
+		"error":      err.Error(),
+	})
+}
+
+// QueryObserver logs failed statements and, when slow is positive, the
+// ones that took at least slow. At debug level every statement is logged.
+func QueryObserver(logger echo.Logger, slow time.Duration) forum.QueryObserver {
+	return func(e forum.QueryEvent) {
+		slowQuery := slow > 0 && e.Duration >= slow
+		failed := e.Err != nil && e.Err != forum.ErrNotFound
+		if !slowQuery && !failed && logger.Level() > log.DEBUG {
+			return
+		}
+
+		entry := log.JSON{
+			"request_id":  RequestID(e.Context),
+			"method":      e.Method,
+			"sql":         e.SQL,
+			"args":        loggedArgs(e.Args),
+			"duration_ms": float64(e.Duration) / float64(time.Millisecond),
+		}
+		switch {
+		case failed:
+			entry["error"] = e.Err.Error()
+			logger.Errorj(entry)
+		case slowQuery:
+			entry["slow"] = true
+			logger.Warnj(entry)
+		default:
+			logger.Debugj(entry)
+		}
+	}
+}
+
+// loggedArgs keeps huge batches and messages out of the log.
+func loggedArgs(args []interface{}) []interface{} {
+	n := len(args)
+	if n > maxLoggedArgs {
+		n = maxLoggedArgs
+	}
+	logged := make([]interface{}, 0, n+1)
+	for _, arg := range args[:n] {
+		if s, ok := arg.(string); ok && len(s) > maxLoggedArgLen {
+			arg = s[:maxLoggedArgLen] + "..."
+		}
+		logged = append(logged, arg)
+	}
+	if len(args) > n {
+		logged = append(logged, fmt.Sprintf("... %d more", len(args)-n))
+	}
+	return logged
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+// Package metrics exposes Prometheus metrics for the HTTP routes, the pgx
+// connection pool and the queries run by the forum services.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"tech-db/internal/forum"
+)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "forum_query_duration_seconds",
+		Help:    "Latency of the statements run by the forum services, by service method.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "forum_query_errors_total",
+		Help: "Statements run by the forum services that failed, by service method.",
+	}, []string{"method"})
+
+	acquireWaits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pgx_pool_acquire_waits_total",
+		Help: "Statements that started while the pool had no idle connection.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requests, requestDuration, queryDuration, queryErrors, acquireWaits)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
+
+// Middleware records a request count and latency for every route.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			started := time.Now()
+			err := next(ctx)
+
+			code := ctx.Response().Status
+			if err != nil {
+				code = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					code = he.Code
+				}
+			}
+			route := ctx.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := ctx.Request().Method
+			requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+			requestDuration.WithLabelValues(route, method).Observe(time.Since(started).Seconds())
+			return err
+		}
+	}
+}
+
+// ObserveQuery is a forum.QueryObserver recording per-method latency.
+func ObserveQuery(e forum.QueryEvent) {
+	queryDuration.WithLabelValues(e.Method).Observe(e.Duration.Seconds())
+	if e.Err != nil && e.Err != forum.ErrNotFound {
+		queryErrors.WithLabelValues(e.Method).Inc()
+	}
+	if e.Waited {
+		acquireWaits.Inc()
+	}
+}
+
+// RegisterPool exports the connection counts of db.
+func RegisterPool(db *pgx.ConnPool) {
+	prometheus.MustRegister(&poolCollector{db: db})
+}
+
+var (
+	maxConnsDesc       = prometheus.NewDesc("pgx_pool_max_connections", "Maximum size of the connection pool.", nil, nil)
+	currentConnsDesc   = prometheus.NewDesc("pgx_pool_current_connections", "Open connections in the pool.", nil, nil)
+	availableConnsDesc = prometheus.NewDesc("pgx_pool_available_connections", "Idle connections in the pool.", nil, nil)
+)
+
+type poolCollector struct {
+	db *pgx.ConnPool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- maxConnsDesc
+	ch <- currentConnsDesc
+	ch <- availableConnsDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+	ch <- prometheus.MustNewConstMetric(maxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConnections))
+	ch <- prometheus.MustNewConstMetric(currentConnsDesc, prometheus.GaugeValue, float64(stat.CurrentConnections))
+	ch <- prometheus.MustNewConstMetric(availableConnsDesc, prometheus.GaugeValue, float64(stat.AvailableConnections))
+}
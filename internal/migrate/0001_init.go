@@ -1,17 +1,11 @@
---
---
-SET statement_timeout = 0;
-SET lock_timeout = 0;
-SET idle_in_transaction_session_timeout = 0;
-SET client_encoding = 'UTF8';
-SET standard_conforming_strings = on;
-SET check_function_bodies = false;
-SET xmloption = content;
-SET client_min_messages = warning;
-SET row_security = off;
+package migrate
 
-
-CREATE EXTENSION citext;
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "init",
+		Up: `
+CREATE EXTENSION IF NOT EXISTS citext;
 
 -- forum
 
@@ -24,9 +18,6 @@ CREATE TABLE forum (
       "user" citext NOT NULL
 );
 
-
-ALTER TABLE forum OWNER TO postgres;
-
 CREATE SEQUENCE forum_id_seq
     AS integer
     START WITH 1
@@ -35,23 +26,16 @@ CREATE SEQUENCE forum_id_seq
     NO MAXVALUE
     CACHE 1;
 
-
-ALTER TABLE forum_id_seq OWNER TO postgres;
 ALTER SEQUENCE forum_id_seq OWNED BY forum.id;
 
-
 CREATE UNIQUE INDEX forum_slug_uindex ON forum USING btree (slug);
 
-
 CREATE TABLE forum_user (
        forum_id integer NOT NULL,
        user_id integer NOT NULL,
        CONSTRAINT forum_user_pk PRIMARY KEY (forum_id, user_id)
 );
 
-
-ALTER TABLE forum_user OWNER TO postgres;
-
 -- post
 
 CREATE TABLE post (
@@ -66,8 +50,6 @@ CREATE TABLE post (
      path bigint[] DEFAULT '{0}'::bigint[] NOT NULL
 );
 
-ALTER TABLE post OWNER TO postgres;
-
 CREATE SEQUENCE post_id_seq
     AS integer
     START WITH 1
@@ -76,10 +58,8 @@ CREATE SEQUENCE post_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER TABLE post_id_seq OWNER TO postgres;
 ALTER SEQUENCE post_id_seq OWNED BY post.id;
 
-
 CREATE INDEX post_author_forum_index ON post USING btree (author, forum);
 CREATE INDEX post_forum_index ON post USING btree (forum);
 CREATE INDEX post_parent_index ON post USING btree (parent);
@@ -99,8 +79,6 @@ CREATE TABLE thread (
        votes integer DEFAULT 0
 );
 
-ALTER TABLE thread OWNER TO postgres;
-
 CREATE SEQUENCE thread_id_seq
     AS integer
     START WITH 1
@@ -109,15 +87,13 @@ CREATE SEQUENCE thread_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER TABLE thread_id_seq OWNER TO postgres;
 ALTER SEQUENCE thread_id_seq OWNED BY thread.id;
 
-
 CREATE INDEX thread_forum_index ON thread USING btree (forum);
 CREATE UNIQUE INDEX thread_id_uindex ON thread USING btree (id);
 CREATE INDEX thread_slug_index ON thread USING btree (slug);
 
---- user
+-- user
 
 CREATE TABLE "user" (
        id integer NOT NULL PRIMARY KEY,
@@ -127,9 +103,6 @@ CREATE TABLE "user" (
        about text
 );
 
-
-ALTER TABLE "user" OWNER TO postgres;
-
 CREATE SEQUENCE user_id_seq
     START WITH 1
     INCREMENT BY 1
@@ -137,14 +110,13 @@ CREATE SEQUENCE user_id_seq
     NO MAXVALUE
     CACHE 1;
 
-
-ALTER TABLE user_id_seq OWNER TO postgres;
 ALTER SEQUENCE user_id_seq OWNED BY "user".id;
 
 CREATE UNIQUE INDEX user_email_uindex ON "user" USING btree (email);
 CREATE UNIQUE INDEX user_nick_name_uindex ON "user" USING btree (nick_name);
 CREATE INDEX user_index ON "user" USING btree (nick_name, email, full_name, about);
 
+-- vote
 
 CREATE TABLE vote (
      user_id integer,
@@ -152,27 +124,13 @@ CREATE TABLE vote (
      thread_id integer NOT NULL
 );
 
-
-ALTER TABLE vote OWNER TO postgres;
-
-----
-
 ALTER TABLE ONLY forum ALTER COLUMN id SET DEFAULT nextval('forum_id_seq'::regclass);
-
 ALTER TABLE ONLY post ALTER COLUMN id SET DEFAULT nextval('post_id_seq'::regclass);
-
 ALTER TABLE ONLY thread ALTER COLUMN id SET DEFAULT nextval('thread_id_seq'::regclass);
-
 ALTER TABLE ONLY "user" ALTER COLUMN id SET DEFAULT nextval('user_id_seq'::regclass);
-
--------
-
-SELECT pg_catalog.setval('forum_id_seq', 1, false);
-
-SELECT pg_catalog.setval('post_id_seq', 1, false);
-
-SELECT pg_catalog.setval('thread_id_seq', 1, false);
-
-SELECT pg_catalog.setval('user_id_seq', 1, false);
-
-------
+`,
+		Down: `
+DROP TABLE vote, "user", thread, post, forum_user, forum;
+`,
+	})
+}
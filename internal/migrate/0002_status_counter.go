@@ -0,0 +1,89 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "status_counter",
+		Up: `
+-- status_counter keeps a row count per table so that the service status is a
+-- single-row read rather than four full scans. Statement-level triggers add
+-- the size of each insert or delete batch; TRUNCATE resets the row.
+
+CREATE TABLE status_counter (
+      name text NOT NULL PRIMARY KEY,
+      value bigint DEFAULT 0 NOT NULL
+);
+
+INSERT INTO status_counter (name, value)
+SELECT 'forum', COUNT(*) FROM forum
+UNION ALL SELECT 'thread', COUNT(*) FROM thread
+UNION ALL SELECT 'post', COUNT(*) FROM post
+UNION ALL SELECT 'user', COUNT(*) FROM "user";
+
+CREATE FUNCTION status_counter_insert() RETURNS trigger AS $$
+BEGIN
+    UPDATE status_counter SET value = value + (SELECT COUNT(*) FROM inserted) WHERE name = TG_ARGV[0];
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION status_counter_delete() RETURNS trigger AS $$
+BEGIN
+    UPDATE status_counter SET value = value - (SELECT COUNT(*) FROM deleted) WHERE name = TG_ARGV[0];
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION status_counter_truncate() RETURNS trigger AS $$
+BEGIN
+    UPDATE status_counter SET value = 0 WHERE name = TG_ARGV[0];
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER forum_status_insert AFTER INSERT ON forum
+    REFERENCING NEW TABLE AS inserted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_insert('forum');
+CREATE TRIGGER forum_status_delete AFTER DELETE ON forum
+    REFERENCING OLD TABLE AS deleted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_delete('forum');
+CREATE TRIGGER forum_status_truncate AFTER TRUNCATE ON forum
+    FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_truncate('forum');
+
+CREATE TRIGGER thread_status_insert AFTER INSERT ON thread
+    REFERENCING NEW TABLE AS inserted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_insert('thread');
+CREATE TRIGGER thread_status_delete AFTER DELETE ON thread
+    REFERENCING OLD TABLE AS deleted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_delete('thread');
+CREATE TRIGGER thread_status_truncate AFTER TRUNCATE ON thread
+    FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_truncate('thread');
+
+CREATE TRIGGER post_status_insert AFTER INSERT ON post
+    REFERENCING NEW TABLE AS inserted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_insert('post');
+CREATE TRIGGER post_status_delete AFTER DELETE ON post
+    REFERENCING OLD TABLE AS deleted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_delete('post');
+CREATE TRIGGER post_status_truncate AFTER TRUNCATE ON post
+    FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_truncate('post');
+
+CREATE TRIGGER user_status_insert AFTER INSERT ON "user"
+    REFERENCING NEW TABLE AS inserted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_insert('user');
+CREATE TRIGGER user_status_delete AFTER DELETE ON "user"
+    REFERENCING OLD TABLE AS deleted FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_delete('user');
+CREATE TRIGGER user_status_truncate AFTER TRUNCATE ON "user"
+    FOR EACH STATEMENT EXECUTE PROCEDURE status_counter_truncate('user');
+`,
+		Down: `
+DROP TRIGGER forum_status_insert ON forum;
+DROP TRIGGER forum_status_delete ON forum;
+DROP TRIGGER forum_status_truncate ON forum;
+DROP TRIGGER thread_status_insert ON thread;
+DROP TRIGGER thread_status_delete ON thread;
+DROP TRIGGER thread_status_truncate ON thread;
+DROP TRIGGER post_status_insert ON post;
+DROP TRIGGER post_status_delete ON post;
+DROP TRIGGER post_status_truncate ON post;
+DROP TRIGGER user_status_insert ON "user";
+DROP TRIGGER user_status_delete ON "user";
+DROP TRIGGER user_status_truncate ON "user";
+DROP FUNCTION status_counter_insert(), status_counter_delete(), status_counter_truncate();
+DROP TABLE status_counter;
+`,
+	})
+}
@@ -0,0 +1,20 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "soft_delete",
+		Up: `
+ALTER TABLE thread ADD COLUMN deleted_at timestamp with time zone, ADD COLUMN deleted_by citext;
+ALTER TABLE post ADD COLUMN deleted_at timestamp with time zone, ADD COLUMN deleted_by citext;
+
+CREATE INDEX thread_forum_live_index ON thread USING btree (forum, created) WHERE deleted_at IS NULL;
+`,
+		Down: `
+DROP INDEX thread_forum_live_index;
+
+ALTER TABLE post DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+ALTER TABLE thread DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+`,
+	})
+}
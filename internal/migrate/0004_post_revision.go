@@ -0,0 +1,24 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "post_revision",
+		Up: `
+-- post_revision keeps every text a post has had. Revision 0 is the original
+-- message, written on the first edit; each edit then adds the next revision.
+
+CREATE TABLE post_revision (
+      post_id integer NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+      revision integer NOT NULL,
+      message text NOT NULL,
+      editor citext NOT NULL,
+      created timestamp with time zone DEFAULT now() NOT NULL,
+      CONSTRAINT post_revision_pk PRIMARY KEY (post_id, revision)
+);
+`,
+		Down: `
+DROP TABLE post_revision;
+`,
+	})
+}
@@ -0,0 +1,49 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "search",
+		Up: `
+-- search holds the english tsvector of a post's message, or of a thread's
+-- title (weight A) and message (weight B). Triggers keep it current on
+-- insert and edit.
+
+ALTER TABLE post ADD COLUMN search tsvector;
+ALTER TABLE thread ADD COLUMN search tsvector;
+
+CREATE FUNCTION post_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search := to_tsvector('english', NEW.message);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION thread_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search := setweight(to_tsvector('english', NEW.title), 'A') || setweight(to_tsvector('english', NEW.message), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_search BEFORE INSERT OR UPDATE OF message ON post
+    FOR EACH ROW EXECUTE PROCEDURE post_search_update();
+CREATE TRIGGER thread_search BEFORE INSERT OR UPDATE OF title, message ON thread
+    FOR EACH ROW EXECUTE PROCEDURE thread_search_update();
+
+UPDATE post SET search = to_tsvector('english', message);
+UPDATE thread SET search = setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', message), 'B');
+
+CREATE INDEX post_search_index ON post USING gin (search);
+CREATE INDEX thread_search_index ON thread USING gin (search);
+`,
+		Down: `
+DROP TRIGGER post_search ON post;
+DROP TRIGGER thread_search ON thread;
+DROP FUNCTION post_search_update(), thread_search_update();
+
+ALTER TABLE post DROP COLUMN search;
+ALTER TABLE thread DROP COLUMN search;
+`,
+	})
+}
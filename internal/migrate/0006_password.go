@@ -0,0 +1,16 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "password",
+		Up: `
+-- password_hash is a bcrypt hash. Users created without a password keep it
+-- NULL and cannot log in.
+ALTER TABLE "user" ADD COLUMN password_hash text;
+`,
+		Down: `
+ALTER TABLE "user" DROP COLUMN password_hash;
+`,
+	})
+}
@@ -0,0 +1,23 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "roles",
+		Up: `
+ALTER TABLE "user" ADD COLUMN is_admin boolean DEFAULT false NOT NULL;
+
+-- forum_moderator lists the users who, besides the forum's owner, may edit
+-- other people's posts and threads in a forum.
+CREATE TABLE forum_moderator (
+       forum_id integer NOT NULL REFERENCES forum (id) ON DELETE CASCADE,
+       user_id integer NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+       CONSTRAINT forum_moderator_pk PRIMARY KEY (forum_id, user_id)
+);
+`,
+		Down: `
+DROP TABLE forum_moderator;
+ALTER TABLE "user" DROP COLUMN is_admin;
+`,
+	})
+}
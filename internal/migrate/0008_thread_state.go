@@ -0,0 +1,22 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "thread_state",
+		Up: `
+-- locked threads take no new posts; archived ones are read-only; pinned
+-- ones are listed first in their forum.
+ALTER TABLE thread
+    ADD COLUMN locked boolean DEFAULT false NOT NULL,
+    ADD COLUMN pinned boolean DEFAULT false NOT NULL,
+    ADD COLUMN archived boolean DEFAULT false NOT NULL;
+
+CREATE INDEX thread_forum_pinned_index ON thread USING btree (forum, created) WHERE pinned AND deleted_at IS NULL;
+`,
+		Down: `
+DROP INDEX thread_forum_pinned_index;
+ALTER TABLE thread DROP COLUMN locked, DROP COLUMN pinned, DROP COLUMN archived;
+`,
+	})
+}
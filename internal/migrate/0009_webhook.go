@@ -0,0 +1,46 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "webhook",
+		Up: `
+-- webhook registers a URL to be sent the listed events of a forum, signed
+-- with secret.
+CREATE TABLE webhook (
+      id serial PRIMARY KEY,
+      forum_id integer NOT NULL REFERENCES forum (id) ON DELETE CASCADE,
+      url text NOT NULL,
+      secret text NOT NULL,
+      events text[] NOT NULL,
+      created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX webhook_forum_index ON webhook USING btree (forum_id);
+
+-- webhook_delivery is the queue of events to send and the log of how
+-- sending them went. Pending deliveries are retried at next_attempt until
+-- they are delivered or fail for good.
+CREATE TABLE webhook_delivery (
+      id bigserial PRIMARY KEY,
+      webhook_id integer NOT NULL REFERENCES webhook (id) ON DELETE CASCADE,
+      event text NOT NULL,
+      payload jsonb NOT NULL,
+      status text DEFAULT 'pending' NOT NULL,
+      attempts integer DEFAULT 0 NOT NULL,
+      next_attempt timestamp with time zone DEFAULT now() NOT NULL,
+      response_code integer DEFAULT 0 NOT NULL,
+      error text DEFAULT '' NOT NULL,
+      created timestamp with time zone DEFAULT now() NOT NULL,
+      delivered timestamp with time zone
+);
+
+CREATE INDEX webhook_delivery_due_index ON webhook_delivery USING btree (next_attempt, id) WHERE status = 'pending';
+CREATE INDEX webhook_delivery_webhook_index ON webhook_delivery USING btree (webhook_id, id);
+`,
+		Down: `
+DROP TABLE webhook_delivery;
+DROP TABLE webhook;
+`,
+	})
+}
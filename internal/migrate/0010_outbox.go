@@ -0,0 +1,49 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "outbox",
+		Up: `
+-- outbox records the changes to users, forums, threads, posts and votes,
+-- each in the transaction that makes it. Rows get their seq from
+-- outbox_sequence when they are read, not when they are written: writers
+-- commit in any order, so a seq taken at insert time could become visible
+-- after a higher one and be skipped by a reader.
+CREATE TABLE outbox (
+      id bigserial PRIMARY KEY,
+      seq bigint UNIQUE,
+      txid bigint DEFAULT txid_current() NOT NULL,
+      kind text NOT NULL,
+      key text NOT NULL,
+      data jsonb NOT NULL,
+      created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX outbox_unsequenced_index ON outbox USING btree (id) WHERE seq IS NULL;
+
+-- outbox_sequence numbers the rows written by transactions older than any
+-- still running, which are all committed or gone, so no row can later
+-- appear before them. Sequencing is serialized by an advisory lock, and
+-- each statement below sees what the previous holder committed.
+CREATE FUNCTION outbox_sequence() RETURNS void AS $$
+DECLARE
+    last bigint;
+BEGIN
+    PERFORM pg_advisory_xact_lock(7310593858020254072);
+    SELECT COALESCE(max(seq), 0) INTO last FROM outbox;
+    UPDATE outbox SET seq = last + numbered.n
+    FROM (
+        SELECT id, row_number() OVER (ORDER BY id) AS n FROM outbox
+        WHERE seq IS NULL AND txid < txid_snapshot_xmin(txid_current_snapshot())
+    ) AS numbered
+    WHERE outbox.id = numbered.id;
+END;
+$$ LANGUAGE plpgsql;
+`,
+		Down: `
+DROP FUNCTION outbox_sequence();
+DROP TABLE outbox;
+`,
+	})
+}
@@ -0,0 +1,75 @@
+package migrate
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "status_shard",
+		Up: `
+-- status_counter spreads each table's count over 16 shard rows. With one row
+-- per table every insert updated that row, so all writers to a table queued
+-- on its lock; now each statement updates a shard picked at random, so
+-- writers rarely meet, and the table stays at 16 rows a name.
+--
+-- The counts are of stored rows: soft-deleted threads and posts are counted
+-- until they are purged, unlike the forum counters, which only count what is
+-- visible.
+ALTER TABLE status_counter DROP CONSTRAINT status_counter_pkey;
+ALTER TABLE status_counter ADD COLUMN shard integer DEFAULT 0 NOT NULL;
+ALTER TABLE status_counter ADD PRIMARY KEY (name, shard);
+
+INSERT INTO status_counter (name, shard, value)
+SELECT c.name, s.shard, 0 FROM status_counter AS c, generate_series(1, 15) AS s (shard);
+
+-- The shard is drawn into a variable: random() in the WHERE clause would be
+-- drawn again for every row.
+CREATE OR REPLACE FUNCTION status_counter_insert() RETURNS trigger AS $$
+DECLARE
+    n bigint;
+    s integer := floor(random() * 16);
+BEGIN
+    SELECT COUNT(*) INTO n FROM inserted;
+    IF n > 0 THEN
+        UPDATE status_counter SET value = value + n WHERE name = TG_ARGV[0] AND shard = s;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION status_counter_delete() RETURNS trigger AS $$
+DECLARE
+    n bigint;
+    s integer := floor(random() * 16);
+BEGIN
+    SELECT COUNT(*) INTO n FROM deleted;
+    IF n > 0 THEN
+        UPDATE status_counter SET value = value - n WHERE name = TG_ARGV[0] AND shard = s;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+`,
+		Down: `
+UPDATE status_counter AS c
+SET value = (SELECT SUM(s.value) FROM status_counter AS s WHERE s.name = c.name)
+WHERE c.shard = 0;
+DELETE FROM status_counter WHERE shard <> 0;
+ALTER TABLE status_counter DROP CONSTRAINT status_counter_pkey;
+ALTER TABLE status_counter DROP COLUMN shard;
+ALTER TABLE status_counter ADD PRIMARY KEY (name);
+
+CREATE OR REPLACE FUNCTION status_counter_insert() RETURNS trigger AS $$
+BEGIN
+    UPDATE status_counter SET value = value + (SELECT COUNT(*) FROM inserted) WHERE name = TG_ARGV[0];
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION status_counter_delete() RETURNS trigger AS $$
+BEGIN
+    UPDATE status_counter SET value = value - (SELECT COUNT(*) FROM deleted) WHERE name = TG_ARGV[0];
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+`,
+	})
+}
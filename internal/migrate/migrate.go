@@ -0,0 +1,223 @@
+package migrate
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// lockKey is the pg_advisory_lock key held while migrations run, so that
+// several instances starting at once apply them one after another.
+const lockKey = 7351620190
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var migrations []Migration
+
+func register(m Migration) {
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+}
+
+// Latest returns the version of the newest embedded migration, which is the
+// schema version this build expects.
+func Latest() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+type Migrator struct {
+	db *pgx.ConnPool
+}
+
+func New(db *pgx.ConnPool) *Migrator {
+	return &Migrator{db: db}
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+func (m *Migrator) Up() (applied []Migration, err error) {
+	err = m.locked(func(conn *pgx.Conn) error {
+		current, err := version(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if mig.Version <= current {
+				continue
+			}
+			err = inTx(conn, func(tx *pgx.Tx) error {
+				if _, err := tx.Exec(mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+				return err
+			})
+			if err != nil {
+				return errors.Wrapf(err, "migration %04d_%s", mig.Version, mig.Name)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return
+}
+
+// Down rolls back the most recently applied migration. It returns false when
+// there is nothing to roll back.
+func (m *Migrator) Down() (reverted Migration, ok bool, err error) {
+	err = m.locked(func(conn *pgx.Conn) error {
+		current, err := version(conn)
+		if err != nil || current == 0 {
+			return err
+		}
+		for _, mig := range migrations {
+			if mig.Version == current {
+				reverted = mig
+			}
+		}
+		if reverted.Version == 0 {
+			return errors.Errorf("database is at version %d which this build does not know", current)
+		}
+		err = inTx(conn, func(tx *pgx.Tx) error {
+			if _, err := tx.Exec(reverted.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version=$1`, reverted.Version)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "revert %04d_%s", reverted.Version, reverted.Name)
+		}
+		ok = true
+		return nil
+	})
+	return
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() (statuses []Status, err error) {
+	err = m.locked(func(conn *pgx.Conn) error {
+		rows, err := conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		appliedAt := map[int]time.Time{}
+		for rows.Next() {
+			var v int
+			var at time.Time
+			if err := rows.Scan(&v, &at); err != nil {
+				return err
+			}
+			appliedAt[v] = at
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, mig := range migrations {
+			at, ok := appliedAt[mig.Version]
+			statuses = append(statuses, Status{Migration: mig, Applied: ok, AppliedAt: at})
+		}
+		return nil
+	})
+	return
+}
+
+// Version returns the highest applied migration, 0 for an empty database.
+func (m *Migrator) Version() (v int, err error) {
+	err = m.withConn(func(conn *pgx.Conn) error {
+		var exists bool
+		if err := conn.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+			return err
+		}
+		v, err = version(conn)
+		return err
+	})
+	return
+}
+
+func (m *Migrator) withConn(fn func(conn *pgx.Conn) error) error {
+	conn, err := m.db.Acquire()
+	if err != nil {
+		return err
+	}
+	defer m.db.Release(conn)
+	return fn(conn)
+}
+
+// locked runs fn on a single connection holding the migration advisory lock,
+// after making sure the schema_migrations table exists.
+func (m *Migrator) locked(fn func(conn *pgx.Conn) error) error {
+	return m.withConn(func(conn *pgx.Conn) error {
+		if _, err := conn.Exec(`SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return errors.Wrap(err, "acquire migration lock")
+		}
+		defer conn.Exec(`SELECT pg_advisory_unlock($1)`, lockKey)
+
+		if err := ensureTable(conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+func ensureTable(conn *pgx.Conn) error {
+	return inTx(conn, func(tx *pgx.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || exists {
+			return err
+		}
+		_, err := tx.Exec(`
+		CREATE TABLE schema_migrations (
+			version integer NOT NULL PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp with time zone DEFAULT now() NOT NULL
+		)`)
+		if err != nil {
+			return err
+		}
+
+		// Databases created from the old db.sql already have the initial
+		// schema, so record it as applied instead of failing on CREATE TABLE.
+		var legacy bool
+		if err := tx.QueryRow(`SELECT to_regclass('forum') IS NOT NULL`).Scan(&legacy); err != nil || !legacy {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migrations[0].Version, migrations[0].Name)
+		return err
+	})
+}
+
+func version(conn *pgx.Conn) (v int, err error) {
+	err = conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	return
+}
+
+func inTx(conn *pgx.Conn, fn func(tx *pgx.Tx) error) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
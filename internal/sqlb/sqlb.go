@@ -0,0 +1,94 @@
+// Package sqlb builds parameterized SQL statements. Statement text has its
+// own type, SQL, which only constants convert to implicitly, and values go
+// in as $n placeholders bound to arguments, so nothing a client sends can
+// change what a statement does. Text is never rewritten after it is
+// written: a ? or $ in it, or in a value, stays as it is.
+package sqlb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SQL is statement text. Untyped string constants convert to it as they
+// are, while a string variable needs an explicit conversion, which should
+// never hold input.
+type SQL string
+
+// Param is an argument bound to a query, written into the statement where
+// it is used. Binding once and writing it several times uses one argument.
+type Param int
+
+func (p Param) String() string {
+	return "$" + strconv.Itoa(int(p))
+}
+
+// Query is a statement being built with its arguments.
+type Query struct {
+	text strings.Builder
+	args []interface{}
+}
+
+// New starts a query with text.
+func New(text SQL) *Query {
+	q := &Query{}
+	return q.SQL(text)
+}
+
+// SQL appends text.
+func (q *Query) SQL(text SQL) *Query {
+	q.text.WriteString(string(text))
+	return q
+}
+
+// Bind binds v to a new placeholder without writing it.
+func (q *Query) Bind(v interface{}) Param {
+	q.args = append(q.args, v)
+	return Param(len(q.args))
+}
+
+// Param appends the placeholder of p.
+func (q *Query) Param(p Param) *Query {
+	q.text.WriteString(p.String())
+	return q
+}
+
+// Arg binds v to a new placeholder and appends it.
+func (q *Query) Arg(v interface{}) *Query {
+	return q.Param(q.Bind(v))
+}
+
+// Args appends a placeholder for each of vs, separated by commas.
+func (q *Query) Args(vs ...interface{}) *Query {
+	for i, v := range vs {
+		if i > 0 {
+			q.text.WriteString(", ")
+		}
+		q.Arg(v)
+	}
+	return q
+}
+
+// Order appends the direction of a scan: DESC when desc is set, ASC
+// otherwise.
+func (q *Query) Order(desc bool) *Query {
+	if desc {
+		return q.SQL("DESC")
+	}
+	return q.SQL("ASC")
+}
+
+// After appends the comparison that selects the rows after a key in a scan
+// in the direction of desc: < when it is set, > otherwise.
+func (q *Query) After(desc bool) *Query {
+	if desc {
+		return q.SQL("<")
+	}
+	return q.SQL(">")
+}
+
+// Build returns the statement and its arguments, in the order of their
+// placeholders.
+func (q *Query) Build() (string, []interface{}) {
+	return q.text.String(), q.args
+}
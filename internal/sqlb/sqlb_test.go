@@ -0,0 +1,59 @@
+package sqlb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	q := New("SELECT p.id FROM post as p WHERE p.thread = ")
+	p := q.Bind(42)
+	q.Param(p).SQL(" AND p.id ").After(true).SQL(" ").Arg(7).
+		SQL(" AND p.parent IN (").Args(1, 2).SQL(")").
+		SQL(" AND p.thread = ").Param(p).
+		SQL(" ORDER BY p.id ").Order(true).SQL(" LIMIT ").Arg(10)
+	sql, args := q.Build()
+	want := "SELECT p.id FROM post as p WHERE p.thread = $1 AND p.id < $2 AND p.parent IN ($3, $4) AND p.thread = $1 ORDER BY p.id DESC LIMIT $5"
+	if sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{42, 7, 1, 2, 10}) {
+		t.Errorf("args = %v", args)
+	}
+
+	sql, _ = New("ORDER BY id ").Order(false).SQL(" WHERE id ").After(false).SQL(" 0").Build()
+	if want := "ORDER BY id ASC WHERE id > 0"; sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+}
+
+// TestValuesStayArguments checks that values meant to break out of their
+// place, or to be mistaken for placeholders, only ever become arguments.
+func TestValuesStayArguments(t *testing.T) {
+	for _, v := range []string{
+		"1; DROP TABLE post; --",
+		"' OR '1'='1",
+		"what? $1 ?",
+		"$$ || pg_sleep(10) || $$",
+	} {
+		sql, args := New("SELECT * FROM post WHERE message = ").Arg(v).SQL(" AND thread = ").Arg(1).Build()
+		if want := "SELECT * FROM post WHERE message = $1 AND thread = $2"; sql != want {
+			t.Errorf("value %q: sql = %q, want %q", v, sql, want)
+		}
+		if len(args) != 2 || args[0] != v {
+			t.Errorf("value %q: args = %v", v, args)
+		}
+	}
+}
+
+// TestTextIsNotRewritten checks that a ? in statement text, such as the
+// jsonb key operator, is left alone rather than taken for a placeholder.
+func TestTextIsNotRewritten(t *testing.T) {
+	sql, args := New("SELECT data ? 'id' FROM outbox WHERE kind = ").Arg("post.inserted").SQL(" AND key <> '?'").Build()
+	if want := "SELECT data ? 'id' FROM outbox WHERE kind = $1 AND key <> '?'"; sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if len(args) != 1 {
+		t.Errorf("args = %v", args)
+	}
+}
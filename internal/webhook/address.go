@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for webhook URLs that point into the
+// network the server runs in rather than out to the internet.
+var ErrForbiddenAddress = errors.New("webhook: address not allowed")
+
+// blocked lists the ranges that are not on the public internet, besides
+// the loopback, link-local and multicast ones net.IP knows of. Cloud
+// metadata services live in link-local 169.254.0.0/16 and, on some clouds,
+// in shared 100.64.0.0/10.
+var blocked = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"240.0.0.0/4",
+		"64:ff9b::/96",
+		"fc00::/7",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// PublicIP reports whether webhooks may be sent to ip: it is none of
+// loopback, private, link-local, multicast, unspecified or otherwise
+// reserved.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range blocked {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL checks that u may be registered as a webhook: an absolute http
+// or https URL whose host is not a forbidden address or a localhost name.
+// Names are only resolved when deliveries are sent, where the Dispatcher
+// checks the addresses they resolve to.
+func CheckURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// checkDial refuses connections to addresses that are not public. It runs
+// as a net.Dialer's Control, after names are resolved, so names that
+// resolve into the local network and redirects to it are caught too.
+func checkDial(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Package webhook sends the events queued for webhooks. Each delivery is
+// a POST of the JSON payload signed with the webhook's secret; failures are
+// retried with exponential backoff until MaxAttempts is reached.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"tech-db/internal/forum"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Forum-Signature"
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+)
+
+// Sign returns the signature of body sent in SignatureHeader: "sha256="
+// and the hex HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one of body, comparing in
+// constant time. Receivers written in Go can use it as is.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Defaults of the Dispatcher settings.
+const (
+	DefaultInterval    = time.Second
+	DefaultBatch       = 50
+	DefaultWorkers     = 10
+	DefaultMaxAttempts = 8
+	DefaultMinBackoff  = 10 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultTimeout     = 10 * time.Second
+	DefaultLease       = time.Minute
+)
+
+// Dispatcher sends due deliveries. Several may run against one database:
+// claimed deliveries are leased to the dispatcher that claimed them for as
+// long as sending the whole batch may take, plus Lease.
+type Dispatcher struct {
+	Webhooks forum.WebhookRepository
+	// Client sends the requests. Each attempt is cut off after its
+	// Timeout, or DefaultTimeout when it has none. The default client
+	// only connects to public addresses.
+	Client *http.Client
+	// Interval is how often Run looks for due deliveries.
+	Interval time.Duration
+	// Batch is how many deliveries are claimed at once.
+	Batch int
+	// Workers is how many deliveries of a batch are sent at the same time.
+	Workers int
+	// MaxAttempts is how many times a delivery is tried before it fails
+	// for good.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry. It doubles with each
+	// attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long a claimed delivery is kept from other dispatchers
+	// beyond the time its batch may take to send.
+	Lease time.Duration
+	// OnError is called with the errors of the queue.
+	OnError func(err error)
+}
+
+func NewDispatcher(hooks forum.WebhookRepository) *Dispatcher {
+	return &Dispatcher{
+		Webhooks:    hooks,
+		Client:      NewClient(DefaultTimeout),
+		Interval:    DefaultInterval,
+		Batch:       DefaultBatch,
+		Workers:     DefaultWorkers,
+		MaxAttempts: DefaultMaxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		Lease:       DefaultLease,
+	}
+}
+
+// NewClient returns a client that times out after timeout and refuses to
+// connect to addresses that are not public, whatever name or redirect led
+// to them.
+func NewClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDial,
+	}).DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Run sends due deliveries every Interval until ctx is done. A full batch
+// is followed by the next one straight away.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.DeliverDue(ctx, time.Now())
+		if err != nil && ctx.Err() == nil && d.OnError != nil {
+			d.OnError(err)
+		}
+		if n == d.Batch && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.Interval):
+		}
+	}
+}
+
+// DeliverDue claims the deliveries due at now, tries each of them and
+// records the outcome. It returns how many it tried.
+func (d *Dispatcher) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	due, err := d.Webhooks.ClaimDeliveries(ctx, now, d.lease(), d.Batch)
+	if err != nil {
+		return 0, err
+	}
+	hooks := map[int]forum.Webhook{}
+	var todo []forum.WebhookDelivery
+	for _, delivery := range due {
+		if _, ok := hooks[delivery.Webhook]; !ok {
+			hook, err := d.Webhooks.SelectWebhook(ctx, delivery.Webhook)
+			if err == forum.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			hooks[hook.Id] = hook
+		}
+		todo = append(todo, delivery)
+	}
+
+	deliveries := make(chan forum.WebhookDelivery)
+	errs := make(chan error, len(todo))
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers() && i < len(todo); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range deliveries {
+				err := d.Webhooks.UpdateDelivery(ctx, d.attempt(ctx, hooks[delivery.Webhook], delivery, now))
+				if err != nil && err != forum.ErrNotFound {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, delivery := range todo {
+		deliveries <- delivery
+	}
+	close(deliveries)
+	wg.Wait()
+	close(errs)
+	return len(due), <-errs
+}
+
+func (d *Dispatcher) workers() int {
+	if d.Workers > 0 {
+		return d.Workers
+	}
+	return 1
+}
+
+func (d *Dispatcher) timeout() time.Duration {
+	if d.Client.Timeout > 0 {
+		return d.Client.Timeout
+	}
+	return DefaultTimeout
+}
+
+// lease is how long claimed deliveries are kept: the time the workers take
+// to send a full batch when every attempt times out, plus Lease.
+func (d *Dispatcher) lease() time.Duration {
+	rounds := (d.Batch + d.workers() - 1) / d.workers()
+	return time.Duration(rounds)*d.timeout() + d.Lease
+}
+
+// attempt sends delivery to hook and returns it updated with the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, hook forum.Webhook, delivery forum.WebhookDelivery, now time.Time) forum.WebhookDelivery {
+	delivery.Attempts++
+	delivery.ResponseCode, delivery.Error = 0, ""
+	code, err := d.send(ctx, hook, delivery)
+	delivery.ResponseCode = code
+	if err == nil {
+		delivered := time.Now()
+		delivery.Status = forum.DeliveryDelivered
+		delivery.Delivered = &delivered
+		return delivery
+	}
+	delivery.Error = err.Error()
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = forum.DeliveryFailed
+		return delivery
+	}
+	delivery.NextAttempt = now.Add(d.Backoff(delivery.Attempts))
+	return delivery
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook forum.Webhook, delivery forum.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tech-db-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, delivery.Payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("%s answered %s", hook.URL, res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Backoff is the wait before retrying a delivery that failed attempts
+// times: MinBackoff doubled for each attempt after the first, up to
+// MaxBackoff.
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	wait := d.MinBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
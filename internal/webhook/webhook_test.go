@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"tech-db/internal/forum"
+	"tech-db/internal/forum/memory"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"event":"post.created"}`)
+	signature := Sign("secret", body)
+	if want := "sha256=5bfab6fc075cfd13eb347eb022171d1fd85adce64716a0568bf15f9feb55c258"; signature != want {
+		t.Fatalf("signature %q, want %q", signature, want)
+	}
+	if !Verify("secret", body, signature) {
+		t.Error("signature does not verify")
+	}
+	if Verify("other", body, signature) || Verify("secret", []byte(`{}`), signature) {
+		t.Error("signature verifies with another secret or body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil)
+	d.MinBackoff, d.MaxBackoff = time.Second, 5*time.Second
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{40, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+type received struct {
+	event, delivery string
+	verified        bool
+}
+
+func TestDeliverDue(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	if err := store.Users.InsertUser(ctx, forum.User{NickName: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Forums.InsertForum(ctx, forum.Forum{Slug: "golang", Title: "Go", User: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []received
+	fail := 1
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, received{r.Header.Get(EventHeader), r.Header.Get(DeliveryHeader), Verify("secret", body, r.Header.Get(SignatureHeader))})
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	hook, err := store.Webhooks.InsertWebhook(ctx, forum.Webhook{Forum: "golang", URL: receiver.URL, Secret: "secret", Events: []string{forum.EventPostCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := forum.EnqueueEvent(ctx, store.Webhooks, "golang", forum.EventPostCreated, forum.Post{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(store.Webhooks)
+	d.Client = &http.Client{Timeout: time.Second} // the receiver is on loopback
+	d.MaxAttempts = 2
+	now := time.Now()
+	if n, err := d.DeliverDue(ctx, now); n != 1 || err != nil {
+		t.Fatalf("first round tried %d: %v", n, err)
+	}
+	log, _ := store.Webhooks.SelectDeliveries(ctx, hook.Id, 10)
+	if len(log) != 1 || log[0].Status != forum.DeliveryPending || log[0].Attempts != 1 || log[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("after a failure %+v", log)
+	}
+	if !log[0].NextAttempt.Equal(now.Add(d.MinBackoff)) {
+		t.Errorf("retry at %s, want %s", log[0].NextAttempt, now.Add(d.MinBackoff))
+	}
+	if n, _ := d.DeliverDue(ctx, now.Add(d.MinBackoff/2)); n != 0 {
+		t.Errorf("retried %d deliveries before the backoff", n)
+	}
+
+	if n, err := d.DeliverDue(ctx, now.Add(d.MinBackoff)); n != 1 || err != nil {
+		t.Fatalf("retry tried %d: %v", n, err)
+	}
+	log, _ = store.Webhooks.SelectDeliveries(ctx, hook.Id, 10)
+	if log[0].Status != forum.DeliveryDelivered || log[0].Attempts != 2 || log[0].ResponseCode != http.StatusOK || log[0].Error != "" || log[0].Delivered == nil {
+		t.Errorf("after the retry %+v", log[0])
+	}
+	if len(got) != 2 {
+		t.Fatalf("receiver got %d requests, want 2", len(got))
+	}
+	for _, r := range got {
+		if r.event != forum.EventPostCreated || r.delivery != "1" || !r.verified {
+			t.Errorf("receiver got %+v", r)
+		}
+	}
+
+	// a receiver that keeps failing is given up on after MaxAttempts
+	fail = d.MaxAttempts
+	if err := forum.EnqueueEvent(ctx, store.Webhooks, "golang", forum.EventPostCreated, forum.Post{Id: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < d.MaxAttempts; i++ {
+		d.DeliverDue(ctx, now.Add(time.Duration(i+1)*time.Hour))
+	}
+	log, _ = store.Webhooks.SelectDeliveries(ctx, hook.Id, 1)
+	if log[0].Status != forum.DeliveryFailed || log[0].Attempts != d.MaxAttempts || log[0].Error == "" {
+		t.Errorf("after %d failures %+v", d.MaxAttempts, log[0])
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, tt := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/forum", true},
+		{"http://93.184.216.34:8080/", true},
+		{"ftp://example.com/", false},
+		{"/relative", false},
+		{"http://LOCALHOST./", false},
+		{"http://api.localhost/", false},
+		{"http://127.0.0.2/", false},
+		{"http://10.1.2.3/", false},
+		{"http://172.16.0.1/", false},
+		{"http://192.168.0.1/", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.100.100.200/", false},
+		{"http://[::1]/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+		{"http://[fe80::1]/", false},
+		{"http://[fd00:ec2::254]/", false},
+	} {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckURL(u); (err == nil) != tt.ok {
+			t.Errorf("CheckURL(%s) = %v, want ok %t", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestDialRefusesLocalAddresses(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	store.Users.InsertUser(ctx, forum.User{NickName: "alice", Email: "alice@example.com"})
+	store.Forums.InsertForum(ctx, forum.Forum{Slug: "golang", Title: "Go", User: "alice"})
+
+	hit := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer receiver.Close()
+	// stored as is, as a name that resolves to loopback would be
+	hook, err := store.Webhooks.InsertWebhook(ctx, forum.Webhook{Forum: "golang", URL: receiver.URL, Secret: "s", Events: []string{forum.EventPostCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forum.EnqueueEvent(ctx, store.Webhooks, "golang", forum.EventPostCreated, forum.Post{Id: 1})
+
+	d := NewDispatcher(store.Webhooks)
+	if n, err := d.DeliverDue(ctx, time.Now()); n != 1 || err != nil {
+		t.Fatalf("tried %d: %v", n, err)
+	}
+	log, _ := store.Webhooks.SelectDeliveries(ctx, hook.Id, 1)
+	if hit || !strings.Contains(log[0].Error, ErrForbiddenAddress.Error()) {
+		t.Errorf("delivery to %s went through: %+v", receiver.URL, log[0])
+	}
+}
+
+func TestLeaseCoversBatch(t *testing.T) {
+	d := NewDispatcher(nil)
+	d.Batch, d.Workers, d.Lease = 50, 10, time.Minute
+	if got, want := d.lease(), 5*DefaultTimeout+time.Minute; got != want {
+		t.Errorf("lease = %s, want %s", got, want)
+	}
+	d.Workers = 0
+	if got, want := d.lease(), 50*DefaultTimeout+time.Minute; got != want {
+		t.Errorf("lease with no workers set = %s, want %s", got, want)
+	}
+}